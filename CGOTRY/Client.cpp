@@ -17,14 +17,32 @@ Engine::~Engine(){
     v8::V8::ShutdownPlatform();
 }
 
-void* Engine::Route(struct metaData metadoc,const char* doc, std::string filename){
+void* Engine::Route(struct metaData metadoc,const char* doc, std::string filename,int timeoutMs){
     auto n= isolateNumber++;
     auto index=n%NumberOfIsolates;
-    return (void*)workers[index]->Map(metadoc,doc,filename);
+    return (void*)workers[index]->Map(metadoc,doc,filename,timeoutMs);
 }
 
-void Engine::Compile(std::string msg,const char* code){
+void Engine::Unload(std::string filename){
     for(int i=0;i<NumberOfIsolates;i++){
-        workers[i]->v8WorkLoad(msg,code);
+        workers[i]->Unload(filename);
     }
 }
+
+int Engine::Compile(std::string msg,const char* code,const char* entryPoint){
+    int compiled=1;
+    for(int i=0;i<NumberOfIsolates;i++){
+        if(!workers[i]->v8WorkLoad(msg,code,entryPoint)){
+            compiled=0;
+        }
+    }
+    return compiled;
+}
+
+validate_response* Engine::Validate(const char* code){
+    auto n= isolateNumber++;
+    auto index=n%NumberOfIsolates;
+    validate_response* resp=new validate_response();
+    workers[index]->Validate(code,resp);
+    return resp;
+}
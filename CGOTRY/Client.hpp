@@ -14,9 +14,11 @@ class Engine{
     v8::Platform* platform;
 public:
     ~Engine();
-    void Compile(std::string msg,const char* code);
+    int Compile(std::string msg,const char* code,const char* entryPoint);
     Engine(int NumberOfIsolates);
-    void* Route(struct metaData metadoc,const char* doc,std::string filename);
+    void* Route(struct metaData metadoc,const char* doc,std::string filename,int timeoutMs=0);
+    void Unload(std::string filename);
+    validate_response* Validate(const char* code);
 private:
     int NumberOfIsolates;
     v8Instance* workers[64];//Array of isolates
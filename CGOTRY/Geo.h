@@ -0,0 +1,280 @@
+#ifndef Geo_h
+#define Geo_h
+
+//Version of the geo namespace, bumped on any change of the cells it
+//returns, as index keys built from them change with it
+#define GEO_VERSION 1
+
+//Cells a cover may have, MAX_COVER_CELLS of the source below. Emitting
+//every cell of a cover, one emit each, takes 3 values per cell, which
+//v8Instance.cpp asserts fits in MAX_EMIT_VALUES
+#define GEO_MAX_COVER_CELLS 1024
+
+//Geohash cells installed as the global "geo" next to helpers and text,
+//for functions emitting spatial keys. Projector/jsgeo.go implements the
+//same computations for the query side, with the same arithmetic: cell
+//bounds are exact, points are placed in cells by bisection and cos is a
+//series of its own, so that both sides return the same cells for the
+//same input. geo_test.js tests them.
+static const char* GEO_SOURCE = R"JS(
+(function(global) {
+    'use strict';
+
+    var VERSION = 1;
+    var BASE32 = '0123456789bcdefghjkmnpqrstuvwxyz';
+    var MAX_PRECISION = 12;
+    var MAX_COVER_CELLS = 1024; // GEO_MAX_COVER_CELLS
+    var EARTH_RADIUS = 6371008.8; // mean, in meters
+    var DEG_PER_RAD = 57.29577951308232;
+    var RAD_PER_DEG = 0.017453292519943295;
+
+    function precisionOf(name, precision) {
+        precision = precision === undefined ? MAX_PRECISION : precision;
+        if (typeof precision !== 'number' || precision % 1 !== 0 || precision < 1 || precision > MAX_PRECISION) {
+            throw new RangeError('geo.' + name + ': precision must be an integer from 1 to ' + MAX_PRECISION);
+        }
+        return precision;
+    }
+
+    function checkPoint(name, lat, lon) {
+        if (typeof lat !== 'number' || !(lat >= -90 && lat <= 90)) {
+            throw new RangeError('geo.' + name + ': latitude must be a number from -90 to 90');
+        }
+        if (typeof lon !== 'number' || !(lon >= -180 && lon <= 180)) {
+            throw new RangeError('geo.' + name + ': longitude must be a number from -180 to 180');
+        }
+    }
+
+    // Bits of longitude and latitude in a geohash of precision characters,
+    // longitude takes the first and every other bit
+    function lonBits(precision) {
+        return Math.ceil(precision * 5 / 2);
+    }
+
+    function latBits(precision) {
+        return Math.floor(precision * 5 / 2);
+    }
+
+    // Index of the cell of value among the 2^bits cells from lo to hi, by
+    // bisection as geohash defines it: values on a boundary go up
+    function cellIndex(value, lo, hi, bits) {
+        var index = 0;
+        for (var i = 0; i < bits; i++) {
+            var mid = (lo + hi) / 2;
+            if (value >= mid) {
+                index = index * 2 + 1;
+                lo = mid;
+            } else {
+                index = index * 2;
+                hi = mid;
+            }
+        }
+        return index;
+    }
+
+    function bit(index, pos) {
+        return Math.floor(index / Math.pow(2, pos)) % 2;
+    }
+
+    function cellHash(lonIndex, latIndex, precision) {
+        var lb = lonBits(precision), ab = latBits(precision), hash = '';
+        for (var c = 0; c < precision; c++) {
+            var ch = 0;
+            for (var k = c * 5; k < c * 5 + 5; k++) {
+                var b = k % 2 === 0 ? bit(lonIndex, lb - 1 - k / 2) : bit(latIndex, ab - 1 - (k - 1) / 2);
+                ch = ch * 2 + b;
+            }
+            hash += BASE32.charAt(ch);
+        }
+        return hash;
+    }
+
+    // encode(lat, lon[, precision]) is the geohash of a point, of 12
+    // characters unless precision is given
+    function encode(lat, lon, precision) {
+        checkPoint('encode', lat, lon);
+        precision = precisionOf('encode', precision);
+        return cellHash(cellIndex(lon, -180, 180, lonBits(precision)),
+            cellIndex(lat, -90, 90, latBits(precision)), precision);
+    }
+
+    // decode(hash) is the cell of a geohash, as {south, west, north, east},
+    // with its center as lat and lon
+    function decode(hash) {
+        if (typeof hash !== 'string' || hash.length < 1 || hash.length > MAX_PRECISION) {
+            throw new RangeError('geo.decode: a geohash has 1 to ' + MAX_PRECISION + ' characters');
+        }
+
+        var lonIndex = 0, latIndex = 0;
+        for (var c = 0; c < hash.length; c++) {
+            var ch = BASE32.indexOf(hash.charAt(c));
+            if (ch < 0) {
+                throw new RangeError('geo.decode: invalid geohash character ' + hash.charAt(c));
+            }
+            for (var k = c * 5; k < c * 5 + 5; k++) {
+                var b = Math.floor(ch / Math.pow(2, 4 - k % 5)) % 2;
+                if (k % 2 === 0) {
+                    lonIndex = lonIndex * 2 + b;
+                } else {
+                    latIndex = latIndex * 2 + b;
+                }
+            }
+        }
+
+        var width = 360 / Math.pow(2, lonBits(hash.length)), height = 180 / Math.pow(2, latBits(hash.length));
+        var cell = {
+            south: -90 + latIndex * height,
+            west: -180 + lonIndex * width,
+            north: -90 + (latIndex + 1) * height,
+            east: -180 + (lonIndex + 1) * width
+        };
+        cell.lat = (cell.south + cell.north) / 2;
+        cell.lon = (cell.west + cell.east) / 2;
+        return cell;
+    }
+
+    // cells(lat, lon[, min[, max]]) are the geohashes of a point from
+    // precision min to max, the cells containing it from the largest to
+    // the smallest. Emitting them all allows lookups at any of those
+    // precisions.
+    function cells(lat, lon, min, max) {
+        min = precisionOf('cells', min === undefined ? 1 : min);
+        max = precisionOf('cells', max === undefined ? MAX_PRECISION : max);
+        if (max < min) {
+            throw new RangeError('geo.cells: max precision is less than min');
+        }
+        var hash = encode(lat, lon, max), out = [];
+        for (var p = min; p <= max; p++) {
+            out.push(hash.substring(0, p));
+        }
+        return out;
+    }
+
+    // The cells of precision intersecting the box, sorted. A box whose west
+    // is greater than its east crosses the antimeridian.
+    function cover(name, south, west, north, east, precision) {
+        checkPoint(name, south, west);
+        checkPoint(name, north, east);
+        if (south > north) {
+            throw new RangeError('geo.' + name + ': south is greater than north');
+        }
+        precision = precisionOf(name, precision);
+
+        var lb = lonBits(precision), ab = latBits(precision), lonCells = Math.pow(2, lb);
+        var j0 = cellIndex(south, -90, 90, ab), j1 = cellIndex(north, -90, 90, ab);
+        var i0 = cellIndex(west, -180, 180, lb), i1 = cellIndex(east, -180, 180, lb);
+        var spans = west <= east ? [[i0, i1]] : [[i0, lonCells - 1], [0, i1]];
+
+        var count = 0;
+        for (var s = 0; s < spans.length; s++) {
+            count += spans[s][1] - spans[s][0] + 1;
+        }
+        if (count * (j1 - j0 + 1) > MAX_COVER_CELLS) {
+            throw new RangeError('geo.' + name + ': more than ' + MAX_COVER_CELLS +
+                ' cells at precision ' + precision + ', use a lower one');
+        }
+
+        var out = [], seen = {};
+        for (var t = 0; t < spans.length; t++) {
+            for (var i = spans[t][0]; i <= spans[t][1]; i++) {
+                for (var j = j0; j <= j1; j++) {
+                    var hash = cellHash(i, j, precision);
+                    if (!seen.hasOwnProperty(hash)) {
+                        seen[hash] = true;
+                        out.push(hash);
+                    }
+                }
+            }
+        }
+        return out.sort();
+    }
+
+    // coverBox(south, west, north, east[, precision]) are the geohashes of
+    // precision, sorted, of the cells intersecting a box, at most 1024
+    function coverBox(south, west, north, east, precision) {
+        return cover('coverBox', south, west, north, east, precision);
+    }
+
+    // cos of x in [0, pi/2], as a fixed series computed the same way as
+    // jsgeo.go does, as Math.cos may differ in its last bit
+    function cos(x) {
+        var x2 = x * x, term = 1, sum = 1;
+        for (var k = 1; k <= 12; k++) {
+            term = term * -x2 / ((2 * k - 1) * (2 * k));
+            sum = sum + term;
+        }
+        return sum;
+    }
+
+    // coverRadius(lat, lon, meters[, precision]) are the cells covering a
+    // box that contains the circle of meters around a point. Some of them
+    // may be out of the circle: filter what they match by distance.
+    function coverRadius(lat, lon, meters, precision) {
+        checkPoint('coverRadius', lat, lon);
+        if (typeof meters !== 'number' || !(meters >= 0) || meters === Infinity) {
+            throw new RangeError('geo.coverRadius: radius must be a number of meters from 0');
+        }
+
+        var dLat = meters / EARTH_RADIUS * DEG_PER_RAD;
+        var south = lat - dLat, north = lat + dLat, west = -180, east = 180;
+        if (south > -90 && north < 90) {
+            var dLon = dLat / cos(Math.max(-south, north) * RAD_PER_DEG);
+            if (dLon < 180) {
+                west = lon - dLon;
+                east = lon + dLon;
+                west = west < -180 ? west + 360 : west;
+                east = east > 180 ? east - 360 : east;
+            }
+        }
+        return cover('coverRadius', Math.max(south, -90), west, Math.min(north, 90), east, precision);
+    }
+
+    function successor(hash) {
+        for (var c = hash.length - 1; c >= 0; c--) {
+            var ch = BASE32.indexOf(hash.charAt(c));
+            if (ch < BASE32.length - 1) {
+                return hash.substring(0, c) + BASE32.charAt(ch + 1);
+            }
+        }
+        return '';
+    }
+
+    // ranges(cells) are the key ranges {low, high} of geohashes, of any
+    // precision, within cells: low included, high excluded or '' for no
+    // upper bound. Adjacent ranges are merged.
+    function ranges(cellList) {
+        if (!Array.isArray(cellList)) {
+            throw new TypeError('geo.ranges: cells must be an array');
+        }
+        var sorted = cellList.slice().sort(), out = [];
+        for (var i = 0; i < sorted.length; i++) {
+            decode(sorted[i]);
+            var last = out.length > 0 ? out[out.length - 1] : null;
+            if (last && (last.high === '' || sorted[i] < last.high)) {
+                continue;
+            }
+            var high = successor(sorted[i]);
+            if (last && last.high === sorted[i]) {
+                last.high = high;
+            } else {
+                out.push({low: sorted[i], high: high});
+            }
+        }
+        return out;
+    }
+
+    var geo = {
+        version: VERSION,
+        encode: encode,
+        decode: decode,
+        cells: cells,
+        coverBox: coverBox,
+        coverRadius: coverRadius,
+        ranges: ranges
+    };
+    Object.freeze(geo);
+    Object.defineProperty(global, 'geo', {value: geo, writable: false, enumerable: false, configurable: false});
+})(this);
+)JS";
+
+#endif /* Geo_h */
@@ -0,0 +1,241 @@
+#ifndef Helpers_h
+#define Helpers_h
+
+//Version of the helpers namespace, bumped on any change of behaviour so
+//that functions can check helpers.version
+#define HELPERS_VERSION 1
+
+//Helpers installed as the global "helpers" in every context functions run
+//and are validated in, next to emit and log. They are plain ES5 and do
+//not depend on the locale, the time zone or Date.parse, so that keys built
+//with them are the same in every engine. helpers_test.js tests them.
+static const char* HELPERS_SOURCE = R"JS(
+(function(global) {
+    'use strict';
+
+    var VERSION = 1;
+    var FORMS = {NFC: true, NFD: true, NFKC: true, NFKD: true};
+
+    // lower(s[, form]) lowercases s after Unicode normalization, NFKC
+    // unless form is given. null and undefined stay as they are.
+    function lower(s, form) {
+        if (s === null || s === undefined) {
+            return s;
+        }
+        form = form === undefined ? 'NFKC' : form;
+        if (!FORMS.hasOwnProperty(form)) {
+            throw new RangeError('helpers.lower: unknown normalization form ' + form);
+        }
+        if (typeof String.prototype.normalize !== 'function') {
+            throw new Error('helpers.lower: the engine has no Unicode normalization');
+        }
+        return String(s).normalize(form).toLowerCase();
+    }
+
+    var ISO_DATE = /^([+-]\d{6}|\d{4})-(\d{2})-(\d{2})(?:[T ](\d{2}):(\d{2})(?::(\d{2})(?:\.(\d{1,9}))?)?)?(Z|[+-]\d{2}:?\d{2})?$/;
+
+    function daysInMonth(year, month) {
+        if (month === 2) {
+            return (year % 4 === 0 && year % 100 !== 0) || year % 400 === 0 ? 29 : 28;
+        }
+        return month === 4 || month === 6 || month === 9 || month === 11 ? 30 : 31;
+    }
+
+    // toEpoch(s[, unit]) converts an ISO 8601 date or date-time to
+    // milliseconds since the epoch, or seconds with unit 's'. A date-time
+    // without offset is UTC. Anything else is null.
+    function toEpoch(s, unit) {
+        if (unit !== undefined && unit !== 'ms' && unit !== 's') {
+            throw new RangeError('helpers.toEpoch: unknown unit ' + unit);
+        }
+        if (typeof s !== 'string') {
+            return null;
+        }
+
+        var m = ISO_DATE.exec(s);
+        if (!m) {
+            return null;
+        }
+
+        var year = parseInt(m[1], 10), month = parseInt(m[2], 10), day = parseInt(m[3], 10);
+        var hour = m[4] ? parseInt(m[4], 10) : 0, minute = m[5] ? parseInt(m[5], 10) : 0;
+        var second = m[6] ? parseInt(m[6], 10) : 0;
+        var ms = m[7] ? parseInt((m[7] + '00').substring(0, 3), 10) : 0;
+        if (month < 1 || month > 12 || day < 1 || day > daysInMonth(year, month) ||
+            hour > 23 || minute > 59 || second > 59) {
+            return null;
+        }
+
+        var offset = 0;
+        if (m[8] && m[8] !== 'Z') {
+            var zone = m[8].replace(':', '');
+            var zh = parseInt(zone.substring(1, 3), 10), zm = parseInt(zone.substring(3, 5), 10);
+            if (zh > 23 || zm > 59) {
+                return null;
+            }
+            offset = (zone.charAt(0) === '-' ? -1 : 1) * (zh * 60 + zm) * 60000;
+        }
+
+        // Date.UTC maps years 0 to 99 to 1900 to 1999, setUTCFullYear does not
+        var date = new Date(Date.UTC(2000, month - 1, day, hour, minute, second, ms));
+        date.setUTCFullYear(year);
+        var epoch = date.getTime() - offset;
+        if (isNaN(epoch)) {
+            return null;
+        }
+        return unit === 's' ? Math.floor(epoch / 1000) : epoch;
+    }
+
+    function parsePath(path) {
+        if (Array.isArray(path)) {
+            return path;
+        }
+        var steps = [];
+        String(path).replace(/\[(\d+)\]|[^.[\]]+/g, function(match, index) {
+            steps.push(index !== undefined ? parseInt(index, 10) : match);
+        });
+        return steps;
+    }
+
+    // get(value, path[, fallback]) returns the field at path, 'a.b[0].c'
+    // or ['a', 'b', 0, 'c'], of value, or fallback when any step is
+    // missing. Only own properties are followed.
+    function get(value, path, fallback) {
+        var steps = parsePath(path);
+        for (var i = 0; i < steps.length; i++) {
+            if (value === null || typeof value !== 'object' ||
+                !Object.prototype.hasOwnProperty.call(value, steps[i])) {
+                return fallback;
+            }
+            value = value[steps[i]];
+        }
+        return value === undefined ? fallback : value;
+    }
+
+    // flatten(value[, depth]) flattens nested arrays, all levels unless
+    // depth is given. A value that is not an array is returned as [value].
+    function flatten(value, depth) {
+        depth = depth === undefined ? Infinity : depth;
+        if (!Array.isArray(value)) {
+            return [value];
+        }
+
+        var out = [];
+        (function walk(arr, level) {
+            for (var i = 0; i < arr.length; i++) {
+                if (Array.isArray(arr[i]) && level < depth) {
+                    walk(arr[i], level + 1);
+                } else {
+                    out.push(arr[i]);
+                }
+            }
+        })(value, 0);
+        return out;
+    }
+
+    var ESCAPES = {'"': '\\"', '\\': '\\\\', '\b': '\\b', '\f': '\\f', '\n': '\\n', '\r': '\\r', '\t': '\\t'};
+
+    // JSON.stringify of a string as ES2019 engines have it, older engines
+    // leave lone surrogates unescaped
+    function quote(s) {
+        var out = '"';
+        for (var i = 0; i < s.length; i++) {
+            var ch = s.charAt(i), c = s.charCodeAt(i);
+            if (ESCAPES.hasOwnProperty(ch)) {
+                out += ESCAPES[ch];
+            } else if (c >= 0xd800 && c <= 0xdbff && i + 1 < s.length &&
+                s.charCodeAt(i + 1) >= 0xdc00 && s.charCodeAt(i + 1) <= 0xdfff) {
+                out += ch + s.charAt(++i);
+            } else if (c < 0x20 || (c >= 0xd800 && c <= 0xdfff)) {
+                out += '\\u' + ('000' + c.toString(16)).slice(-4);
+            } else {
+                out += ch;
+            }
+        }
+        return out + '"';
+    }
+
+    // canonical(value) is JSON with object keys sorted, so that equal
+    // values have equal text whatever order their keys were set in.
+    function canonical(value) {
+        if (value === null || typeof value === 'boolean') {
+            return String(value);
+        } else if (typeof value === 'number') {
+            return isFinite(value) ? String(value) : 'null';
+        } else if (typeof value === 'string') {
+            return quote(value);
+        } else if (Array.isArray(value)) {
+            var items = [];
+            for (var i = 0; i < value.length; i++) {
+                var item = canonical(value[i]);
+                items.push(item === undefined ? 'null' : item);
+            }
+            return '[' + items.join(',') + ']';
+        } else if (typeof value === 'object') {
+            if (typeof value.toJSON === 'function') {
+                return canonical(value.toJSON());
+            }
+            var keys = Object.keys(value).sort(), fields = [];
+            for (var j = 0; j < keys.length; j++) {
+                var field = canonical(value[keys[j]]);
+                if (field !== undefined) {
+                    fields.push(quote(keys[j]) + ':' + field);
+                }
+            }
+            return '{' + fields.join(',') + '}';
+        }
+        return undefined;
+    }
+
+    // hash(value) is the 32 bit FNV-1a hash, as 8 hex digits, of the UTF-8
+    // bytes of canonical(value).
+    function hash(value) {
+        var text = canonical(value);
+        text = text === undefined ? 'null' : text;
+
+        var h = 0x811c9dc5;
+        function feed(b) {
+            h = Math.imul(h ^ b, 0x01000193);
+        }
+
+        for (var i = 0; i < text.length; i++) {
+            var cp = text.charCodeAt(i);
+            // canonical leaves no lone surrogate
+            if (cp >= 0xd800 && cp <= 0xdbff) {
+                cp = 0x10000 + ((cp - 0xd800) << 10) + (text.charCodeAt(++i) - 0xdc00);
+            }
+
+            if (cp < 0x80) {
+                feed(cp);
+            } else if (cp < 0x800) {
+                feed(0xc0 | cp >> 6);
+                feed(0x80 | cp & 0x3f);
+            } else if (cp < 0x10000) {
+                feed(0xe0 | cp >> 12);
+                feed(0x80 | cp >> 6 & 0x3f);
+                feed(0x80 | cp & 0x3f);
+            } else {
+                feed(0xf0 | cp >> 18);
+                feed(0x80 | cp >> 12 & 0x3f);
+                feed(0x80 | cp >> 6 & 0x3f);
+                feed(0x80 | cp & 0x3f);
+            }
+        }
+        return ('0000000' + (h >>> 0).toString(16)).slice(-8);
+    }
+
+    var helpers = {
+        version: VERSION,
+        lower: lower,
+        toEpoch: toEpoch,
+        get: get,
+        flatten: flatten,
+        canonical: canonical,
+        hash: hash
+    };
+    Object.freeze(helpers);
+    Object.defineProperty(global, 'helpers', {value: helpers, writable: false, enumerable: false, configurable: false});
+})(this);
+)JS";
+
+#endif /* Helpers_h */
@@ -1,6 +1,12 @@
 #ifndef Messages_h
 #define Messages_h
+#include<string>
+#include<vector>
 #include "Wrapper.h"
+
+//Values, markers included, that the emit calls of one document may
+//generate; emit throws past it and the document fails
+#define MAX_EMIT_VALUES 65536
 struct msg_request{
     metaData metadoc;
     std::string doc;
@@ -14,9 +20,20 @@ struct ValueForType{
 };
 
 struct msg_response{
-    int type[20];//Should change with increase in arguments in emit function
-    ValueForType arr[20]; //Should change with increase in arguments in emit function
+    std::vector<int> type;//every emit call as EMITSTART, its arguments, EMITEND
+    std::vector<ValueForType> arr;//values of strings, numbers and JSON in type
     int ValueLength;
     int length;
+    int failed;//set when entry point threw for this document
+    int emits;//emit calls for this document, each one is kept
+    std::string exception;//what the entry point threw, with its line
+    std::string console;//output of log() and console.log(), capped
+};
+
+struct validate_response{
+    std::string error;//empty when the code compiled and ran
+    int line;//1-based, 0 when unknown
+    int column;
+    std::vector<std::string> entryPoints;//functions declared at global scope
 };
 #endif
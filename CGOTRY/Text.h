@@ -0,0 +1,408 @@
+#ifndef Text_h
+#define Text_h
+
+//Version of the text namespace, bumped on any change of the tokens an
+//analyzer returns, as index keys built from them change with it
+#define TEXT_VERSION 1
+
+//Text analysis installed as the global "text" next to helpers, for
+//functions emitting one entry per word. An analyzer is a tokenizer and a
+//list of filters, all listed in the tables below: adding one is adding an
+//entry there. They are plain ES5 and do not depend on the locale, so that
+//every node with the same engine returns the same tokens. text_test.js
+//tests them.
+static const char* TEXT_SOURCE = R"JS(
+(function(global) {
+    'use strict';
+
+    var VERSION = 1;
+
+    function isSpace(c) {
+        return c === 0x20 || (c >= 0x09 && c <= 0x0d) || c === 0x85 || c === 0xa0 ||
+            c === 0x1680 || (c >= 0x2000 && c <= 0x200a) || c === 0x2028 || c === 0x2029 ||
+            c === 0x202f || c === 0x205f || c === 0x3000 || c === 0xfeff;
+    }
+
+    // Letters, digits and '_' of ASCII, and anything else but spaces,
+    // punctuation, symbols and emoji of the blocks below
+    function isWord(c) {
+        if (c < 0x80) {
+            return (c >= 0x30 && c <= 0x39) || (c >= 0x41 && c <= 0x5a) ||
+                (c >= 0x61 && c <= 0x7a) || c === 0x5f;
+        }
+        return !(isSpace(c) ||
+            (c >= 0xa1 && c <= 0xbf && c !== 0xaa && c !== 0xb5 && c !== 0xba) ||
+            c === 0xd7 || c === 0xf7 ||
+            (c >= 0x2010 && c <= 0x205e) || (c >= 0x20a0 && c <= 0x20cf) ||
+            (c >= 0x2190 && c <= 0x2bff) ||
+            (c >= 0x3001 && c <= 0x3003) || (c >= 0x3008 && c <= 0x3020) || c === 0x30fb ||
+            (c >= 0xff01 && c <= 0xff0f) || (c >= 0xff1a && c <= 0xff20) ||
+            (c >= 0xff3b && c <= 0xff40) || (c >= 0xff5b && c <= 0xff65) ||
+            (c >= 0x1f000 && c <= 0x1faff));
+    }
+
+    function isDigit(c) {
+        return c >= 0x30 && c <= 0x39;
+    }
+
+    // Code points of s, as numbers
+    function codePoints(s) {
+        var cps = [];
+        for (var i = 0; i < s.length; i++) {
+            var c = s.charCodeAt(i);
+            if (c >= 0xd800 && c <= 0xdbff && i + 1 < s.length) {
+                var d = s.charCodeAt(i + 1);
+                if (d >= 0xdc00 && d <= 0xdfff) {
+                    c = 0x10000 + ((c - 0xd800) << 10) + (d - 0xdc00);
+                    i++;
+                }
+            }
+            cps.push(c);
+        }
+        return cps;
+    }
+
+    function fromCodePoints(cps, start, end) {
+        var s = '';
+        for (var i = start; i < end; i++) {
+            var c = cps[i];
+            if (c >= 0x10000) {
+                c -= 0x10000;
+                s += String.fromCharCode(0xd800 + (c >> 10), 0xdc00 + (c & 0x3ff));
+            } else {
+                s += String.fromCharCode(c);
+            }
+        }
+        return s;
+    }
+
+    // Runs of word characters. An apostrophe between two of them, as in
+    // don't, and '.' or ',' between two digits, as in 3.14, stay within.
+    function standardTokenizer(s) {
+        var cps = codePoints(s), tokens = [], start = -1;
+        for (var i = 0; i <= cps.length; i++) {
+            var c = i < cps.length ? cps[i] : 0x20;
+            if (isWord(c)) {
+                start = start < 0 ? i : start;
+                continue;
+            }
+            if (start >= 0 && i + 1 < cps.length && isWord(cps[i + 1]) &&
+                ((c === 0x27 || c === 0x2019) ||
+                ((c === 0x2e || c === 0x2c) && isDigit(cps[i - 1]) && isDigit(cps[i + 1])))) {
+                continue;
+            }
+            if (start >= 0) {
+                tokens.push(fromCodePoints(cps, start, i));
+                start = -1;
+            }
+        }
+        return tokens;
+    }
+
+    function whitespaceTokenizer(s) {
+        var cps = codePoints(s), tokens = [], start = -1;
+        for (var i = 0; i <= cps.length; i++) {
+            if (i < cps.length && !isSpace(cps[i])) {
+                start = start < 0 ? i : start;
+            } else if (start >= 0) {
+                tokens.push(fromCodePoints(cps, start, i));
+                start = -1;
+            }
+        }
+        return tokens;
+    }
+
+    function keywordTokenizer(s) {
+        return s === '' ? [] : [s];
+    }
+
+    function lowerFilter(token) {
+        if (typeof String.prototype.normalize !== 'function') {
+            throw new Error('text: the engine has no Unicode normalization');
+        }
+        return token.normalize('NFKC').toLowerCase();
+    }
+
+    // Removes accents, as combining marks after NFD
+    function foldFilter(token) {
+        if (typeof String.prototype.normalize !== 'function') {
+            throw new Error('text: the engine has no Unicode normalization');
+        }
+        return token.normalize('NFD').replace(/[\u0300-\u036f]/g, '').normalize('NFC');
+    }
+
+    function possessiveEnFilter(token) {
+        return token.replace(/['\u2019][sS]?$/, '');
+    }
+
+    var STOP_EN = {};
+    ('a an and are as at be but by for if in into is it no not of on or such that the their ' +
+        'then there these they this to was will with').split(' ').forEach(function(word) {
+        STOP_EN[word] = true;
+    });
+
+    function stopEnFilter(token) {
+        return STOP_EN.hasOwnProperty(token) ? null : token;
+    }
+
+    // The Porter stemmer, as of its reference implementation
+    var PORTER_C = '[^aeiou]', PORTER_V = '[aeiouy]';
+    var PORTER_CS = PORTER_C + '[^aeiouy]*', PORTER_VS = PORTER_V + '[aeiou]*';
+    var MGR0 = new RegExp('^(' + PORTER_CS + ')?' + PORTER_VS + PORTER_CS);
+    var MEQ1 = new RegExp('^(' + PORTER_CS + ')?' + PORTER_VS + PORTER_CS + '(' + PORTER_VS + ')?$');
+    var MGR1 = new RegExp('^(' + PORTER_CS + ')?' + PORTER_VS + PORTER_CS + PORTER_VS + PORTER_CS);
+    var HAS_V = new RegExp('^(' + PORTER_CS + ')?' + PORTER_V);
+    var CVC = new RegExp('^' + PORTER_CS + PORTER_V + '[^aeiouwxy]$');
+
+    var STEP2 = {
+        ational: 'ate', tional: 'tion', enci: 'ence', anci: 'ance', izer: 'ize', bli: 'ble',
+        alli: 'al', entli: 'ent', eli: 'e', ousli: 'ous', ization: 'ize', ation: 'ate',
+        ator: 'ate', alism: 'al', iveness: 'ive', fulness: 'ful', ousness: 'ous', aliti: 'al',
+        iviti: 'ive', biliti: 'ble', logi: 'log'
+    };
+    var STEP3 = {icate: 'ic', ative: '', alize: 'al', iciti: 'ic', ical: 'ic', ful: '', ness: ''};
+
+    var STEP1A = /^(.+?)(ss|i)es$/, STEP1A_S = /^(.+?)([^s])s$/;
+    var STEP1B_EED = /^(.+?)eed$/, STEP1B = /^(.+?)(ed|ing)$/;
+    var STEP1C = /^(.+?)y$/;
+    var STEP2_RE = /^(.+?)(ational|tional|enci|anci|izer|bli|alli|entli|eli|ousli|ization|ation|ator|alism|iveness|fulness|ousness|aliti|iviti|biliti|logi)$/;
+    var STEP3_RE = /^(.+?)(icate|ative|alize|iciti|ical|ful|ness)$/;
+    var STEP4_RE = /^(.+?)(al|ance|ence|er|ic|able|ible|ant|ement|ment|ent|ou|ism|ate|iti|ous|ive|ize)$/;
+    var STEP4_ION = /^(.+?)(s|t)(ion)$/;
+    var STEP5 = /^(.+?)e$/;
+
+    // Stems lowercase ASCII words, leaves anything else as it is
+    function porterEnFilter(w) {
+        if (w.length < 3 || !/^[a-z]+$/.test(w)) {
+            return w;
+        }
+
+        var m, stem;
+        var y = w.charAt(0) === 'y';
+        if (y) {
+            w = 'Y' + w.substring(1);
+        }
+
+        if ((m = STEP1A.exec(w))) {
+            w = m[1] + m[2];
+        } else if ((m = STEP1A_S.exec(w))) {
+            w = m[1] + m[2];
+        }
+
+        if ((m = STEP1B_EED.exec(w))) {
+            if (MGR0.test(m[1])) {
+                w = w.substring(0, w.length - 1);
+            }
+        } else if ((m = STEP1B.exec(w))) {
+            if (HAS_V.test(m[1])) {
+                w = m[1];
+                if (/(at|bl|iz)$/.test(w)) {
+                    w += 'e';
+                } else if (/([^aeiouylsz])\1$/.test(w)) {
+                    w = w.substring(0, w.length - 1);
+                } else if (CVC.test(w)) {
+                    w += 'e';
+                }
+            }
+        }
+
+        if ((m = STEP1C.exec(w)) && HAS_V.test(m[1])) {
+            w = m[1] + 'i';
+        }
+
+        if ((m = STEP2_RE.exec(w)) && MGR0.test(m[1])) {
+            w = m[1] + STEP2[m[2]];
+        }
+
+        if ((m = STEP3_RE.exec(w)) && MGR0.test(m[1])) {
+            w = m[1] + STEP3[m[2]];
+        }
+
+        if ((m = STEP4_RE.exec(w))) {
+            if (MGR1.test(m[1])) {
+                w = m[1];
+            }
+        } else if ((m = STEP4_ION.exec(w))) {
+            stem = m[1] + m[2];
+            if (MGR1.test(stem)) {
+                w = stem;
+            }
+        }
+
+        if ((m = STEP5.exec(w))) {
+            stem = m[1];
+            if (MGR1.test(stem) || (MEQ1.test(stem) && !CVC.test(stem))) {
+                w = stem;
+            }
+        }
+
+        if (/ll$/.test(w) && MGR1.test(w)) {
+            w = w.substring(0, w.length - 1);
+        }
+
+        return y ? 'y' + w.substring(1) : w;
+    }
+
+    var TOKENIZERS = {
+        standard: standardTokenizer,
+        whitespace: whitespaceTokenizer,
+        keyword: keywordTokenizer
+    };
+
+    // Filters take a token and return it, changed or not, a list of tokens
+    // it is replaced with, or null to drop it
+    var FILTERS = {
+        lower: lowerFilter,
+        fold: foldFilter,
+        possessive_en: possessiveEnFilter,
+        stop_en: stopEnFilter,
+        porter_en: porterEnFilter
+    };
+
+    var ANALYZERS = {
+        keyword: {tokenizer: 'keyword', filters: []},
+        whitespace: {tokenizer: 'whitespace', filters: []},
+        standard: {tokenizer: 'standard', filters: ['lower']},
+        folding: {tokenizer: 'standard', filters: ['lower', 'fold']},
+        en: {tokenizer: 'standard', filters: ['lower', 'possessive_en', 'stop_en', 'porter_en']}
+    };
+
+    function lookup(table, kind, name) {
+        if (typeof name === 'function') {
+            return name;
+        } else if (!table.hasOwnProperty(name)) {
+            throw new RangeError('text.tokens: unknown ' + kind + ' ' + name);
+        }
+        return table[name];
+    }
+
+    function applyFilter(tokens, filter) {
+        var out = [];
+        for (var i = 0; i < tokens.length; i++) {
+            var result = filter(tokens[i]);
+            result = Array.isArray(result) ? result : [result];
+            for (var j = 0; j < result.length; j++) {
+                if (result[j] !== null && result[j] !== undefined && result[j] !== '') {
+                    out.push(String(result[j]));
+                }
+            }
+        }
+        return out;
+    }
+
+    function count(opts, name, fallback) {
+        var n = opts[name] === undefined ? fallback : opts[name];
+        if (typeof n !== 'number' || n % 1 !== 0 || n < 1) {
+            throw new RangeError('text.tokens: ' + name + ' must be a positive integer');
+        }
+        return n;
+    }
+
+    // Character n-grams, of min to max code points, of each token, only
+    // those at its start with edge. Tokens shorter than min are kept whole.
+    function ngrams(tokens, opts) {
+        var min = count(opts, 'min', 1), max = count(opts, 'max', min);
+        if (max < min) {
+            throw new RangeError('text.tokens: ngram max is less than min');
+        }
+
+        var out = [];
+        for (var i = 0; i < tokens.length; i++) {
+            var cps = codePoints(tokens[i]);
+            if (cps.length < min) {
+                out.push(tokens[i]);
+                continue;
+            }
+            var starts = opts.edge ? 1 : cps.length;
+            for (var start = 0; start < starts; start++) {
+                for (var n = min; n <= max && start + n <= cps.length; n++) {
+                    out.push(fromCodePoints(cps, start, start + n));
+                }
+            }
+        }
+        return out;
+    }
+
+    // Tokens, followed by runs of 2 to n of them joined by a space
+    function shingles(tokens, n) {
+        var out = tokens.slice();
+        for (var size = 2; size <= n; size++) {
+            for (var i = 0; i + size <= tokens.length; i++) {
+                out.push(tokens.slice(i, i + size).join(' '));
+            }
+        }
+        return out;
+    }
+
+    // tokens(s[, opts]) analyzes s into a list of tokens. opts are
+    //   analyzer   name of the analyzer, standard by default
+    //   tokenizer  name of a tokenizer, in place of the analyzer's
+    //   filters    names of filters, or functions, in place of the analyzer's
+    //   ngram      {min, max, edge}: character n-grams of every token
+    //   shingles   n: also every run of 2 to n tokens
+    //   unique     true to return every token once, in order of appearance
+    // null and undefined have no tokens, anything else is a string.
+    function tokens(s, opts) {
+        opts = opts || {};
+        if (s === null || s === undefined) {
+            return [];
+        }
+
+        var analyzer = lookup(ANALYZERS, 'analyzer', opts.analyzer === undefined ? 'standard' : opts.analyzer);
+        var tokenizer = lookup(TOKENIZERS, 'tokenizer', opts.tokenizer === undefined ? analyzer.tokenizer : opts.tokenizer);
+        var filters = opts.filters === undefined ? analyzer.filters : opts.filters;
+        if (!Array.isArray(filters)) {
+            throw new TypeError('text.tokens: filters must be an array');
+        }
+
+        var out = applyFilter(tokenizer(String(s)), function(token) {
+            return token;
+        });
+        for (var i = 0; i < filters.length; i++) {
+            out = applyFilter(out, lookup(FILTERS, 'filter', filters[i]));
+        }
+
+        if (opts.ngram !== undefined && opts.shingles !== undefined) {
+            throw new RangeError('text.tokens: ngram and shingles cannot be combined');
+        } else if (opts.ngram !== undefined) {
+            out = ngrams(out, opts.ngram);
+        } else if (opts.shingles !== undefined) {
+            out = shingles(out, count(opts, 'shingles'));
+        }
+
+        if (opts.unique) {
+            var seen = {}, unique = [];
+            for (var j = 0; j < out.length; j++) {
+                if (!seen.hasOwnProperty('$' + out[j])) {
+                    seen['$' + out[j]] = true;
+                    unique.push(out[j]);
+                }
+            }
+            out = unique;
+        }
+        return out;
+    }
+
+    function names(table) {
+        return Object.keys(table).sort();
+    }
+
+    var text = {
+        version: VERSION,
+        tokens: tokens,
+        analyzers: function() {
+            return names(ANALYZERS);
+        },
+        tokenizers: function() {
+            return names(TOKENIZERS);
+        },
+        filters: function() {
+            return names(FILTERS);
+        }
+    };
+    Object.freeze(text);
+    Object.defineProperty(global, 'text', {value: text, writable: false, enumerable: false, configurable: false});
+})(this);
+)JS";
+
+#endif /* Text_h */
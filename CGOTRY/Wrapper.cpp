@@ -12,9 +12,10 @@ EngineObj CreateEngine(int NumberOfIsolates){
     return (void*)e;
 }
 
-void Compile(char* filename,EngineObj e,const char* code){
+//returns 1 when every isolate compiled code and found the entry point
+int Compile(char* filename,EngineObj e,const char* code,const char* entryPoint){
     Engine *e1=(Engine*)e;
-    e1->Compile(std::string(filename),code);
+    return e1->Compile(std::string(filename),code,entryPoint);
 }
 
 returnType Route(EngineObj e,struct metaData meta,const char* doc,const char* filename){
@@ -23,11 +24,43 @@ returnType Route(EngineObj e,struct metaData meta,const char* doc,const char* fi
     return ans;
 }
 
+//Route, terminating the entry point once it ran for timeoutMs
+returnType RouteTimeout(EngineObj e,struct metaData meta,const char* doc,const char* filename,int timeoutMs){
+    Engine *e1=(Engine*)e;
+    return e1->Route(meta, doc,filename,timeoutMs);
+}
+
+//Drops the entry point compiled as filename from every isolate
+void Unload(EngineObj e,const char* filename){
+    Engine *e1=(Engine*)e;
+    e1->Unload(std::string(filename));
+}
+
 int getLength(void* msg){
     msg_response* m=(msg_response*)msg;
     return m->length;
 }
 
+int getFailed(returnType msg){
+    msg_response* m=(msg_response*)msg;
+    return m->failed;
+}
+
+int getEmits(returnType msg){
+    msg_response* m=(msg_response*)msg;
+    return m->emits;
+}
+
+const char* getException(returnType msg){
+    msg_response* m=(msg_response*)msg;
+    return m->exception.c_str();
+}
+
+const char* getConsole(returnType msg){
+    msg_response* m=(msg_response*)msg;
+    return m->console.c_str();
+}
+
 int getType(returnType msg,int index){
     msg_response* m=(msg_response*)msg;
     return m->type[index];
@@ -35,12 +68,12 @@ int getType(returnType msg,int index){
 
 void* GetValue(returnType msg){
     msg_response* m=(msg_response*)msg;
-    return (void*)&m->arr;
+    return (void*)m->arr.data();
 }
 
 void* GetTypeArray(returnType msg){
     msg_response* m=(msg_response*)msg;
-    return (void*)&m->type;
+    return (void*)m->type.data();
 }
 const char* getJSON(returnType msg,int index){
     msg_response* m=(msg_response*)msg;
@@ -67,3 +100,46 @@ int getBool(returnType msg,int index){
     return m->arr[index].boolValue;
 }
 
+//Frees a response of Route once it has been read
+void freeResponse(returnType msg){
+    delete (msg_response*)msg;
+}
+
+
+validateType Validate(EngineObj e,const char* code){
+    Engine *e1=(Engine*)e;
+    return (void*)e1->Validate(code);
+}
+
+const char* getValidateError(validateType v){
+    validate_response* m=(validate_response*)v;
+    return m->error.c_str();
+}
+
+int getValidateLine(validateType v){
+    validate_response* m=(validate_response*)v;
+    return m->line;
+}
+
+int getValidateColumn(validateType v){
+    validate_response* m=(validate_response*)v;
+    return m->column;
+}
+
+int getEntryPointCount(validateType v){
+    validate_response* m=(validate_response*)v;
+    return (int)m->entryPoints.size();
+}
+
+const char* getEntryPoint(validateType v,int index){
+    validate_response* m=(validate_response*)v;
+    return m->entryPoints[index].c_str();
+}
+
+void freeValidate(validateType v){
+    delete (validate_response*)v;
+}
+
+const char* EngineVersion(){
+    return v8::V8::GetVersion();
+}
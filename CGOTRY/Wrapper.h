@@ -19,9 +19,15 @@ extern "C" {
     typedef void* returnType;
     static EngineObj e;
     EngineObj CreateEngine(int NumberOfIsolates);
-    void Compile(char* filename,EngineObj e,const char* code);
+    int Compile(char* filename,EngineObj e,const char* code,const char* entryPoint);
     returnType Route(EngineObj e,struct metaData meta,const char* doc,const char* filename);
+    returnType RouteTimeout(EngineObj e,struct metaData meta,const char* doc,const char* filename,int timeoutMs);
+    void Unload(EngineObj e,const char* filename);
     int getLength(returnType msg);
+    int getFailed(returnType msg);
+    int getEmits(returnType msg);
+    const char* getException(returnType msg);
+    const char* getConsole(returnType msg);
     void* GetTypeArray(returnType msg);
     void* GetValue(returnType msg);
     const char* getJSON(returnType msg,int index);
@@ -30,6 +36,17 @@ extern "C" {
     int getType(returnType msg,int index);
     double getFloat(returnType msg,int index);
     int getBool(returnType msg,int index);
+    void freeResponse(returnType msg);
+
+    typedef void* validateType;
+    validateType Validate(EngineObj e,const char* code);
+    const char* getValidateError(validateType v);
+    int getValidateLine(validateType v);
+    int getValidateColumn(validateType v);
+    int getEntryPointCount(validateType v);
+    const char* getEntryPoint(validateType v,int index);
+    void freeValidate(validateType v);
+    const char* EngineVersion();
 #ifdef __cplusplus
 }
 
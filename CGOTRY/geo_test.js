@@ -0,0 +1,108 @@
+// Test suite of the geo namespace of Geo.h. Like helpers_test.js the
+// cases only use ES5 and print through print() or console.log(); with node
+// they run against the very source embedded in Geo.h:
+//
+//     node CGOTRY/geo_test.js
+//
+// The cover cases are those Projector/jsgeo.go returns for the same input.
+
+var geoTestCases = [
+    ['version', function(g) { return g.version; }, 1],
+    ['frozen', function(g) { return Object.isFrozen(g); }, true],
+
+    ['encode', function(g) { return g.encode(57.64911, 10.40744, 11); }, 'u4pruydqqvj'],
+    ['encode precision 5', function(g) { return g.encode(42.6, -5.6, 5); }, 'ezs42'],
+    ['encode default precision', function(g) { return g.encode(0, 0); }, 's00000000000'],
+    ['encode boundary goes up', function(g) { return g.encode(0, -0.0000001, 1); }, 'e'],
+    ['encode corners', function(g) { return [g.encode(-90, -180, 2), g.encode(90, 180, 2)]; }, ['00', 'zz']],
+    ['encode bad latitude', function(g) { return throws(function() { g.encode(91, 0); }); }, true],
+    ['encode nan', function(g) { return throws(function() { g.encode(NaN, 0); }); }, true],
+    ['encode bad precision', function(g) { return throws(function() { g.encode(0, 0, 13); }); }, true],
+
+    ['decode', function(g) { return g.decode('ezs42'); }, {
+        south: 42.5830078125, west: -5.625, north: 42.626953125, east: -5.5810546875,
+        lat: 42.60498046875, lon: -5.60302734375
+    }],
+    ['decode 1', function(g) { return g.decode('s'); }, {south: 0, west: 0, north: 45, east: 45, lat: 22.5, lon: 22.5}],
+    ['decode round trip', function(g) {
+        var cell = g.decode('u4pruydqqvj');
+        return cell.south <= 57.64911 && 57.64911 < cell.north && cell.west <= 10.40744 && 10.40744 < cell.east;
+    }, true],
+    ['decode bad character', function(g) { return throws(function() { g.decode('abc'); }); }, true],
+    ['decode empty', function(g) { return throws(function() { g.decode(''); }); }, true],
+
+    ['cells', function(g) { return g.cells(42.6, -5.6, 2, 5); }, ['ez', 'ezs', 'ezs4', 'ezs42']],
+    ['cells default', function(g) { return g.cells(0, 0).length; }, 12],
+    ['cells bad range', function(g) { return throws(function() { g.cells(0, 0, 5, 2); }); }, true],
+
+    ['coverBox', function(g) { return g.coverBox(42.6, -5.6, 42.65, -5.55, 5); }, ['ezs42', 'ezs43', 'ezs48', 'ezs49']],
+    ['coverBox point', function(g) { return g.coverBox(42.6, -5.6, 42.6, -5.6, 5); }, ['ezs42']],
+    ['coverBox antimeridian', function(g) { return g.coverBox(-1, 179, 1, -179, 2); }, ['2p', '80', 'rz', 'xb']],
+    ['coverBox world', function(g) { return g.coverBox(-90, -180, 90, 180, 1).length; }, 32],
+    ['coverBox too many', function(g) { return throws(function() { g.coverBox(-90, -180, 90, 180, 3); }); }, true],
+    ['coverBox south over north', function(g) { return throws(function() { g.coverBox(1, 0, 0, 1, 2); }); }, true],
+
+    ['coverRadius', function(g) { return g.coverRadius(48.8566, 2.3522, 1000, 5); }, ['u09tv']],
+    ['coverRadius precision 6', function(g) { return g.coverRadius(48.8566, 2.3522, 1000, 6); }, [
+        'u09tvh', 'u09tvj', 'u09tvk', 'u09tvm', 'u09tvn', 'u09tvp', 'u09tvq', 'u09tvr',
+        'u09tvs', 'u09tvt', 'u09tvu', 'u09tvv', 'u09tvw', 'u09tvx', 'u09tvy', 'u09tvz'
+    ]],
+    ['coverRadius zero', function(g) { return g.coverRadius(48.8566, 2.3522, 0, 6); }, ['u09tvw']],
+    ['coverRadius contains point', function(g) {
+        return g.coverRadius(48.8566, 2.3522, 5000, 5).indexOf(g.encode(48.8566, 2.3522, 5)) >= 0;
+    }, true],
+    ['coverRadius antimeridian', function(g) { return g.coverRadius(0, 179.99, 5000, 3); }, ['2pb', '800', 'rzz', 'xbp']],
+    ['coverRadius pole', function(g) { return g.coverRadius(89.99, 0, 5000, 1); }, ['b', 'c', 'f', 'g', 'u', 'v', 'y', 'z']],
+    ['coverRadius bad radius', function(g) { return throws(function() { g.coverRadius(0, 0, -1); }); }, true],
+
+    ['ranges', function(g) { return g.ranges(['ezs43', 'ezs42', 'ezs48', 'ezs49']); }, [
+        {low: 'ezs42', high: 'ezs44'}, {low: 'ezs48', high: 'ezs4b'}
+    ]],
+    ['ranges carry', function(g) { return g.ranges(['bz', 'c']); }, [{low: 'bz', high: 'd'}]],
+    ['ranges gap', function(g) { return g.ranges(['bz', 'c0']); }, [{low: 'bz', high: 'c'}, {low: 'c0', high: 'c1'}]],
+    ['ranges nested', function(g) { return g.ranges(['u0', 'u09', 'u1']); }, [{low: 'u0', high: 'u2'}]],
+    ['ranges unbounded', function(g) { return g.ranges(['z', 'y']); }, [{low: 'y', high: ''}]],
+    ['ranges empty', function(g) { return g.ranges([]); }, []],
+    ['ranges bad cell', function(g) { return throws(function() { g.ranges(['ai']); }); }, true]
+];
+
+function throws(fn) {
+    try {
+        fn();
+    } catch (e) {
+        return true;
+    }
+    return false;
+}
+
+// Runs the cases against geo, returns the number of failures
+function runGeoTests(geo, out) {
+    var failures = 0;
+    for (var i = 0; i < geoTestCases.length; i++) {
+        var name = geoTestCases[i][0], expected = geoTestCases[i][2], actual;
+        try {
+            actual = geoTestCases[i][1](geo);
+        } catch (e) {
+            actual = 'exception: ' + e;
+        }
+
+        if (JSON.stringify([actual]) !== JSON.stringify([expected])) {
+            failures++;
+            out('FAIL ' + name + ': got ' + JSON.stringify(actual) + ', expected ' + JSON.stringify(expected));
+        }
+    }
+    out((geoTestCases.length - failures) + ' passed, ' + failures + ' failed');
+    return failures;
+}
+
+if (typeof module !== 'undefined' && module.exports && typeof require === 'function') {
+    var fs = require('fs'), path = require('path'), vm = require('vm');
+    var header = fs.readFileSync(path.join(__dirname, 'Geo.h'), 'utf8');
+    var source = header.substring(header.indexOf('R"JS(') + 5, header.indexOf(')JS"'));
+
+    var context = vm.createContext({});
+    vm.runInContext(source, context, {filename: 'geo'});
+    process.exitCode = runGeoTests(context.geo, console.log) > 0 ? 1 : 0;
+} else if (typeof geo !== 'undefined') {
+    runGeoTests(geo, typeof print === 'function' ? print : console.log);
+}
@@ -0,0 +1,112 @@
+// Test suite of the helpers namespace of Helpers.h. The cases only use
+// ES5 and print through print() or console.log(), so that they can run
+// in any engine the helpers are installed in; with node they run against
+// the very source embedded in Helpers.h:
+//
+//     node CGOTRY/helpers_test.js
+//
+// Expected hashes are FNV-1a computed outside of JavaScript.
+
+var helpersTestCases = [
+    ['version', function(h) { return h.version; }, 1],
+    ['frozen', function(h) { return Object.isFrozen(h); }, true],
+
+    ['lower ascii', function(h) { return h.lower('HeLLo'); }, 'hello'],
+    ['lower nfkc ligature', function(h) { return h.lower('ﬁne'); }, 'fine'],
+    ['lower nfkc fullwidth', function(h) { return h.lower('ＡＢ'); }, 'ab'],
+    ['lower composes', function(h) { return h.lower('CAFÉ'); }, 'café'],
+    ['lower nfd', function(h) { return h.lower('É', 'NFD'); }, 'é'],
+    ['lower null', function(h) { return h.lower(null); }, null],
+    ['lower number', function(h) { return h.lower(12); }, '12'],
+    ['lower bad form', function(h) { return throws(function() { h.lower('a', 'NFX'); }); }, true],
+
+    ['toEpoch date', function(h) { return h.toEpoch('2020-01-02'); }, 1577923200000],
+    ['toEpoch utc', function(h) { return h.toEpoch('2020-01-02T03:04:05Z'); }, 1577934245000],
+    ['toEpoch no offset is utc', function(h) { return h.toEpoch('2020-01-02T03:04:05'); }, 1577934245000],
+    ['toEpoch offset', function(h) { return h.toEpoch('2020-01-02T03:04:05+05:30'); }, 1577914445000],
+    ['toEpoch offset no colon', function(h) { return h.toEpoch('2020-01-02T03:04:05-0100'); }, 1577937845000],
+    ['toEpoch fraction', function(h) { return h.toEpoch('1970-01-01T00:00:00.1234Z'); }, 123],
+    ['toEpoch space', function(h) { return h.toEpoch('1970-01-01 00:01'); }, 60000],
+    ['toEpoch seconds', function(h) { return h.toEpoch('1970-01-01T00:00:01.999Z', 's'); }, 1],
+    ['toEpoch before epoch', function(h) { return h.toEpoch('1969-12-31T23:59:59Z'); }, -1000],
+    ['toEpoch year 50', function(h) { return h.toEpoch('0050-01-01'); }, -60589296000000],
+    ['toEpoch leap day', function(h) { return h.toEpoch('2000-02-29'); }, 951782400000],
+    ['toEpoch no leap day', function(h) { return h.toEpoch('1900-02-29'); }, null],
+    ['toEpoch bad month', function(h) { return h.toEpoch('2020-13-01'); }, null],
+    ['toEpoch bad hour', function(h) { return h.toEpoch('2020-01-01T24:00'); }, null],
+    ['toEpoch not iso', function(h) { return h.toEpoch('Jan 2, 2020'); }, null],
+    ['toEpoch not string', function(h) { return h.toEpoch(1577923200000); }, null],
+    ['toEpoch bad unit', function(h) { return throws(function() { h.toEpoch('2020-01-01', 'h'); }); }, true],
+
+    ['get path', function(h) { return h.get({a: {b: [{c: 3}]}}, 'a.b[0].c'); }, 3],
+    ['get array path', function(h) { return h.get({a: {b: [{c: 3}]}}, ['a', 'b', 0, 'c']); }, 3],
+    ['get missing', function(h) { return h.get({a: {}}, 'a.b.c', 'none'); }, 'none'],
+    ['get through null', function(h) { return h.get({a: null}, 'a.b', 0); }, 0],
+    ['get through string', function(h) { return h.get({a: 'xyz'}, 'a.length', -1); }, -1],
+    ['get no prototype', function(h) { return h.get({}, 'constructor', 'none'); }, 'none'],
+    ['get null value', function(h) { return h.get({a: null}, 'a', 'none'); }, null],
+    ['get undefined fallback', function(h) { return h.get(undefined, 'a'); }, undefined],
+
+    ['flatten all', function(h) { return h.flatten([1, [2, [3, [4]]], []]); }, [1, 2, 3, 4]],
+    ['flatten depth', function(h) { return h.flatten([1, [2, [3, [4]]]], 1); }, [1, 2, [3, [4]]]],
+    ['flatten scalar', function(h) { return h.flatten('a'); }, ['a']],
+    ['flatten keeps null', function(h) { return h.flatten([null, [undefined]]); }, [null, undefined]],
+
+    ['canonical sorts keys', function(h) { return h.canonical({b: 1, a: [1, 'x']}); }, '{"a":[1,"x"],"b":1}'],
+    ['canonical drops undefined', function(h) { return h.canonical({a: undefined, b: function() {}}); }, '{}'],
+    ['canonical array holes', function(h) { return h.canonical([undefined, NaN, Infinity]); }, '[null,null,null]'],
+    ['canonical escapes', function(h) { return h.canonical('"\\\n\u0001'); }, '"\\"\\\\\\n\\u0001"'],
+    ['canonical lone surrogate', function(h) { return h.canonical('\ud800'); }, '"\\ud800"'],
+    ['canonical surrogate pair', function(h) { return h.canonical('😀'); }, '"😀"'],
+    ['canonical date', function(h) { return h.canonical(new Date(0)); }, '"1970-01-01T00:00:00.000Z"'],
+
+    ['hash null', function(h) { return h.hash(null); }, '77074ba4'],
+    ['hash undefined', function(h) { return h.hash(undefined); }, '77074ba4'],
+    ['hash object', function(h) { return h.hash({b: 1, a: [1, 'x']}); }, '3c246a00'],
+    ['hash key order', function(h) { return h.hash({a: [1, 'x'], b: 1}) === h.hash({b: 1, a: [1, 'x']}); }, true],
+    ['hash utf8', function(h) { return h.hash('café'); }, 'd1ace591'],
+    ['hash array', function(h) { return h.hash([1.5, true, null]); }, '4cad4f10'],
+    ['hash lone surrogate', function(h) { return h.hash('\ud800'); }, '3ffd2a2c'],
+    ['hash astral', function(h) { return h.hash('😀'); }, '9edc1cbe']
+];
+
+function throws(fn) {
+    try {
+        fn();
+    } catch (e) {
+        return true;
+    }
+    return false;
+}
+
+// Runs the cases against helpers, returns the number of failures
+function runHelpersTests(helpers, out) {
+    var failures = 0;
+    for (var i = 0; i < helpersTestCases.length; i++) {
+        var name = helpersTestCases[i][0], expected = helpersTestCases[i][2], actual;
+        try {
+            actual = helpersTestCases[i][1](helpers);
+        } catch (e) {
+            actual = 'exception: ' + e;
+        }
+
+        if (JSON.stringify([actual]) !== JSON.stringify([expected])) {
+            failures++;
+            out('FAIL ' + name + ': got ' + JSON.stringify(actual) + ', expected ' + JSON.stringify(expected));
+        }
+    }
+    out((helpersTestCases.length - failures) + ' passed, ' + failures + ' failed');
+    return failures;
+}
+
+if (typeof module !== 'undefined' && module.exports && typeof require === 'function') {
+    var fs = require('fs'), path = require('path'), vm = require('vm');
+    var header = fs.readFileSync(path.join(__dirname, 'Helpers.h'), 'utf8');
+    var source = header.substring(header.indexOf('R"JS(') + 5, header.indexOf(')JS"'));
+
+    var context = vm.createContext({});
+    vm.runInContext(source, context, {filename: 'helpers'});
+    process.exitCode = runHelpersTests(context.helpers, console.log) > 0 ? 1 : 0;
+} else if (typeof helpers !== 'undefined') {
+    runHelpersTests(helpers, typeof print === 'function' ? print : console.log);
+}
@@ -0,0 +1,134 @@
+// Test suite of the text namespace of Text.h. Like helpers_test.js the
+// cases only use ES5 and print through print() or console.log(); with node
+// they run against the very source embedded in Text.h:
+//
+//     node CGOTRY/text_test.js
+//
+// Expected stems are those of the reference Porter stemmer.
+
+var textTestCases = [
+    ['version', function(t) { return t.version; }, 1],
+    ['frozen', function(t) { return Object.isFrozen(t); }, true],
+    ['analyzers', function(t) { return t.analyzers(); }, ['en', 'folding', 'keyword', 'standard', 'whitespace']],
+    ['tokenizers', function(t) { return t.tokenizers(); }, ['keyword', 'standard', 'whitespace']],
+    ['filters', function(t) { return t.filters(); }, ['fold', 'lower', 'porter_en', 'possessive_en', 'stop_en']],
+
+    ['standard', function(t) { return t.tokens('Hello, World!'); }, ['hello', 'world']],
+    ['standard apostrophe', function(t) { return t.tokens("Don't stop"); }, ["don't", 'stop']],
+    ['standard quotes', function(t) { return t.tokens("'quoted' text"); }, ['quoted', 'text']],
+    ['standard numbers', function(t) { return t.tokens('pi is 3.14, 1,000 or v1.2'); }, ['pi', 'is', '3.14', '1,000', 'or', 'v1.2']],
+    ['standard underscore', function(t) { return t.tokens('snake_case-word'); }, ['snake_case', 'word']],
+    ['standard unicode', function(t) { return t.tokens('Grüße aus Köln—München'); }, ['grüße', 'aus', 'köln', 'münchen']],
+    ['standard cjk punctuation', function(t) { return t.tokens('東京、大阪。'); }, ['東京', '大阪']],
+    ['standard fullwidth', function(t) { return t.tokens('ＡＢＣ１２３'); }, ['abc123']],
+    ['standard emoji', function(t) { return t.tokens('good 😀 day'); }, ['good', 'day']],
+    ['standard astral letters', function(t) { return t.tokens('𝐀𝐁 x'); }, ['ab', 'x']],
+    ['standard empty', function(t) { return t.tokens(' ,. '); }, []],
+    ['null', function(t) { return t.tokens(null); }, []],
+    ['undefined', function(t) { return t.tokens(undefined, {analyzer: 'en'}); }, []],
+    ['number', function(t) { return t.tokens(42); }, ['42']],
+
+    ['whitespace', function(t) { return t.tokens(' Foo-bar　BAZ!\n', {analyzer: 'whitespace'}); }, ['Foo-bar', 'BAZ!']],
+    ['keyword', function(t) { return t.tokens('New York', {analyzer: 'keyword'}); }, ['New York']],
+    ['keyword empty', function(t) { return t.tokens('', {analyzer: 'keyword'}); }, []],
+    ['folding', function(t) { return t.tokens('Crème Brûlée', {analyzer: 'folding'}); }, ['creme', 'brulee']],
+
+    ['en', function(t) { return t.tokens("The runners' running in the city's parks", {analyzer: 'en'}); }, ['runner', 'run', 'citi', 'park']],
+    ['en stop words only', function(t) { return t.tokens('to be or not to be', {analyzer: 'en'}); }, []],
+    ['en curly possessive', function(t) { return t.tokens('John’s', {analyzer: 'en'}); }, ['john']],
+    ['en leaves non ascii', function(t) { return t.tokens('naïve cafés', {analyzer: 'en'}); }, ['naïve', 'cafés']],
+
+    ['porter', function(t) {
+        var words = ['caresses', 'ponies', 'ties', 'caress', 'cats', 'feed', 'agreed', 'plastered',
+            'motoring', 'sing', 'conflated', 'troubled', 'sized', 'hopping', 'falling', 'hissing',
+            'fizzed', 'failing', 'filing', 'happy', 'sky', 'relational', 'conditional', 'rational',
+            'valenci', 'digitizer', 'conformabli', 'radicalli', 'differentli', 'vileli', 'analogousli',
+            'vietnamization', 'predication', 'operator', 'feudalism', 'decisiveness', 'hopefulness',
+            'callousness', 'formaliti', 'sensitiviti', 'sensibiliti', 'triplicate', 'formative',
+            'formalize', 'electriciti', 'electrical', 'hopeful', 'goodness', 'revival', 'allowance',
+            'inference', 'airliner', 'gyroscopic', 'adjustable', 'defensible', 'irritant',
+            'replacement', 'adjustment', 'dependent', 'adoption', 'homologou', 'communism',
+            'activate', 'angulariti', 'homologous', 'effective', 'bowdlerize', 'probate', 'rate',
+            'cease', 'controll', 'roll', 'generalization', 'yelling', 'youth', 'is', 'a'];
+        return words.map(function(w) {
+            return t.tokens(w, {filters: ['porter_en']})[0];
+        });
+    }, ['caress', 'poni', 'ti', 'caress', 'cat', 'feed', 'agre', 'plaster',
+        'motor', 'sing', 'conflat', 'troubl', 'size', 'hop', 'fall', 'hiss',
+        'fizz', 'fail', 'file', 'happi', 'sky', 'relat', 'condit', 'ration',
+        'valenc', 'digit', 'conform', 'radic', 'differ', 'vile', 'analog',
+        'vietnam', 'predic', 'oper', 'feudal', 'decis', 'hope',
+        'callous', 'formal', 'sensit', 'sensibl', 'triplic', 'form',
+        'formal', 'electr', 'electr', 'hope', 'good', 'reviv', 'allow',
+        'infer', 'airlin', 'gyroscop', 'adjust', 'defens', 'irrit',
+        'replac', 'adjust', 'depend', 'adopt', 'homolog', 'commun',
+        'activ', 'angular', 'homolog', 'effect', 'bowdler', 'probat', 'rate',
+        'ceas', 'control', 'roll', 'gener', 'yell', 'youth', 'is', 'a']],
+
+    ['custom pipeline', function(t) { return t.tokens('The Cats', {tokenizer: 'whitespace', filters: ['lower', 'stop_en']}); }, ['cats']],
+    ['function filter', function(t) {
+        return t.tokens('e-mail me', {analyzer: 'whitespace', filters: [function(tok) { return tok.split('-'); }, 'lower']});
+    }, ['e', 'mail', 'me']],
+    ['function filter drops', function(t) {
+        return t.tokens('a bb ccc', {filters: [function(tok) { return tok.length > 1 ? tok : null; }]});
+    }, ['bb', 'ccc']],
+    ['function tokenizer', function(t) { return t.tokens('a;b;;c', {tokenizer: function(s) { return s.split(';'); }}); }, ['a', 'b', 'c']],
+    ['no filters', function(t) { return t.tokens('ABC def', {filters: []}); }, ['ABC', 'def']],
+
+    ['ngram', function(t) { return t.tokens('abcd x', {ngram: {min: 2, max: 3}}); }, ['ab', 'abc', 'bc', 'bcd', 'cd', 'x']],
+    ['ngram edge', function(t) { return t.tokens('Search', {ngram: {min: 1, max: 4, edge: true}}); }, ['s', 'se', 'sea', 'sear']],
+    ['ngram default max', function(t) { return t.tokens('abc', {ngram: {min: 2}}); }, ['ab', 'bc']],
+    ['ngram astral', function(t) { return t.tokens('a𝒳b', {ngram: {min: 2, max: 2}, filters: []}); }, ['a𝒳', '𝒳b']],
+    ['ngram bad', function(t) { return throws(function() { t.tokens('abc', {ngram: {min: 3, max: 2}}); }); }, true],
+    ['shingles', function(t) { return t.tokens('new york city', {shingles: 3}); }, ['new', 'york', 'city', 'new york', 'york city', 'new york city']],
+    ['shingles and ngram', function(t) { return throws(function() { t.tokens('a b', {shingles: 2, ngram: {min: 1}}); }); }, true],
+    ['shingles bad', function(t) { return throws(function() { t.tokens('a b', {shingles: 0}); }); }, true],
+    ['unique', function(t) { return t.tokens('run Runs running ran', {analyzer: 'en', unique: true}); }, ['run', 'ran']],
+    ['unique proto keys', function(t) { return t.tokens('constructor __proto__ constructor', {unique: true}); }, ['constructor', '__proto__']],
+
+    ['unknown analyzer', function(t) { return throws(function() { t.tokens('a', {analyzer: 'fr'}); }); }, true],
+    ['unknown filter', function(t) { return throws(function() { t.tokens('a', {filters: ['nope']}); }); }, true],
+    ['inherited name', function(t) { return throws(function() { t.tokens('a', {analyzer: 'toString'}); }); }, true],
+    ['filters not array', function(t) { return throws(function() { t.tokens('a', {filters: 'lower'}); }); }, true]
+];
+
+function throws(fn) {
+    try {
+        fn();
+    } catch (e) {
+        return true;
+    }
+    return false;
+}
+
+// Runs the cases against text, returns the number of failures
+function runTextTests(text, out) {
+    var failures = 0;
+    for (var i = 0; i < textTestCases.length; i++) {
+        var name = textTestCases[i][0], expected = textTestCases[i][2], actual;
+        try {
+            actual = textTestCases[i][1](text);
+        } catch (e) {
+            actual = 'exception: ' + e;
+        }
+
+        if (JSON.stringify([actual]) !== JSON.stringify([expected])) {
+            failures++;
+            out('FAIL ' + name + ': got ' + JSON.stringify(actual) + ', expected ' + JSON.stringify(expected));
+        }
+    }
+    out((textTestCases.length - failures) + ' passed, ' + failures + ' failed');
+    return failures;
+}
+
+if (typeof module !== 'undefined' && module.exports && typeof require === 'function') {
+    var fs = require('fs'), path = require('path'), vm = require('vm');
+    var header = fs.readFileSync(path.join(__dirname, 'Text.h'), 'utf8');
+    var source = header.substring(header.indexOf('R"JS(') + 5, header.indexOf(')JS"'));
+
+    var context = vm.createContext({});
+    vm.runInContext(source, context, {filename: 'text'});
+    process.exitCode = runTextTests(context.text, console.log) > 0 ? 1 : 0;
+} else if (typeof text !== 'undefined') {
+    runTextTests(text, typeof print === 'function' ? print : console.log);
+}
@@ -1,81 +1,152 @@
 #include "v8Instance.hpp"
 
-void Generate(v8::Local<v8::Value> value,int* typeArray,ValueForType* ValArray,v8::Isolate* isolate,int& index,int& Vindex){
+//emit(cell) for every cell of a geo cover is EMITSTART, STRING and EMITEND
+static_assert(3*GEO_MAX_COVER_CELLS<=MAX_EMIT_VALUES,"a geo cover must fit in the emits of a document");
+
+//Appends value to the type and value arrays of msg, false once they hold
+//MAX_EMIT_VALUES entries
+bool Generate(v8::Local<v8::Value> value,msg_response* msg,v8::Isolate* isolate){
+    if(msg->type.size()>=MAX_EMIT_VALUES){
+        return false;
+    }
+
     if(value->IsString()){
-        typeArray[index++]=STRING;
+        msg->type.push_back(STRING);
         v8::String::Utf8Value const strResult(value);
-        ValArray[Vindex++].stringValue=std::string(*strResult, strResult.length());
-        return;
+        ValueForType v;
+        v.stringValue=std::string(*strResult, strResult.length());
+        msg->arr.push_back(v);
+        return true;
     }
     
     if(value->IsNumber()){
+        ValueForType v;
         if(value->IsInt32() || value->IsUint32()){
-            typeArray[index++]=INTNUMBER;
-            ValArray[Vindex++].intValue=value->IntegerValue();
-            
+            msg->type.push_back(INTNUMBER);
+            v.intValue=value->IntegerValue();
         }else{
-            typeArray[index++]=FLOATNUMBER;
-            ValArray[Vindex++].doubleValue=value->NumberValue();
+            msg->type.push_back(FLOATNUMBER);
+            v.doubleValue=value->NumberValue();
         }
-        return;
+        msg->arr.push_back(v);
+        return true;
     }
     
     if(value->IsBoolean()){
-        if(value->IsTrue()){
-            typeArray[index++]=BOOLEANTRUE;
-        }else{
-            typeArray[index++]=BOOLEANFALSE;
-        }
-        return;
+        msg->type.push_back(value->IsTrue() ? BOOLEANTRUE : BOOLEANFALSE);
+        return true;
     }
     
     if(value->IsArray()){
-        typeArray[index++]=ARRAYSTART;
+        msg->type.push_back(ARRAYSTART);
         v8::Handle<v8::Array> array = v8::Handle<v8::Array>::Cast(value);
-        for(int i=0;i<array->Length();i++){
-            Generate(array->Get(i),typeArray,ValArray,isolate,index,Vindex);
+        for(uint32_t i=0;i<array->Length();i++){
+            if(!Generate(array->Get(i),msg,isolate)){
+                return false;
+            }
         }
-        typeArray[index++]=ARRAYEND;
-        return;
+        if(msg->type.size()>=MAX_EMIT_VALUES){
+            return false;
+        }
+        msg->type.push_back(ARRAYEND);
+        return true;
     }
     
     if(value->IsMap()){
-        typeArray[index++]=MAPSTART;
+        msg->type.push_back(MAPSTART);
         v8::Local<v8::Map> map = v8::Local<v8::Map>::Cast(value);
         v8::Local<v8::Array> array = map->AsArray();
-        ValArray[Vindex++].intValue=value->Int32Value();
-        for(int i=0;i<array->Length();i++){
-            Generate(array->Get(i),typeArray,ValArray,isolate,index,Vindex);
+        ValueForType v;
+        v.intValue=value->Int32Value();
+        msg->arr.push_back(v);
+        for(uint32_t i=0;i<array->Length();i++){
+            if(!Generate(array->Get(i),msg,isolate)){
+                return false;
+            }
         }
-        typeArray[index++]=MAPEND;
-        return;
+        if(msg->type.size()>=MAX_EMIT_VALUES){
+            return false;
+        }
+        msg->type.push_back(MAPEND);
+        return true;
     }
 
     if(value->IsUndefined() || value->IsNull()){
-        typeArray[index++]=UNDEFINED;
-        return;
+        msg->type.push_back(UNDEFINED);
+        return true;
     }
     
     if(value->IsObject()){
-        typeArray[index++]=JSONSTRING;
+        msg->type.push_back(JSONSTRING);
         v8::Local<v8::Object> json = isolate->GetCurrentContext()->Global()->Get(v8::String::NewFromUtf8(isolate, "JSON"))->ToObject();
         v8::Local<v8::Function> stringify = json->Get(v8::String::NewFromUtf8(isolate, "stringify")).As<v8::Function>();
         v8::Local<v8::Value> result;
         result = stringify->Call(json, 1, &value);
         
         v8::String::Utf8Value const strResult(result);
-        ValArray[Vindex++].stringValue=std::string(*strResult, strResult.length());
+        ValueForType v;
+        v.stringValue=std::string(*strResult, strResult.length());
+        msg->arr.push_back(v);
     }
+    return true;
 }
 
+//Every call is kept, between EMITSTART and EMITEND: each is an entry of
+//the document. A call that does not fit in MAX_EMIT_VALUES is undone and
+//throws, which fails the document.
 void Emit(const v8::FunctionCallbackInfo<v8::Value>& args){
         auto isolate=args.GetIsolate();
         auto x = (Data *)isolate->GetData(0);
-        int index=0,valueIndex=0;
-        for(int i=0;i<args.Length();i++){
-            Generate(args[i],x->Rmsg->type,x->Rmsg->arr,isolate,index,valueIndex);
+        if(!x->Rmsg){
+            return;//code at global scope, run on compile, maps no document
+        }
+        auto types=x->Rmsg->type.size(), values=x->Rmsg->arr.size();
+        bool fits=types+2<=MAX_EMIT_VALUES;
+        if(fits){
+            x->Rmsg->type.push_back(EMITSTART);
+            for(int i=0;i<args.Length() && fits;i++){
+                fits=Generate(args[i],x->Rmsg,isolate);
+            }
+            fits=fits && x->Rmsg->type.size()<MAX_EMIT_VALUES;
+        }
+        if(!fits){
+            x->Rmsg->type.resize(types);
+            x->Rmsg->arr.resize(values);
+            isolate->ThrowException(v8::Exception::RangeError(v8::String::NewFromUtf8(isolate,
+                ("emit: a document may emit at most "+std::to_string(MAX_EMIT_VALUES)+" values").c_str())));
+            return;
         }
-        x->Rmsg->length=index;
+        x->Rmsg->type.push_back(EMITEND);
+        x->Rmsg->length=x->Rmsg->type.size();
+        x->Rmsg->ValueLength=x->Rmsg->arr.size();
+        x->Rmsg->emits++;
+}
+
+//Appends a line to the console output of the current document, objects
+//as JSON like emit does
+void Log(const v8::FunctionCallbackInfo<v8::Value>& args){
+    auto isolate=args.GetIsolate();
+    auto x = (Data *)isolate->GetData(0);
+    if(!x->Rmsg){
+        return;
+    }
+    std::string line;
+    for(int i=0;i<args.Length();i++){
+        v8::Local<v8::Value> value=args[i];
+        if(value->IsObject() && !value->IsFunction()){
+            v8::Local<v8::Object> json = isolate->GetCurrentContext()->Global()->Get(v8::String::NewFromUtf8(isolate, "JSON"))->ToObject();
+            v8::Local<v8::Function> stringify = json->Get(v8::String::NewFromUtf8(isolate, "stringify")).As<v8::Function>();
+            value = stringify->Call(json, 1, &value);
+        }
+        v8::String::Utf8Value const str(value);
+        if(i>0){
+            line+=" ";
+        }
+        line+= *str ? std::string(*str, str.length()) : "undefined";
+    }
+    if(x->Rmsg->console.size()+line.size() < MAX_CONSOLE_OUTPUT){
+        x->Rmsg->console+=line+"\n";
+    }
 }
 
 v8Instance::v8Instance(v8::Platform *platform){
@@ -86,47 +157,97 @@ v8Instance::v8Instance(v8::Platform *platform){
     v8::Isolate::Scope isolate_scope(GetIsolate());
     v8::HandleScope handle_scope(GetIsolate());
     isolate_->SetData(0, &data);
-    data.Rmsg=new msg_response();
+    data.Rmsg=nullptr;
+}
+
+//Globals installed in every context functions run in
+v8::Local<v8::ObjectTemplate> v8Instance::GlobalTemplate(){
     v8::Local<v8::ObjectTemplate> global = v8::ObjectTemplate::New(GetIsolate());
     global->Set(v8::String::NewFromUtf8(GetIsolate(), "emit"),v8::FunctionTemplate::New(GetIsolate(), Emit));
-    auto context = v8::Context::New(GetIsolate(), nullptr, global);
-    context_.Reset(GetIsolate(), context);
+    auto log = v8::FunctionTemplate::New(GetIsolate(), Log);
+    global->Set(v8::String::NewFromUtf8(GetIsolate(), "log"),log);
+    v8::Local<v8::ObjectTemplate> console = v8::ObjectTemplate::New(GetIsolate());
+    console->Set(v8::String::NewFromUtf8(GetIsolate(), "log"),log);
+    global->Set(v8::String::NewFromUtf8(GetIsolate(), "console"),console);
+    return global;
 }
 
-v8Instance::~v8Instance(){
-    context_.Reset();
+//Preludes run in every new context, after the global template: the
+//helpers of Helpers.h, the text analysis of Text.h and the geohash cells
+//of Geo.h
+static const struct {
+    const char* name;
+    const char* source;
+} preludes[] = {
+    {"helpers", HELPERS_SOURCE},
+    {"text", TEXT_SOURCE},
+    {"geo", GEO_SOURCE},
+};
+
+//Runs the preludes in a new context, they cannot be part of the global
+//template as they are JavaScript
+bool v8Instance::InstallHelpers(v8::Local<v8::Context> context){
+    v8::Context::Scope context_scope(context);
+    for (auto const& prelude : preludes) {
+        v8::TryCatch try_catch(GetIsolate());
+        v8::ScriptOrigin origin(v8::String::NewFromUtf8(GetIsolate(), prelude.name));
+        v8::Local<v8::Script> script;
+        v8::Local<v8::Value> result;
+        if (!v8::Script::Compile(context, v8::String::NewFromUtf8(GetIsolate(), prelude.source), &origin).ToLocal(&script) ||
+            !script->Run(context).ToLocal(&result)) {
+            v8::String::Utf8Value const exception(try_catch.Exception());
+            std::cerr<<"Helpers ERROR "<<prelude.name<<": "<<(*exception ? *exception : "unknown error")<<"\n";
+            return false;
+        }
+    }
+    return true;
+}
 
+v8Instance::~v8Instance(){
+    for(auto& it : on_map_){
+        it.second.Reset();
+    }
+    for(auto& it : contexts_){
+        it.second.Reset();
+    }
 }
 
-int v8Instance::v8WorkLoad(std::string jsFile,const char* code){
+//Compiles code in a context of its own, so that loading a function, or
+//another version of it, never changes the globals of those loaded before,
+//and looks the entry point up there
+int v8Instance::v8WorkLoad(std::string jsFile,const char* code,const char* entryPoint){
     v8::Locker locker(isolate_);
     v8::Isolate::Scope isolate_scope(isolate_);
     v8::HandleScope handle_scope(isolate_);
     
-    auto context = context_.Get(isolate_);
+    auto context = v8::Context::New(GetIsolate(), nullptr, GlobalTemplate());
+    if(!InstallHelpers(context)){
+        return 0;
+    }
     v8::Context::Scope context_scope(context);
     v8::Local<v8::String> file_name = v8::String::NewFromUtf8(GetIsolate(), jsFile.c_str(), v8::NewStringType::kNormal).ToLocalChecked();
     
     v8::Local<v8::String> jsCode=v8::String::NewFromUtf8(isolate_, code);
-    if(!ExecuteScript(jsCode,file_name)){
+    if(!ExecuteScript(context,jsCode,file_name)){
         std::cerr<<"COMPILATION ERROR\n";
+        return 0;
     }
     
-    v8::Local<v8::String> on_map = v8::String::NewFromUtf8(isolate_, "OnMap", v8::NewStringType::kNormal).ToLocalChecked();
+    v8::Local<v8::String> on_map = v8::String::NewFromUtf8(isolate_, entryPoint, v8::NewStringType::kNormal).ToLocalChecked();
     auto onMapDef = context->Global()->Get(on_map);
     if (onMapDef->IsFunction()){
         v8::Local<v8::Function> on_map_def = v8::Local<v8::Function>::Cast(onMapDef);
         on_map_[jsFile].Reset(isolate_, on_map_def);
+        contexts_[jsFile].Reset(isolate_, context);
         return 1;
     }
     return 0;
 }
 
-bool v8Instance::ExecuteScript(v8::Local<v8::String> source,v8::Local<v8::String> name){
+bool v8Instance::ExecuteScript(v8::Local<v8::Context> context,v8::Local<v8::String> source,v8::Local<v8::String> name){
     v8::HandleScope handle_scope(GetIsolate());
     v8::TryCatch try_catch(GetIsolate());
     
-    auto context = context_.Get(GetIsolate());
     v8::ScriptOrigin origin(name);
     
     v8::Local<v8::Script> compiled_script;
@@ -155,26 +276,144 @@ v8::Handle<v8::Object> v8Instance::ParseString(metaData meta){
     return Meta;
 }
 
-msg_response* v8Instance::Map(metaData meta,const char* doc,std::string jsFile){
+//Terminates the execution of an isolate unless Done is called within
+//timeoutMs, none when timeoutMs is 0. TerminateExecution may be called
+//from any thread, the isolate being locked by the one running.
+class Watchdog{
+    v8::Isolate* isolate_;
+    std::mutex mutex_;
+    std::condition_variable cond_;
+    bool done_=false;
+    bool fired_=false;
+    std::thread thread_;
+public:
+    Watchdog(v8::Isolate* isolate,int timeoutMs):isolate_(isolate){
+        if(timeoutMs<=0){
+            return;
+        }
+        thread_=std::thread([this,timeoutMs](){
+            std::unique_lock<std::mutex> lock(mutex_);
+            if(!cond_.wait_for(lock,std::chrono::milliseconds(timeoutMs),[this]{return done_;})){
+                fired_=true;
+                isolate_->TerminateExecution();
+            }
+        });
+    }
+    //Stops the watchdog, true when it terminated the execution
+    bool Done(){
+        {
+            std::lock_guard<std::mutex> lock(mutex_);
+            done_=true;
+        }
+        cond_.notify_one();
+        if(thread_.joinable()){
+            thread_.join();
+        }
+        return fired_;
+    }
+    ~Watchdog(){
+        Done();
+    }
+};
+
+//Evaluates the entry point against a document. The response is allocated
+//for this call and belongs to the caller, which frees it with freeResponse:
+//other documents are mapped on the isolate as soon as the locker is
+//released, while the caller still reads it.
+msg_response* v8Instance::Map(metaData meta,const char* doc,std::string jsFile,int timeoutMs){
     v8::Locker locker(GetIsolate());
     v8::Isolate::Scope isolate_scope(GetIsolate());
     v8::HandleScope handle_scope(GetIsolate());
-    auto context = context_.Get(GetIsolate());
+    msg_response* resp=new msg_response();
+    auto loaded = contexts_.find(jsFile);
+    if(loaded==contexts_.end()){
+        resp->failed=1;
+        resp->exception="function "+jsFile+" is not loaded";
+        return resp;
+    }
+    auto context = loaded->second.Get(GetIsolate());
     v8::Context::Scope context_scope(context);
     v8::TryCatch try_catch(GetIsolate());
     auto x = (Data *)GetIsolate()->GetData(0);
     args[0]= ParseString(meta);
     args[1] = v8::JSON::Parse(v8::String::NewFromUtf8(GetIsolate(), doc));
     auto map = on_map_[jsFile].Get(GetIsolate());
-    x->Rmsg->ValueLength=0;
-    x->Rmsg->length=0;
+    x->Rmsg=resp;
+    Watchdog watchdog(GetIsolate(),timeoutMs);
     map->Call(context->Global(), 2, args);
-    if (try_catch.HasCaught()){
+    if (watchdog.Done()){
+        GetIsolate()->CancelTerminateExecution();
+        x->Rmsg->failed=1;
+        x->Rmsg->exception = "terminated after running for " + std::to_string(timeoutMs) + " ms";
+    } else if (try_catch.HasCaught()){
         std::cerr<<"Error in Running\n";
+        x->Rmsg->failed=1;
+        v8::String::Utf8Value const exception(try_catch.Exception());
+        x->Rmsg->exception = *exception ? std::string(*exception, exception.length()) : "unknown error";
+        v8::Local<v8::Message> message = try_catch.Message();
+        if (!message.IsEmpty()){
+            x->Rmsg->exception += " at line " + std::to_string(message->GetLineNumber(context).FromMaybe(0));
+        }
     }
-    return x->Rmsg;
+    x->Rmsg=nullptr;
+    return resp;
 }
 
+void v8Instance::Unload(std::string jsFile){
+    v8::Locker locker(GetIsolate());
+    auto it=on_map_.find(jsFile);
+    if(it!=on_map_.end()){
+        it->second.Reset();
+        on_map_.erase(it);
+    }
+    auto ctx=contexts_.find(jsFile);
+    if(ctx!=contexts_.end()){
+        ctx->second.Reset();
+        contexts_.erase(ctx);
+    }
+}
 
+//Compiles and runs code in a throwaway context, so that validating a
+//function never changes the functions indexes are evaluated with
+void v8Instance::Validate(const char* code,validate_response* resp){
+    v8::Locker locker(GetIsolate());
+    v8::Isolate::Scope isolate_scope(GetIsolate());
+    v8::HandleScope handle_scope(GetIsolate());
+    auto context = v8::Context::New(GetIsolate(), nullptr, GlobalTemplate());
+    InstallHelpers(context);
+    v8::Context::Scope context_scope(context);
+    v8::TryCatch try_catch(GetIsolate());
 
+    resp->line=0;
+    resp->column=0;
+    v8::Local<v8::String> source=v8::String::NewFromUtf8(GetIsolate(), code);
+    v8::ScriptOrigin origin(v8::String::NewFromUtf8(GetIsolate(), "validate"));
+    v8::Local<v8::Script> script;
+    v8::Local<v8::Value> result;
+    if (!v8::Script::Compile(context, source, &origin).ToLocal(&script) ||
+        !script->Run(context).ToLocal(&result)) {
+        v8::Local<v8::Message> message = try_catch.Message();
+        v8::String::Utf8Value const exception(try_catch.Exception());
+        resp->error = *exception ? std::string(*exception, exception.length()) : "unknown error";
+        if (!message.IsEmpty()){
+            resp->line = message->GetLineNumber(context).FromMaybe(0);
+            resp->column = message->GetStartColumn(context).FromMaybe(0);
+        }
+        return;
+    }
 
+    auto global = context->Global();
+    v8::Local<v8::Array> names;
+    if (!global->GetOwnPropertyNames(context).ToLocal(&names)){
+        return;
+    }
+    for(uint32_t i=0;i<names->Length();i++){
+        auto name = names->Get(i);
+        auto value = global->Get(name);
+        v8::String::Utf8Value const fname(name);
+        std::string entry(*fname, fname.length());
+        if (value->IsFunction() && entry != "emit" && entry != "log"){
+            resp->entryPoints.push_back(entry);
+        }
+    }
+}
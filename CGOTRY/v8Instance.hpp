@@ -5,12 +5,20 @@
 #include<map>
 #include<stdlib.h>
 #include<iostream>
+#include<thread>
+#include<mutex>
+#include<condition_variable>
 #include<v8.h>
 #include "Messages.h"
 #include "Wrapper.h"
+#include "Helpers.h"
+#include "Text.h"
+#include "Geo.h"
+
+#define MAX_CONSOLE_OUTPUT 65536
 
 struct Data{
-    msg_response * Rmsg; //It should not be a string
+    msg_response * Rmsg; //response of the document being mapped, null otherwise
 };
 
 enum TYPE{
@@ -31,7 +39,6 @@ enum TYPE{
 
 class v8Instance{
     v8::Isolate *isolate_;
-    v8::Persistent<v8::Context> context_;
     Data data;
     v8::Local<v8::Value> args[2];
     
@@ -40,14 +47,19 @@ public:
     v8Instance(v8::Platform *platform); //same
     ~v8Instance();
     v8::Isolate *GetIsolate() { return isolate_; }
-    int v8WorkLoad(std::string source_path,const char* code);
+    int v8WorkLoad(std::string source_path,const char* code,const char* entryPoint);
     void Start();
-    msg_response* Map(metaData value,const char* doc,std::string jsFile);
+    msg_response* Map(metaData value,const char* doc,std::string jsFile,int timeoutMs=0);
+    void Unload(std::string jsFile);
+    void Validate(const char* code,validate_response* resp);
     
 private:
     std::map<std::string,v8::Persistent<v8::Function>> on_map_;
+    std::map<std::string,v8::Persistent<v8::Context>> contexts_;//one per function loaded
     v8::Handle<v8::Object> ParseString(metaData meta);
-    bool ExecuteScript(v8::Local<v8::String> source,v8::Local<v8::String> name);
+    v8::Local<v8::ObjectTemplate> GlobalTemplate();
+    bool InstallHelpers(v8::Local<v8::Context> context);
+    bool ExecuteScript(v8::Local<v8::Context> context,v8::Local<v8::String> source,v8::Local<v8::String> name);
 };
 
 
@@ -1,14 +1,21 @@
 package protobuf
 
-// #cgo CXXFLAGS: -I/Users/ankitdamodarprabhu/.cbdepscache/include -std=c++11
-// #cgo LDFLAGS: -L/Users/ankitdamodarprabhu/Library/Developer/Xcode/DerivedData/CGOTRY-ehckjyzrynwdcmagdpsapaiqvwze/Build/Products/Debug -L/Users/ankitdamodarprabhu/.cbdepscache/lib -L/Users/ankitdamodarprabhu/.cbdepscache/lib/Release -L/usr/lib -lc++ -lv8_libplatform -lv8_libbase -licui18n -licuuc -lv8 -lCGOTRY
-// #include "/Users/ankitdamodarprabhu/project/CGOTRY/CGOTRY/Wrapper.h"
+// Headers and libraries of v8 come from CGO_CXXFLAGS and CGO_LDFLAGS, see
+// README.md, libCGOTRY.a and its headers from this tree.
+//
+// #cgo CXXFLAGS: -I${SRCDIR}/../CGOTRY -std=c++11
+// #cgo LDFLAGS: -L${SRCDIR}/.. -lCGOTRY -lv8_libplatform -lv8_libbase -licui18n -licuuc -lv8 -lc++
+// #include "Wrapper.h"
 //#include<stdlib.h>
 //#include<stdio.h>
 import "C"
 
+import "errors"
+import "fmt"
 import "unsafe"
 import "strconv"
+import "sync"
+import "time"
 import "github.com/couchbase/indexing/secondary/logging"
 import "github.com/couchbase/indexing/secondary/collatejson"
 
@@ -16,8 +23,36 @@ type JSEvaluate struct {
 	jsfile *C.char
 	E      C.EngineObj
 	code *C.char
+	entry  *C.char
+
+	// ArrayKey is set for array indexes: every emit call is an entry of
+	// the document, otherwise a document emits at most once.
+	ArrayKey bool
+
+	// Timeout terminates the entry point once it ran that long for a
+	// document, which then fails. Zero is no limit.
+	Timeout time.Duration
+}
+
+// JSEngineIsolates is the number of isolates of the engine.
+const JSEngineIsolates = 2
+
+var jsEngineOnce sync.Once
+var jsEngine C.EngineObj
+
+// Returns the engine every function of this process is evaluated and
+// validated in, created on first use and never destroyed.
+func sharedJSEngine() C.EngineObj {
+	jsEngineOnce.Do(func() {
+		jsEngine = C.CreateEngine(JSEngineIsolates)
+	})
+	return jsEngine
 }
 
+// ErrorJSMultipleEmits is returned when a function emits more than once
+// for a document of an index that is not an array index.
+var ErrorJSMultipleEmits = errors.New("protobuf.jsMultipleEmits")
+
 const (
 	STRING C.int = iota
 	INT
@@ -36,36 +71,210 @@ const (
 
 const CTerminator = byte(0)
 
-func NewJSEvaluator(file string,code string) *JSEvaluate {
-	J := &JSEvaluate{E: C.CreateEngine(2), jsfile: C.CString(file), code: C.CString(code)}
+// Evaluators per file loaded in the engine, evaluators of the same file
+// share its code, which is unloaded when the last of them is closed.
+var jsLoadedMu sync.Mutex
+var jsLoaded = make(map[string]int)
+
+func NewJSEvaluator(file string,code string,entryPoint string) *JSEvaluate {
+	J := &JSEvaluate{E: sharedJSEngine(), jsfile: C.CString(file), code: C.CString(code), entry: C.CString(entryPoint)}
+	jsLoadedMu.Lock()
+	jsLoaded[file]++
+	jsLoadedMu.Unlock()
 	return J
 }
 
-func (J *JSEvaluate) Compile() {
-	C.Compile(J.jsfile, J.E, J.code)
+// Close frees the evaluator, which cannot be used afterwards, and unloads
+// its file from the engine unless other evaluators still use it.
+func (J *JSEvaluate) Close() {
+	file := C.GoString(J.jsfile)
+	jsLoadedMu.Lock()
+	if jsLoaded[file]--; jsLoaded[file] <= 0 {
+		delete(jsLoaded, file)
+		C.Unload(J.E, J.jsfile)
+	}
+	jsLoadedMu.Unlock()
+	C.free(unsafe.Pointer(J.jsfile))
+	C.free(unsafe.Pointer(J.code))
+	C.free(unsafe.Pointer(J.entry))
+	J.jsfile, J.code, J.entry = nil, nil, nil
+}
+
+// Evaluates the entry point against a document within J.Timeout, the
+// response is freed with C.freeResponse once read
+func (J *JSEvaluate) route(metaDoc C.struct_metaData, doc []byte) C.returnType {
+	if J.Timeout <= 0 {
+		return C.Route(J.E, metaDoc, (*C.char)(unsafe.Pointer(&doc[0])), J.jsfile)
+	}
+	ms := J.Timeout.Nanoseconds() / int64(time.Millisecond)
+	if ms < 1 {
+		ms = 1
+	}
+	return C.RouteTimeout(J.E, metaDoc, (*C.char)(unsafe.Pointer(&doc[0])), J.jsfile, C.int(ms))
+}
+
+// Compile loads the function into every isolate of the engine. On
+// failure the function is compiled once more in a throwaway context, to
+// report where it is broken.
+func (J *JSEvaluate) Compile() error {
+	if C.Compile(J.jsfile, J.E, J.code, J.entry) != 0 {
+		return nil
+	}
+
+	v := ValidateJS(C.GoString(J.code))
+	if v.Error != "" {
+		return fmt.Errorf("compile error at line %v column %v: %v", v.Line, v.Column, v.Error)
+	}
+	return fmt.Errorf("entry point %v is not declared", C.GoString(J.entry))
+}
+
+// Run evaluates the entry point against a document, failed is set when
+// the entry point threw.
+func (J *JSEvaluate) Run(docid, doc []byte, meta map[string]interface{}, encodeBuf []byte) (key []byte, failed bool) {
+	key, _, failed = J.RunStats(docid, doc, meta, encodeBuf)
+	return key, failed
+}
+
+// RunStats is Run, also returning the encoded size of every index entry
+// the document gets: the key, or for an array index each of its distinct
+// emits as the indexer explodes them.
+func (J *JSEvaluate) RunStats(docid, doc []byte, meta map[string]interface{}, encodeBuf []byte) (key []byte, entries []int, failed bool) {
+	metaDoc := CreateMeta(meta)
+	doc = append(doc, CTerminator)
+	response := J.route(metaDoc, doc)
+	defer C.freeResponse(response)
+	if C.getFailed(response) != 0 {
+		return nil, nil, true
+	}
+	key, entries, err := collateEntries(response, encodeBuf, J.ArrayKey)
+	if err != nil {
+		logging.Debugf("JSEvaluate: doc %v: %v", logging.TagUD(string(docid)), err)
+		return nil, nil, true
+	}
+	return key, entries, false
+}
+
+// JSTrace is the evaluation of the entry point against one document,
+// with what the function printed and threw.
+type JSTrace struct {
+	Key       []byte // collatejson encoded, nil when nothing was emitted
+	Emits     int
+	Failed    bool
+	Exception string
+	Console   string
+	Duration  time.Duration
 }
 
-func (J *JSEvaluate) Run(docid, doc []byte, meta map[string]interface{}, encodeBuf []byte) []byte {
+// RunTrace is RunStats, also returning console output, the exception
+// thrown and how long the entry point ran.
+func (J *JSEvaluate) RunTrace(docid, doc []byte, meta map[string]interface{}, encodeBuf []byte) *JSTrace {
 	metaDoc := CreateMeta(meta)
 	doc = append(doc, CTerminator)
-	response := C.Route(J.E, metaDoc, (*C.char)(unsafe.Pointer(&doc[0])), J.jsfile)
-	logging.Infof("COLLATE VALUE %v",CollateIt(response, encodeBuf))
-	return CollateIt(response, encodeBuf)
+	start := time.Now()
+	response := J.route(metaDoc, doc)
+	defer C.freeResponse(response)
+	trace := &JSTrace{
+		Duration:  time.Since(start),
+		Emits:     int(C.getEmits(response)),
+		Failed:    C.getFailed(response) != 0,
+		Exception: C.GoString(C.getException(response)),
+		Console:   C.GoString(C.getConsole(response)),
+	}
+	if !trace.Failed {
+		key, err := CollateIt(response, encodeBuf, J.ArrayKey)
+		if err != nil {
+			trace.Failed, trace.Exception = true, err.Error()
+		}
+		trace.Key = key
+	}
+	return trace
 }
 
-func CollateIt(response C.returnType, encodebuf []byte) []byte {
+// JSValidation is the result of compiling a function in the engine.
+type JSValidation struct {
+	Error       string // empty when the function compiled and ran
+	Line        int
+	Column      int
+	EntryPoints []string // functions declared at global scope
+}
+
+// ValidateJS compiles and runs code in a throwaway context of the shared
+// engine, reporting the first error and the functions it declares.
+func ValidateJS(code string) *JSValidation {
+	ccode := C.CString(code)
+	defer C.free(unsafe.Pointer(ccode))
+
+	v := C.Validate(sharedJSEngine(), ccode)
+	defer C.freeValidate(v)
+
+	res := &JSValidation{
+		Error:  C.GoString(C.getValidateError(v)),
+		Line:   int(C.getValidateLine(v)),
+		Column: int(C.getValidateColumn(v)),
+	}
+	for i := 0; i < int(C.getEntryPointCount(v)); i++ {
+		res.EntryPoints = append(res.EntryPoints, C.GoString(C.getEntryPoint(v, C.int(i))))
+	}
+	return res
+}
+
+// JSEngineVersion returns the version of the engine functions run in.
+func JSEngineVersion() string {
+	return "v8-" + C.GoString(C.EngineVersion())
+}
+
+// CollateIt encodes what the entry point emitted for a document as the
+// collatejson key of its entries. Every emit call is an entry: emit(k)
+// with an array k is the composite key k, emit(k1, k2, ...) the composite
+// key [k1, k2, ...]. With arrayKey the key is [[e1, e2, ...]], the distinct
+// entries the indexer explodes at c.JSArrayKeyPosition, otherwise the key
+// of the only entry, more than one being ErrorJSMultipleEmits.
+func CollateIt(response C.returnType, encodebuf []byte, arrayKey bool) ([]byte, error) {
+	key, _, err := collateEntries(response, encodebuf, arrayKey)
+	return key, err
+}
+
+// collateEntries is CollateIt, also returning the encoded size of every
+// entry of the key, each exploded entry [e] of an array key.
+func collateEntries(response C.returnType, encodebuf []byte, arrayKey bool) ([]byte, []int, error) {
 	var valIndex int
 	lengthType := int(C.getLength(response))
 	if lengthType == 0 {
-		return nil
+		return nil, nil, nil
+	}
+
+	// bounds of every emit call within encodebuf, with its arguments
+	type emitted struct {
+		start, end, args int
+		array            bool // single argument that is an array
 	}
+	emits := make([]emitted, 0, 1)
+	var depth, start, args int
+	var array bool
+
+	base := len(encodebuf)
 	arrayAddress := uintptr(C.GetTypeArray(response))
 	for i := 0; i < lengthType; i++ {
-		switch *(*C.int)(unsafe.Pointer(arrayAddress + uintptr(4*i))){
+		typ := *(*C.int)(unsafe.Pointer(arrayAddress + uintptr(4*i)))
+		if depth == 1 && typ != EMITEND {
+			args++
+			array = typ == ARRAYSTART
+		}
+		switch typ {
 		case EMITSTART:
-			encodebuf=append(encodebuf,collatejson.TypeArray)
+			depth, start, args, array = 1, len(encodebuf), 0, false
+			continue
 		case EMITEND:
-			encodebuf=append(encodebuf,collatejson.Terminator)
+			depth = 0
+			emits = append(emits, emitted{start, len(encodebuf), args, array && args == 1})
+			continue
+		case ARRAYSTART, MAPSTART:
+			depth++
+		case ARRAYEND, MAPEND:
+			depth--
+		}
+
+		switch typ {
 		case UNDEFINED:
 			encodebuf = append(encodebuf, collatejson.TypeMissing, collatejson.Terminator)
 
@@ -108,6 +317,7 @@ func CollateIt(response C.returnType, encodebuf []byte) []byte {
 
 		case MAPSTART:
 			encodebuf = append(encodebuf, collatejson.TypeObj)
+			valIndex += 1
 
 		case MAPEND:
 			encodebuf = append(encodebuf, collatejson.Terminator)
@@ -115,18 +325,53 @@ func CollateIt(response C.returnType, encodebuf []byte) []byte {
 		case JSONSTRING:
 			codec := collatejson.NewCodec(16)
 			jsonBytes := []byte(C.GoString(C.getJSON(response, C.int(valIndex))))
+			valIndex += 1
 			code := make([]byte, 0, 3*len(jsonBytes))
 			encoded, err := codec.Encode(jsonBytes, code)
 			if err != nil {
-				logging.Infof("ERROR in DECODING %v", err)
-				break
+				return nil, nil, fmt.Errorf("emitted object: %v", err)
 			}
-			valIndex += 1
 			encodebuf = append(encodebuf, encoded...)
 
 		}
 	}
-	return encodebuf
+
+	if len(emits) == 0 {
+		return nil, nil, nil
+	} else if !arrayKey && len(emits) > 1 {
+		return nil, nil, ErrorJSMultipleEmits
+	}
+
+	key := make([]byte, 0, len(encodebuf)-base+4)
+	if arrayKey {
+		key = append(key, collatejson.TypeArray, collatejson.TypeArray)
+	}
+	entries := make([]int, 0, len(emits))
+	seen := make(map[string]bool, len(emits))
+	for _, e := range emits {
+		entry := encodebuf[e.start:e.end]
+		if seen[string(entry)] {
+			continue
+		}
+		seen[string(entry)] = true
+		size := len(key)
+
+		// a single argument is the entry of an array key as it is, a
+		// composite key when it is an array
+		if (arrayKey && e.args == 1) || e.array {
+			key = append(key, entry...)
+		} else {
+			key = append(key, collatejson.TypeArray)
+			key = append(key, entry...)
+			key = append(key, collatejson.Terminator)
+		}
+		entries = append(entries, len(key)-size+2) // exploded as [e]
+	}
+	if !arrayKey {
+		return key, []int{len(key)}, nil
+	}
+	key = append(key, collatejson.Terminator, collatejson.Terminator)
+	return key, entries, nil
 }
 
 func encodeString(s []byte, code []byte) []byte {
@@ -82,6 +82,9 @@ const (
 	INDEX_STATE_ERROR
 	// Nil State (used for no-op / invalid) -- not a persistent state
 	INDEX_STATE_NIL
+	//JavaScript index whose function projectors cannot evaluate, see
+	//IndexInst.Error for the cause
+	INDEX_STATE_FUNC_ERROR
 )
 
 func (s IndexState) String() string {
@@ -101,6 +104,8 @@ func (s IndexState) String() string {
 		return "INDEX_STATE_DELETED"
 	case INDEX_STATE_ERROR:
 		return "INDEX_STATE_ERROR"
+	case INDEX_STATE_FUNC_ERROR:
+		return "INDEX_STATE_FUNC_ERROR"
 	default:
 		return "INDEX_STATE_UNKNOWN"
 	}
@@ -184,7 +189,15 @@ type IndexDefn struct {
 	//PartitionKey is obsolete
 	PartitionKey string `json:"partitionKey,omitempty"`
 	WhereExpr    string `json:"where,omitempty"`
-	JSPath       string `json:"JSPath,omitempty"`
+	// Library function evaluated by a JavaScript index, resolved
+	// once during CREATE INDEX. See ResolveJSFunction.
+	FuncName       string `json:"funcName,omitempty"`
+	FuncVersion    uint64 `json:"funcVersion,omitempty"`
+	FuncEntryPoint string `json:"funcEntryPoint,omitempty"`
+	FuncCode       string `json:"funcCode,omitempty"`
+	FuncHash       string `json:"funcHash,omitempty"`
+	// What projector does with a document when the function throws.
+	FuncFailurePolicy JSFailurePolicy `json:"funcFailurePolicy,omitempty"`
 
 	Desc               []bool   `json:"desc,omitempty"`
 	Deferred           bool     `json:"deferred,omitempty"`
@@ -248,6 +261,11 @@ func (idx IndexDefn) String() string {
 	str += fmt.Sprintf("\n\t\tPartitionScheme: %v ", idx.PartitionScheme)
 	str += fmt.Sprintf("PartitionKeys: %v ", idx.PartitionKeys)
 	str += fmt.Sprintf("WhereExpr: %v ", logging.TagUD(idx.WhereExpr))
+	str += fmt.Sprintf("FuncName: %v ", idx.FuncName)
+	str += fmt.Sprintf("FuncVersion: %v ", idx.FuncVersion)
+	str += fmt.Sprintf("FuncEntryPoint: %v ", idx.FuncEntryPoint)
+	str += fmt.Sprintf("FuncHash: %v ", idx.FuncHash)
+	str += fmt.Sprintf("FuncFailurePolicy: %v ", idx.FuncFailurePolicy)
 	str += fmt.Sprintf("RetainDeletedXATTR: %v ", idx.RetainDeletedXATTR)
 	return str
 
@@ -269,6 +287,12 @@ func (idx IndexDefn) Clone() *IndexDefn {
 		PartitionScheme:    idx.PartitionScheme,
 		PartitionKeys:      idx.PartitionKeys,
 		WhereExpr:          idx.WhereExpr,
+		FuncName:           idx.FuncName,
+		FuncVersion:        idx.FuncVersion,
+		FuncEntryPoint:     idx.FuncEntryPoint,
+		FuncCode:           idx.FuncCode,
+		FuncHash:           idx.FuncHash,
+		FuncFailurePolicy:  idx.FuncFailurePolicy,
 		Deferred:           idx.Deferred,
 		Immutable:          idx.Immutable,
 		Nodes:              idx.Nodes,
@@ -568,6 +592,10 @@ func IsEquivalentIndex(d1, d2 *IndexDefn) bool {
 		}
 	}
 
+	if d1.ExprType == JavaScript && !isEquivalentJSFunction(d1, d2) {
+		return false
+	}
+
 	return true
 }
 
@@ -178,6 +178,44 @@ func (x *PartitionScheme) UnmarshalJSON(data []byte) error {
 	return nil
 }
 
+// What projector does with a document when the library function of a
+// JavaScript index throws.
+type JSFailurePolicy int32
+
+const (
+	// leave the document out of the index.
+	JSFailurePolicy_SKIP_DOCUMENT JSFailurePolicy = 1
+	// index the document under a null key, so that failures stay
+	// visible to queries.
+	JSFailurePolicy_INDEX_NULL JSFailurePolicy = 2
+)
+
+var JSFailurePolicy_name = map[int32]string{
+	1: "SKIP_DOCUMENT",
+	2: "INDEX_NULL",
+}
+var JSFailurePolicy_value = map[string]int32{
+	"SKIP_DOCUMENT": 1,
+	"INDEX_NULL":    2,
+}
+
+func (x JSFailurePolicy) Enum() *JSFailurePolicy {
+	p := new(JSFailurePolicy)
+	*p = x
+	return p
+}
+func (x JSFailurePolicy) String() string {
+	return proto.EnumName(JSFailurePolicy_name, int32(x))
+}
+func (x *JSFailurePolicy) UnmarshalJSON(data []byte) error {
+	value, err := proto.UnmarshalJSONEnum(JSFailurePolicy_value, data, "JSFailurePolicy")
+	if err != nil {
+		return err
+	}
+	*x = JSFailurePolicy(value)
+	return nil
+}
+
 // IndexInst message as payload between co-ordinator, projector, indexer.
 type IndexInst struct {
 	InstId           *uint64          `protobuf:"varint,1,req,name=instId" json:"instId,omitempty"`
@@ -249,8 +287,16 @@ type IndexDefn struct {
 	WhereExpression    *string  `protobuf:"bytes,10,opt,name=whereExpression" json:"whereExpression,omitempty"`
 	PartnExpressions   []string `protobuf:"bytes,11,rep,name=partnExpressions" json:"partnExpressions,omitempty"`
 	RetainDeletedXATTR *bool    `protobuf:"varint,12,opt,name=retainDeletedXATTR" json:"retainDeletedXATTR,omitempty"`
-	XXX_unrecognized   []byte   `json:"-"`
-	JSPath *string `protobuf:"bytes,10,opt,name=JSPath" json:"JSPath,omitempty"`
+	// Library function of a JavaScript index, only sent with
+	// FeedVersion_javascript or later.
+	FuncName          *string          `protobuf:"bytes,13,opt,name=funcName" json:"funcName,omitempty"`
+	FuncVersion       *uint64          `protobuf:"varint,14,opt,name=funcVersion" json:"funcVersion,omitempty"`
+	FuncHash          *string          `protobuf:"bytes,15,opt,name=funcHash" json:"funcHash,omitempty"`
+	FuncEntryPoints   []string         `protobuf:"bytes,16,rep,name=funcEntryPoints" json:"funcEntryPoints,omitempty"`
+	FuncFailurePolicy *JSFailurePolicy `protobuf:"varint,17,opt,name=funcFailurePolicy,enum=protobuf.JSFailurePolicy" json:"funcFailurePolicy,omitempty"`
+	FuncCode          *string          `protobuf:"bytes,18,opt,name=funcCode" json:"funcCode,omitempty"`
+	FuncArrayKey      *bool            `protobuf:"varint,19,opt,name=funcArrayKey" json:"funcArrayKey,omitempty"`
+	XXX_unrecognized  []byte           `json:"-"`
 }
 
 func (m *IndexDefn) Reset()         { *m = IndexDefn{} }
@@ -299,13 +345,6 @@ func (m *IndexDefn) GetExprType() ExprType {
 	return ExprType_JAVASCRIPT
 }
 
-func (m *IndexDefn) GetJSPath() string {
-        if m != nil {
-                return *m.JSPath
-        }
-        return ""
-}
-
 func (m *IndexDefn) GetSecExpressions() []string {
 	if m != nil {
 		return m.SecExpressions
@@ -341,9 +380,59 @@ func (m *IndexDefn) GetRetainDeletedXATTR() bool {
 	return false
 }
 
+func (m *IndexDefn) GetFuncName() string {
+	if m != nil && m.FuncName != nil {
+		return *m.FuncName
+	}
+	return ""
+}
+
+func (m *IndexDefn) GetFuncVersion() uint64 {
+	if m != nil && m.FuncVersion != nil {
+		return *m.FuncVersion
+	}
+	return 0
+}
+
+func (m *IndexDefn) GetFuncHash() string {
+	if m != nil && m.FuncHash != nil {
+		return *m.FuncHash
+	}
+	return ""
+}
+
+func (m *IndexDefn) GetFuncEntryPoints() []string {
+	if m != nil {
+		return m.FuncEntryPoints
+	}
+	return nil
+}
+
+func (m *IndexDefn) GetFuncFailurePolicy() JSFailurePolicy {
+	if m != nil && m.FuncFailurePolicy != nil {
+		return *m.FuncFailurePolicy
+	}
+	return JSFailurePolicy_SKIP_DOCUMENT
+}
+
+func (m *IndexDefn) GetFuncCode() string {
+	if m != nil && m.FuncCode != nil {
+		return *m.FuncCode
+	}
+	return ""
+}
+
+func (m *IndexDefn) GetFuncArrayKey() bool {
+	if m != nil && m.FuncArrayKey != nil {
+		return *m.FuncArrayKey
+	}
+	return false
+}
+
 func init() {
 	proto.RegisterEnum("protobuf.IndexState", IndexState_name, IndexState_value)
 	proto.RegisterEnum("protobuf.StorageType", StorageType_name, StorageType_value)
 	proto.RegisterEnum("protobuf.ExprType", ExprType_name, ExprType_value)
 	proto.RegisterEnum("protobuf.PartitionScheme", PartitionScheme_name, PartitionScheme_value)
+	proto.RegisterEnum("protobuf.JSFailurePolicy", JSFailurePolicy_name, JSFailurePolicy_value)
 }
@@ -1,42 +1,137 @@
 package protobuf
 
+import "errors"
 import "fmt"
+import "sync"
 import "github.com/couchbase/indexing/secondary/logging"
 import c "github.com/couchbase/indexing/secondary/common"
+import "github.com/couchbase/indexing/secondary/collatejson"
 import mcd "github.com/couchbase/indexing/secondary/dcp/transport"
 import mc "github.com/couchbase/indexing/secondary/dcp/transport/client"
-import "github.com/couchbase/eventing/service_manager"
+
+// ErrorJSFunctionHash is returned when function source shipped with the
+// index definition does not match its content hash.
+var ErrorJSFunctionHash = errors.New("protobuf.jsFunctionHashMismatch")
+
+// ErrorJSFunctionMissing is returned for index definitions without the
+// source of their library function.
+var ErrorJSFunctionMissing = errors.New("protobuf.jsFunctionMissing")
+
+// ErrorJSEntryPoints is returned for index definitions naming more entry
+// points than this projector evaluates.
+var ErrorJSEntryPoints = errors.New("protobuf.jsEntryPointsNotSupported")
 
 type IndexJSEvaluator struct {
-	instance *IndexInst
-	version  FeedVersion
-	J        *JSEvaluate
+	// mu is held for reading while a mutation is evaluated, Update takes
+	// it for writing to swap the function between two mutations.
+	mu            sync.RWMutex
+	bucket        string
+	instance      *IndexInst
+	version       FeedVersion
+	failurePolicy JSFailurePolicy
+	J             *JSEvaluate
+
+	seqnoMu sync.Mutex
+	seqnos  map[uint16]uint64 // last seqno seen per vbucket of the feed
 }
 
 func NewIndexJSEvaluator(instance *IndexInst,
 	version FeedVersion) (*IndexJSEvaluator, error) {
 		
-	ie := &IndexJSEvaluator{instance: instance, version: version}
-	funcname:=instance.GetDefinition().GetFuncName()
-	code,err:=servicemanager.GetCode(funcname)
-	logging.Infof("CODE IS %v",code)
-	if err!=nil{
-		logging.Infof("ERROR %v",err)
-		return nil,err
+	defn := instance.GetDefinition()
+	ie := &IndexJSEvaluator{
+		bucket:        defn.GetBucket(),
+		instance:      instance,
+		version:       version,
+		failurePolicy: defn.GetFuncFailurePolicy(),
+		seqnos:        make(map[uint16]uint64),
+	}
+	funcname, code, hash := defn.GetFuncName(), defn.GetFuncCode(), defn.GetFuncHash()
+	if code == "" {
+		logging.Errorf("IndexJSEvaluator: function %v of index %v missing from definition",
+			funcname, defn.GetName())
+		return nil, ErrorJSFunctionMissing
+	} else if c.JSFunctionHash(code) != hash {
+		logging.Errorf("IndexJSEvaluator: function %v of index %v does not match hash %v",
+			funcname, defn.GetName(), hash)
+		return nil, ErrorJSFunctionHash
+	}
+	entryPoint := c.DefaultJSEntryPoint
+	switch entryPoints := defn.GetFuncEntryPoints(); len(entryPoints) {
+	case 0:
+	case 1:
+		entryPoint = entryPoints[0]
+	default:
+		logging.Errorf("IndexJSEvaluator: index %v has entry points %v, only one is supported",
+			defn.GetName(), entryPoints)
+		return nil, ErrorJSEntryPoints
 	}
-	J := NewJSEvaluator(funcname,code)
-        J.Compile()
-        ie.J = J
-	return ie, nil //Compilation error or something
+	// compiled code is keyed by content, so instances bound to the
+	// same revision of a function share it.
+	J := NewJSEvaluator(funcname+"@"+hash, code, entryPoint)
+	if err := J.Compile(); err != nil {
+		J.Close()
+		logging.Errorf("IndexJSEvaluator: function %v of index %v: %v",
+			funcname, defn.GetName(), err)
+		return nil, fmt.Errorf("function %v: %v", funcname, err)
+	}
+	J.ArrayKey = defn.GetFuncArrayKey()
+	ie.J = J
+	return ie, nil
+}
+
+// Update swaps, in place, the definition and function of the index for
+// the ones of next, which must not be used afterwards. It returns per
+// vbucket the last seqno evaluated with the old function, every later
+// mutation is evaluated with the new one. Each function is loaded in a
+// context of its own, so the old one runs unchanged up to the swap.
+func (ie *IndexJSEvaluator) Update(next *IndexJSEvaluator) map[uint16]uint64 {
+	ie.mu.Lock()
+	old := ie.J
+	ie.instance, ie.version, ie.failurePolicy, ie.J =
+		next.instance, next.version, next.failurePolicy, next.J
+	switchSeqnos := ie.lastSeqnos()
+	ie.mu.Unlock()
+
+	old.Close()
+	return switchSeqnos
+}
+
+// Close frees the function of the index, for an evaluator that is
+// dropped or was never used.
+func (ie *IndexJSEvaluator) Close() {
+	ie.mu.Lock()
+	defer ie.mu.Unlock()
+	if ie.J != nil {
+		ie.J.Close()
+		ie.J = nil
+	}
+}
+
+func (ie *IndexJSEvaluator) setSeqno(vbno uint16, seqno uint64) {
+	ie.seqnoMu.Lock()
+	ie.seqnos[vbno] = seqno
+	ie.seqnoMu.Unlock()
+}
+
+func (ie *IndexJSEvaluator) lastSeqnos() map[uint16]uint64 {
+	ie.seqnoMu.Lock()
+	defer ie.seqnoMu.Unlock()
+	seqnos := make(map[uint16]uint64, len(ie.seqnos))
+	for vbno, seqno := range ie.seqnos {
+		seqnos[vbno] = seqno
+	}
+	return seqnos
 }
 
 func (ie *IndexJSEvaluator) Bucket() string {
-	return ie.instance.GetDefinition().GetBucket()
+	return ie.bucket
 }
 
 func (ie *IndexJSEvaluator) StreamBeginData(
 	vbno uint16, vbuuid, seqno uint64) (data interface{}) {
 
+	ie.setSeqno(vbno, seqno)
 	bucket := ie.Bucket()
 	kv := c.NewKeyVersions(seqno, nil, 1, 0 /*ctime*/)
 	kv.AddStreamBegin()
@@ -46,6 +141,7 @@ func (ie *IndexJSEvaluator) StreamBeginData(
 func (ie *IndexJSEvaluator) SyncData(
 	vbno uint16, vbuuid, seqno uint64) (data interface{}) {
 
+	ie.setSeqno(vbno, seqno)
 	bucket := ie.Bucket()
 	kv := c.NewKeyVersions(seqno, nil, 1, 0 /*ctime*/)
 	kv.AddSync()
@@ -64,6 +160,9 @@ func (ie *IndexJSEvaluator) SnapshotData(
 func (ie *IndexJSEvaluator) StreamEndData(
 	vbno uint16, vbuuid, seqno uint64) (data interface{}) {
 
+	ie.seqnoMu.Lock()
+	delete(ie.seqnos, vbno)
+	ie.seqnoMu.Unlock()
 	bucket := ie.Bucket()
 	kv := c.NewKeyVersions(seqno, nil, 1, 0 /*ctime*/)
 	kv.AddStreamEnd()
@@ -80,6 +179,10 @@ func (ie *IndexJSEvaluator) TransformRoute(
 		}
 	}()
 
+	ie.mu.RLock()
+	defer ie.mu.RUnlock()
+	defer ie.setSeqno(m.VBucket, m.Seqno)
+
 	if ie.version < FeedVersion_watson {
 		encodeBuf = nil
 	}
@@ -101,10 +204,10 @@ func (ie *IndexJSEvaluator) TransformRoute(
 	meta := dcpEvent2Meta(m)
 	where:= true
 	if len(m.Value) > 0 {
-		nkey=ie.J.Run(m.Key, m.Value, meta, encodeBuf)
+		nkey = ie.evaluate(m.Key, m.Value, meta, encodeBuf)
 	}
 	if len(m.OldValue) > 0 {
-		okey=ie.J.Run(m.Key, m.OldValue, meta, encodeBuf)
+		okey = ie.evaluate(m.Key, m.OldValue, meta, encodeBuf)
 	}
 	if nkey == nil && okey == nil {
 		where = false
@@ -190,27 +293,29 @@ func (ie *IndexJSEvaluator) TransformRoute(
 	return newBuf, nil
 }
 
-/*
-func Equalise(npkey [][]byte,nkey [][]byte, okey [][]byte)([][]byte,[][]byte,[][]byte,int){
-	maxlength:=int(math.Max(float64(len(npkey)),math.Max(float64(len(okey)),float64(len(nkey)))))
-	npkey=fill(npkey,maxlength)
-	nkey=fill(nkey,maxlength)
-	okey=fill(okey,maxlength)
-	return npkey,nkey,okey,maxlength
-}
+// evaluate runs the library function against a document, applying the
+// index's failure policy when the function throws.
+func (ie *IndexJSEvaluator) evaluate(docid, doc []byte,
+	meta map[string]interface{}, encodeBuf []byte) []byte {
+
+	key, failed := ie.J.Run(docid, doc, meta, encodeBuf)
+	if !failed {
+		return key
+	}
+
+	logging.Debugf("IndexJSEvaluator: inst %v function threw for doc %v, policy %v",
+		ie.instance.GetInstId(), logging.TagUD(string(docid)), ie.failurePolicy)
 
-func fill(arr [][]byte,length int) [][]byte{
-	for i:=len(arr);i<length;i++{
-		arr=append(arr,[]byte{})
+	switch ie.failurePolicy {
+	case JSFailurePolicy_INDEX_NULL:
+		return jsNullKey(encodeBuf)
 	}
-	return arr
+	return nil
 }
 
-func getCode(details []byte) string{
-	var v interface{}
-	json.Unmarshal(details,&v)
-	maps:=v.(map[string]interface{})
-	logging.Infof("METAKV %v",maps)
-	return maps["appcode"].(string)
+// jsNullKey appends to encodeBuf the collatejson key [null] that
+// INDEX_NULL indexes a document with.
+func jsNullKey(encodeBuf []byte) []byte {
+	return append(encodeBuf, collatejson.TypeArray,
+		collatejson.TypeNull, collatejson.Terminator, collatejson.Terminator)
 }
-*/
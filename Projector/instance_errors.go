@@ -0,0 +1,41 @@
+package protobuf
+
+import "encoding/json"
+import "strings"
+
+// InstanceErrors is returned by GetEvaluators when evaluators could not
+// be built for some JavaScript instances, keyed by instance uuid. The
+// request fails as a whole and its TopicResponse.Err carries them, which
+// indexer parses back with ParseInstanceErrors to retry without them.
+type InstanceErrors map[uint64]string
+
+const instanceErrorsPrefix = "protobuf.instanceErrors:"
+
+// Error implement error{} interface
+func (ie InstanceErrors) Error() string {
+	data, err := json.Marshal(map[uint64]string(ie))
+	if err != nil {
+		return instanceErrorsPrefix + "{}"
+	}
+	return instanceErrorsPrefix + string(data)
+}
+
+// ParseInstanceErrors recovers per instance errors from an error
+// received from projector, ok is false for any other error.
+func ParseInstanceErrors(err error) (ie InstanceErrors, ok bool) {
+	if err == nil {
+		return nil, false
+	} else if ie, ok = err.(InstanceErrors); ok {
+		return ie, true
+	}
+
+	msg := err.Error()
+	if !strings.HasPrefix(msg, instanceErrorsPrefix) {
+		return nil, false
+	}
+	ie = make(InstanceErrors)
+	if json.Unmarshal([]byte(msg[len(instanceErrorsPrefix):]), &ie) != nil {
+		return nil, false
+	}
+	return ie, true
+}
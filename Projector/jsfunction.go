@@ -0,0 +1,348 @@
+// Copyright (c) 2014 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package common
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+// metakv path under which eventing service manager stores the latest
+// version of library functions.
+const JSFunctionMetakvPath = "/eventing/view/"
+
+// metakv path under which every saved version of a library function is
+// kept, as <name>/<version>. Versions are never rewritten.
+const JSFunctionVersionsMetakvPath = "/eventing/viewVersions/"
+
+// metakv path under which indexer records the index definitions bound to
+// each library function, as <name>/<defnId>. Eventing service manager
+// refuses to delete a function while it has references.
+const JSFunctionRefsMetakvPath = "/eventing/viewRefs/"
+
+// JSArrayKeyPosition is the position, in the key of a JavaScript array
+// index, of the array of the entries a document emitted. Indexer explodes
+// it into one entry per element, as for the DISTINCT ARRAY of a N1QL
+// array index, rather than finding it by parsing SecExprs.
+const JSArrayKeyPosition = 0
+
+// DefaultJSEntryPoint is invoked for every document when the index
+// does not name an entry point.
+const DefaultJSEntryPoint = "OnMap"
+
+// JSFailurePolicy decides what projector does with a document when the
+// library function of a JavaScript index throws.
+type JSFailurePolicy string
+
+const (
+	// JSSkipDocument leaves the document out of the index.
+	JSSkipDocument JSFailurePolicy = "SKIP_DOCUMENT"
+	// JSIndexNull indexes the document under a null key.
+	JSIndexNull JSFailurePolicy = "INDEX_NULL"
+)
+
+// jsFunction is the library entry as saved by eventing service manager.
+type jsFunction struct {
+	Name      string `json:"appname"`
+	Code      string `json:"appcode"`
+	Version   uint64 `json:"version"`
+	Hash      string `json:"hash"`
+	SourceMap string `json:"source_map,omitempty"` // when Code was transpiled
+
+	Module       bool              `json:"module,omitempty"`
+	Dependencies map[string]uint64 `json:"dependencies,omitempty"` // modules Code requires
+}
+
+func loadJSModule(name string, version uint64) (*JSModule, error) {
+	var fn jsFunction
+	path := JSFunctionVersionsMetakvPath + name + "/" + strconv.FormatUint(version, 10)
+	found, err := MetakvGet(path, &fn)
+	if err != nil || !found {
+		return nil, err
+	} else if fn.Hash != "" && fn.Hash != JSFunctionHash(fn.Code) {
+		return nil, fmt.Errorf("library function %v version %v is corrupt, "+
+			"hash mismatch", name, version)
+	}
+
+	return &JSModule{
+		Name:         name,
+		Version:      version,
+		Code:         fn.Code,
+		Module:       fn.Module,
+		Dependencies: fn.Dependencies,
+	}, nil
+}
+
+// JSFunctionRef records an index definition bound to a library function.
+// Error is set by eventing service manager when the function is deleted
+// by force.
+type JSFunctionRef struct {
+	DefnId  IndexDefnId `json:"defnId"`
+	Bucket  string      `json:"bucket"`
+	Name    string      `json:"name"`
+	Version uint64      `json:"version"`
+	Error   string      `json:"error,omitempty"`
+}
+
+// JSFunctionHash returns the SHA-256 content hash of function source.
+func JSFunctionHash(code string) string {
+	sum := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(sum[:])
+}
+
+// ResolveJSFunction fetches the library function bound to a JavaScript
+// index and embeds its source, entry point and content hash into the
+// definition. It is called once during CREATE INDEX, so that every
+// projector compiles identical code for the life of the index.
+//
+// The definition is pinned to FuncVersion, or to the latest version when
+// FuncVersion is not set. Later saves of the function do not change the
+// meaning of the index, use RepinJSFunction to move it to another version.
+func (idx *IndexDefn) ResolveJSFunction() error {
+
+	if idx.ExprType != JavaScript {
+		return nil
+	}
+
+	if idx.FuncName == "" {
+		return fmt.Errorf("JavaScript index %v has no library function", idx.Name)
+	}
+
+	if idx.FuncVersion == 0 {
+		var latest jsFunction
+		found, err := MetakvGet(JSFunctionMetakvPath+idx.FuncName, &latest)
+		if err != nil {
+			return err
+		} else if !found {
+			return fmt.Errorf("library function %v not found", idx.FuncName)
+		} else if latest.Version == 0 {
+			return fmt.Errorf("library function %v has no saved version", idx.FuncName)
+		}
+		idx.FuncVersion = latest.Version
+	}
+
+	var fn jsFunction
+	path := JSFunctionVersionsMetakvPath + idx.FuncName + "/" +
+		strconv.FormatUint(idx.FuncVersion, 10)
+	found, err := MetakvGet(path, &fn)
+	if err != nil {
+		return err
+	} else if !found {
+		return fmt.Errorf("library function %v version %v not found",
+			idx.FuncName, idx.FuncVersion)
+	}
+
+	hash := JSFunctionHash(fn.Code)
+	if fn.Hash != "" && fn.Hash != hash {
+		return fmt.Errorf("library function %v version %v is corrupt, "+
+			"hash mismatch", idx.FuncName, idx.FuncVersion)
+	}
+
+	if idx.FuncEntryPoint == "" {
+		idx.FuncEntryPoint = DefaultJSEntryPoint
+	}
+	switch idx.FuncFailurePolicy {
+	case "":
+		idx.FuncFailurePolicy = JSSkipDocument
+	case JSSkipDocument, JSIndexNull:
+	default:
+		return fmt.Errorf("invalid failure policy %v for JavaScript index %v",
+			idx.FuncFailurePolicy, idx.Name)
+	}
+
+	// Modules are bundled in, the hash is that of what projector compiles
+	modules, err := ResolveJSModules(idx.FuncName, fn.Dependencies, loadJSModule)
+	if err != nil {
+		return fmt.Errorf("library function %v version %v: %v",
+			idx.FuncName, idx.FuncVersion, err)
+	}
+	idx.FuncCode = BundleJSFunction(fn.Code, fn.Dependencies, modules)
+	idx.FuncHash = JSFunctionHash(idx.FuncCode)
+	return nil
+}
+
+// MapJSFunctionError maps the lines an error of projector refers to, in
+// the code of version of library function funcName, back to the source the
+// function was transpiled from. msg is returned as is for functions that
+// were not transpiled, or when the version cannot be read.
+func MapJSFunctionError(funcName string, version uint64, msg string) string {
+
+	if funcName == "" || version == 0 {
+		return msg
+	}
+
+	var fn jsFunction
+	path := JSFunctionVersionsMetakvPath + funcName + "/" +
+		strconv.FormatUint(version, 10)
+	if found, err := MetakvGet(path, &fn); err != nil || !found {
+		return msg
+	}
+	return MapJSErrorLines(fn.SourceMap, msg)
+}
+
+// RepinJSFunction returns a copy of the definition bound to another
+// version of its library function, to rebuild the index on. Both rolling
+// forward and rolling back are explicit: the caller drops the index and
+// creates the returned definition under a new DefnId.
+func (idx *IndexDefn) RepinJSFunction(version uint64) (*IndexDefn, error) {
+
+	if idx.ExprType != JavaScript {
+		return nil, fmt.Errorf("index %v is not a JavaScript index", idx.Name)
+	}
+
+	if version == 0 {
+		return nil, fmt.Errorf("invalid version %v for library function %v",
+			version, idx.FuncName)
+	}
+
+	defn := idx.Clone()
+	defn.FuncVersion = version
+	defn.FuncCode = ""
+	defn.FuncHash = ""
+	if err := defn.ResolveJSFunction(); err != nil {
+		return nil, err
+	}
+	return defn, nil
+}
+
+// isEquivalentJSFunction tells whether two JavaScript indexes evaluate
+// documents identically. Functions are identified by content, so the
+// same code saved under two names is equivalent, while two versions of
+// one function are not. Definitions not resolved yet fall back to name
+// and version.
+func isEquivalentJSFunction(d1, d2 *IndexDefn) bool {
+
+	if entryPoint(d1) != entryPoint(d2) ||
+		failurePolicy(d1) != failurePolicy(d2) ||
+		d1.IsJSArrayIndex() != d2.IsJSArrayIndex() {
+		return false
+	}
+
+	if d1.FuncHash != "" && d2.FuncHash != "" {
+		return d1.FuncHash == d2.FuncHash
+	}
+
+	return d1.FuncName == d2.FuncName && d1.FuncVersion == d2.FuncVersion
+}
+
+// IsJSArrayIndex tells whether a JavaScript index keeps every emit of a
+// document as an entry, under a key exploded at JSArrayKeyPosition.
+func (idx *IndexDefn) IsJSArrayIndex() bool {
+	return idx.ExprType == JavaScript && idx.IsArrayIndex
+}
+
+func entryPoint(idx *IndexDefn) string {
+	if idx.FuncEntryPoint == "" {
+		return DefaultJSEntryPoint
+	}
+	return idx.FuncEntryPoint
+}
+
+func failurePolicy(idx *IndexDefn) JSFailurePolicy {
+	if idx.FuncFailurePolicy == "" {
+		return JSSkipDocument
+	}
+	return idx.FuncFailurePolicy
+}
+
+func (idx *IndexDefn) jsFunctionRefPath() string {
+	return JSFunctionRefsMetakvPath + idx.FuncName + "/" +
+		strconv.FormatUint(uint64(idx.DefnId), 10)
+}
+
+// RegisterJSFunctionRef records the definition as a dependent of its
+// library function. Indexer calls it once the definition is persisted,
+// and for the definition returned by RepinJSFunction.
+func (idx *IndexDefn) RegisterJSFunctionRef() error {
+
+	if idx.ExprType != JavaScript {
+		return nil
+	}
+
+	ref := &JSFunctionRef{
+		DefnId:  idx.DefnId,
+		Bucket:  idx.Bucket,
+		Name:    idx.Name,
+		Version: idx.FuncVersion,
+	}
+	return MetakvSet(idx.jsFunctionRefPath(), ref)
+}
+
+// UnregisterJSFunctionRef removes the record of the definition, when the
+// index is dropped.
+func (idx *IndexDefn) UnregisterJSFunctionRef() error {
+
+	if idx.ExprType != JavaScript {
+		return nil
+	}
+	return MetakvDel(idx.jsFunctionRefPath())
+}
+
+// JSFunctionRefError returns the error recorded against the definition
+// when its library function was deleted by force, empty otherwise.
+func (idx *IndexDefn) JSFunctionRefError() (string, error) {
+
+	if idx.ExprType != JavaScript {
+		return "", nil
+	}
+
+	var ref JSFunctionRef
+	found, err := MetakvGet(idx.jsFunctionRefPath(), &ref)
+	if err != nil || !found {
+		return "", err
+	}
+	return ref.Error, nil
+}
+
+// Documents a function is tried against, in projector's engine by
+// protobuf.TryJSFunction. They are here so that eventing service manager
+// can take and return them without evaluating anything itself.
+
+// ErrorJSTryTooManyDocs is returned when a try-it request carries more
+// documents than JSTryMaxDocs.
+var ErrorJSTryTooManyDocs = errors.New("common.jsTryTooManyDocs")
+
+// JSTryMaxDocs bounds the documents of one try-it request.
+const JSTryMaxDocs = 100
+
+// JSTryMeta is the meta of a document the entry point is tried against,
+// as passed to the entry point.
+type JSTryMeta struct {
+	ID         string `json:"id"`
+	Cas        uint64 `json:"cas"`
+	Flags      uint32 `json:"flags"`
+	Expiration uint32 `json:"expiration"`
+	Locktime   uint32 `json:"locktime"`
+	Nru        uint8  `json:"nru"`
+	Byseqno    uint64 `json:"byseqno"`
+	Revseqno   uint64 `json:"revseqno"`
+}
+
+// JSTryDoc is a document the entry point is tried against.
+type JSTryDoc struct {
+	Meta JSTryMeta       `json:"meta"`
+	Doc  json.RawMessage `json:"doc"`
+}
+
+// JSTryResult is what the entry point did with one document.
+type JSTryResult struct {
+	ID         string          `json:"id"`
+	Key        json.RawMessage `json:"key,omitempty"`      // decoded from the collatejson key
+	Collated   string          `json:"collated,omitempty"` // hex of the key projector would send
+	KeyError   string          `json:"keyError,omitempty"` // key could not be decoded
+	Emits      int             `json:"emits"`
+	Console    string          `json:"console,omitempty"`
+	Exception  string          `json:"exception,omitempty"`
+	DurationUs int64           `json:"durationUs"`
+}
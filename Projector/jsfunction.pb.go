@@ -0,0 +1,163 @@
+// Code generated by protoc-gen-go.
+// source: jsfunction.proto
+// DO NOT EDIT!
+
+package protobuf
+
+import "github.com/golang/protobuf/proto"
+import math "math"
+
+// Reference imports to suppress errors if they are not otherwise used.
+var _ = proto.Marshal
+var _ = math.Inf
+
+// Requested by indexer during CREATE INDEX, to compile a library function
+// in projector's engine before any stream carries it.
+type ValidateFunctionRequest struct {
+	Name             *string  `protobuf:"bytes,1,req,name=name" json:"name,omitempty"`
+	Code             *string  `protobuf:"bytes,2,req,name=code" json:"code,omitempty"`
+	EntryPoints      []string `protobuf:"bytes,3,rep,name=entryPoints" json:"entryPoints,omitempty"`
+	XXX_unrecognized []byte   `json:"-"`
+}
+
+func (m *ValidateFunctionRequest) Reset()         { *m = ValidateFunctionRequest{} }
+func (m *ValidateFunctionRequest) String() string { return proto.CompactTextString(m) }
+func (*ValidateFunctionRequest) ProtoMessage()    {}
+
+func (m *ValidateFunctionRequest) GetName() string {
+	if m != nil && m.Name != nil {
+		return *m.Name
+	}
+	return ""
+}
+
+func (m *ValidateFunctionRequest) GetCode() string {
+	if m != nil && m.Code != nil {
+		return *m.Code
+	}
+	return ""
+}
+
+func (m *ValidateFunctionRequest) GetEntryPoints() []string {
+	if m != nil {
+		return m.EntryPoints
+	}
+	return nil
+}
+
+// Compile error, or entry point missing from the function.
+type FunctionError struct {
+	Message          *string `protobuf:"bytes,1,req,name=message" json:"message,omitempty"`
+	Line             *uint32 `protobuf:"varint,2,opt,name=line" json:"line,omitempty"`
+	Column           *uint32 `protobuf:"varint,3,opt,name=column" json:"column,omitempty"`
+	XXX_unrecognized []byte  `json:"-"`
+}
+
+func (m *FunctionError) Reset()         { *m = FunctionError{} }
+func (m *FunctionError) String() string { return proto.CompactTextString(m) }
+func (*FunctionError) ProtoMessage()    {}
+
+func (m *FunctionError) GetMessage() string {
+	if m != nil && m.Message != nil {
+		return *m.Message
+	}
+	return ""
+}
+
+func (m *FunctionError) GetLine() uint32 {
+	if m != nil && m.Line != nil {
+		return *m.Line
+	}
+	return 0
+}
+
+func (m *FunctionError) GetColumn() uint32 {
+	if m != nil && m.Column != nil {
+		return *m.Column
+	}
+	return 0
+}
+
+// Response to ValidateFunctionRequest, function is valid when errors is
+// empty.
+type ValidateFunctionResponse struct {
+	EngineVersion    *string          `protobuf:"bytes,1,opt,name=engineVersion" json:"engineVersion,omitempty"`
+	EntryPoints      []string         `protobuf:"bytes,2,rep,name=entryPoints" json:"entryPoints,omitempty"`
+	Errors           []*FunctionError `protobuf:"bytes,3,rep,name=errors" json:"errors,omitempty"`
+	FeedVersion      *FeedVersion     `protobuf:"varint,4,opt,name=feedVersion,enum=protobuf.FeedVersion" json:"feedVersion,omitempty"`
+	XXX_unrecognized []byte           `json:"-"`
+}
+
+func (m *ValidateFunctionResponse) Reset()         { *m = ValidateFunctionResponse{} }
+func (m *ValidateFunctionResponse) String() string { return proto.CompactTextString(m) }
+func (*ValidateFunctionResponse) ProtoMessage()    {}
+
+func (m *ValidateFunctionResponse) GetEngineVersion() string {
+	if m != nil && m.EngineVersion != nil {
+		return *m.EngineVersion
+	}
+	return ""
+}
+
+func (m *ValidateFunctionResponse) GetEntryPoints() []string {
+	if m != nil {
+		return m.EntryPoints
+	}
+	return nil
+}
+
+func (m *ValidateFunctionResponse) GetErrors() []*FunctionError {
+	if m != nil {
+		return m.Errors
+	}
+	return nil
+}
+
+func (m *ValidateFunctionResponse) GetFeedVersion() FeedVersion {
+	if m != nil && m.FeedVersion != nil {
+		return *m.FeedVersion
+	}
+	return FeedVersion_sherlock
+}
+
+// Requested by indexer to swap, in place, the evaluators of instances
+// already on a topic, typically for a new version of their library
+// function. Projector responds with TimestampResponse, whose
+// currentTimestamps carry per vbucket the last seqno evaluated with the
+// old code.
+type UpdateInstancesRequest struct {
+	Topic            *string      `protobuf:"bytes,1,req,name=topic" json:"topic,omitempty"`
+	Instances        []*Instance  `protobuf:"bytes,2,rep,name=instances" json:"instances,omitempty"`
+	Version          *FeedVersion `protobuf:"varint,3,opt,name=version,enum=protobuf.FeedVersion,def=2" json:"version,omitempty"`
+	XXX_unrecognized []byte       `json:"-"`
+}
+
+func (m *UpdateInstancesRequest) Reset()         { *m = UpdateInstancesRequest{} }
+func (m *UpdateInstancesRequest) String() string { return proto.CompactTextString(m) }
+func (*UpdateInstancesRequest) ProtoMessage()    {}
+
+const Default_UpdateInstancesRequest_Version FeedVersion = FeedVersion_watson
+
+func (m *UpdateInstancesRequest) GetTopic() string {
+	if m != nil && m.Topic != nil {
+		return *m.Topic
+	}
+	return ""
+}
+
+func (m *UpdateInstancesRequest) GetInstances() []*Instance {
+	if m != nil {
+		return m.Instances
+	}
+	return nil
+}
+
+func (m *UpdateInstancesRequest) GetVersion() FeedVersion {
+	if m != nil && m.Version != nil {
+		return *m.Version
+	}
+	return Default_UpdateInstancesRequest_Version
+}
+
+func init() {
+}
@@ -0,0 +1,41 @@
+package common
+
+import (
+	"testing"
+)
+
+func TestIsEquivalentJSFunction(t *testing.T) {
+	defn := func(update func(*IndexDefn)) *IndexDefn {
+		idx := &IndexDefn{ExprType: JavaScript, FuncName: "fn", FuncVersion: 1, FuncHash: "h1"}
+		if update != nil {
+			update(idx)
+		}
+		return idx
+	}
+
+	tests := []struct {
+		name       string
+		d2         *IndexDefn
+		equivalent bool
+	}{
+		{"same", defn(nil), true},
+		{"same code under another name",
+			defn(func(idx *IndexDefn) { idx.FuncName, idx.FuncVersion = "other", 3 }), true},
+		{"default entry point",
+			defn(func(idx *IndexDefn) { idx.FuncEntryPoint = DefaultJSEntryPoint }), true},
+		{"another version", defn(func(idx *IndexDefn) { idx.FuncHash = "h2" }), false},
+		{"another entry point", defn(func(idx *IndexDefn) { idx.FuncEntryPoint = "OnOther" }), false},
+		{"another failure policy",
+			defn(func(idx *IndexDefn) { idx.FuncFailurePolicy = JSIndexNull }), false},
+		{"array index", defn(func(idx *IndexDefn) { idx.IsArrayIndex = true }), false},
+		{"unresolved, same version", defn(func(idx *IndexDefn) { idx.FuncHash = "" }), true},
+		{"unresolved, another version",
+			defn(func(idx *IndexDefn) { idx.FuncHash, idx.FuncVersion = "", 2 }), false},
+	}
+
+	for _, test := range tests {
+		if equivalent := isEquivalentJSFunction(defn(nil), test.d2); equivalent != test.equivalent {
+			t.Errorf("%v: expected equivalent %v, got %v", test.name, test.equivalent, equivalent)
+		}
+	}
+}
@@ -0,0 +1,306 @@
+package common
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+)
+
+// JS index functions emit spatial keys with the geo namespace projector's
+// engine installs, see CGOTRY/Geo.h: geohashes of points, or the cells
+// covering a box or a radius. The functions below are the query side of
+// those, computing the same cells with the same arithmetic, so that a
+// radius query turns into the key ranges of the cells the function would
+// have emitted.
+
+const (
+	// GeohashMaxPrecision is the number of characters of the longest
+	// geohash.
+	GeohashMaxPrecision = 12
+
+	// GeohashMaxCoverCells is the number of cells a cover may have, so
+	// that a function can emit every cell of one, GEO_MAX_COVER_CELLS.
+	GeohashMaxCoverCells = 1024
+)
+
+const (
+	geohashBase32  = "0123456789bcdefghjkmnpqrstuvwxyz"
+	geoEarthRadius = 6371008.8 // mean, in meters
+	geoDegPerRad   = 57.29577951308232
+	geoRadPerDeg   = 0.017453292519943295
+)
+
+// GeoBox is a box of latitudes and longitudes, in degrees. A box whose
+// West is greater than its East crosses the antimeridian.
+type GeoBox struct {
+	South float64 `json:"south"`
+	West  float64 `json:"west"`
+	North float64 `json:"north"`
+	East  float64 `json:"east"`
+}
+
+// GeohashRange is a range of geohash keys: Low included, High excluded,
+// or no upper bound when empty.
+type GeohashRange struct {
+	Low  string `json:"low"`
+	High string `json:"high"`
+}
+
+// Center returns the point at the center of a box, not crossing the
+// antimeridian.
+func (b GeoBox) Center() (lat, lon float64) {
+	return (b.South + b.North) / 2, (b.West + b.East) / 2
+}
+
+func geohashPrecision(precision int) error {
+	if precision < 1 || precision > GeohashMaxPrecision {
+		return fmt.Errorf("geohash precision must be from 1 to %v", GeohashMaxPrecision)
+	}
+	return nil
+}
+
+func geoPoint(lat, lon float64) error {
+	if !(lat >= -90 && lat <= 90) {
+		return fmt.Errorf("latitude %v is not from -90 to 90", lat)
+	} else if !(lon >= -180 && lon <= 180) {
+		return fmt.Errorf("longitude %v is not from -180 to 180", lon)
+	}
+	return nil
+}
+
+// Bits of longitude and latitude in a geohash of precision characters,
+// longitude takes the first and every other bit
+func geohashBits(precision int) (lonBits, latBits uint) {
+	return uint(precision*5+1) / 2, uint(precision*5) / 2
+}
+
+// Index of the cell of value among the 2^bits cells from lo to hi, by
+// bisection as geohash defines it: values on a boundary go up
+func geoCellIndex(value, lo, hi float64, bits uint) uint64 {
+	var index uint64
+	for i := uint(0); i < bits; i++ {
+		mid := (lo + hi) / 2
+		if value >= mid {
+			index = index<<1 | 1
+			lo = mid
+		} else {
+			index = index << 1
+			hi = mid
+		}
+	}
+	return index
+}
+
+func geoCellHash(lonIndex, latIndex uint64, precision int) string {
+	lonBits, latBits := geohashBits(precision)
+	hash := make([]byte, precision)
+	for c := range hash {
+		var ch uint64
+		for k := uint(c * 5); k < uint(c*5+5); k++ {
+			if k%2 == 0 {
+				ch = ch<<1 | lonIndex>>(lonBits-1-k/2)&1
+			} else {
+				ch = ch<<1 | latIndex>>(latBits-1-(k-1)/2)&1
+			}
+		}
+		hash[c] = geohashBase32[ch]
+	}
+	return string(hash)
+}
+
+// GeohashEncode returns the geohash of a point, of precision characters,
+// as geo.encode does.
+func GeohashEncode(lat, lon float64, precision int) (string, error) {
+	if err := geoPoint(lat, lon); err != nil {
+		return "", err
+	} else if err := geohashPrecision(precision); err != nil {
+		return "", err
+	}
+
+	lonBits, latBits := geohashBits(precision)
+	return geoCellHash(geoCellIndex(lon, -180, 180, lonBits),
+		geoCellIndex(lat, -90, 90, latBits), precision), nil
+}
+
+// GeohashDecode returns the cell of a geohash, as geo.decode does.
+func GeohashDecode(hash string) (GeoBox, error) {
+	if len(hash) < 1 || len(hash) > GeohashMaxPrecision {
+		return GeoBox{}, fmt.Errorf("geohash %q does not have 1 to %v characters", hash, GeohashMaxPrecision)
+	}
+
+	var lonIndex, latIndex uint64
+	for c := 0; c < len(hash); c++ {
+		ch := strings.IndexByte(geohashBase32, hash[c])
+		if ch < 0 {
+			return GeoBox{}, fmt.Errorf("invalid character %q in geohash %q", hash[c], hash)
+		}
+		for k := c * 5; k < c*5+5; k++ {
+			b := uint64(ch>>uint(4-k%5)) & 1
+			if k%2 == 0 {
+				lonIndex = lonIndex<<1 | b
+			} else {
+				latIndex = latIndex<<1 | b
+			}
+		}
+	}
+
+	lonBits, latBits := geohashBits(len(hash))
+	width, height := 360/float64(uint64(1)<<lonBits), 180/float64(uint64(1)<<latBits)
+	return GeoBox{
+		South: -90 + float64(float64(latIndex)*height),
+		West:  -180 + float64(float64(lonIndex)*width),
+		North: -90 + float64(float64(latIndex+1)*height),
+		East:  -180 + float64(float64(lonIndex+1)*width),
+	}, nil
+}
+
+// GeohashCells returns the geohashes of a point from precision min to max,
+// the cells containing it from the largest to the smallest, as geo.cells
+// does.
+func GeohashCells(lat, lon float64, min, max int) ([]string, error) {
+	if err := geohashPrecision(min); err != nil {
+		return nil, err
+	} else if max < min {
+		return nil, fmt.Errorf("geohash max precision %v is less than min %v", max, min)
+	}
+
+	hash, err := GeohashEncode(lat, lon, max)
+	if err != nil {
+		return nil, err
+	}
+
+	cells := make([]string, 0, max-min+1)
+	for p := min; p <= max; p++ {
+		cells = append(cells, hash[:p])
+	}
+	return cells, nil
+}
+
+// GeohashCoverBox returns the geohashes of precision, sorted, of the cells
+// intersecting box, as geo.coverBox does. Covers of more than
+// GeohashMaxCoverCells cells are an error.
+func GeohashCoverBox(box GeoBox, precision int) ([]string, error) {
+	if err := geoPoint(box.South, box.West); err != nil {
+		return nil, err
+	} else if err := geoPoint(box.North, box.East); err != nil {
+		return nil, err
+	} else if box.South > box.North {
+		return nil, fmt.Errorf("south %v is greater than north %v", box.South, box.North)
+	} else if err := geohashPrecision(precision); err != nil {
+		return nil, err
+	}
+
+	lonBits, latBits := geohashBits(precision)
+	j0, j1 := geoCellIndex(box.South, -90, 90, latBits), geoCellIndex(box.North, -90, 90, latBits)
+	i0, i1 := geoCellIndex(box.West, -180, 180, lonBits), geoCellIndex(box.East, -180, 180, lonBits)
+	spans := [][2]uint64{{i0, i1}}
+	if box.West > box.East {
+		spans = [][2]uint64{{i0, uint64(1)<<lonBits - 1}, {0, i1}}
+	}
+
+	count := uint64(0)
+	for _, span := range spans {
+		count += span[1] - span[0] + 1
+	}
+	if count*(j1-j0+1) > GeohashMaxCoverCells {
+		return nil, fmt.Errorf("more than %v cells at precision %v, use a lower one",
+			GeohashMaxCoverCells, precision)
+	}
+
+	cells := make([]string, 0, count*(j1-j0+1))
+	seen := make(map[string]bool)
+	for _, span := range spans {
+		for i := span[0]; i <= span[1]; i++ {
+			for j := j0; j <= j1; j++ {
+				hash := geoCellHash(i, j, precision)
+				if !seen[hash] {
+					seen[hash] = true
+					cells = append(cells, hash)
+				}
+			}
+		}
+	}
+	sort.Strings(cells)
+	return cells, nil
+}
+
+// cos of x in [0, pi/2], as the series geo computes, as math.Cos and the
+// Math.cos of the engine may differ in their last bit. Explicit float64
+// conversions keep the compiler from fusing multiply and add.
+func geoCos(x float64) float64 {
+	x2 := float64(x * x)
+	term, sum := 1.0, 1.0
+	for k := 1; k <= 12; k++ {
+		term = float64(term*-x2) / float64((2*k-1)*(2*k))
+		sum = sum + term
+	}
+	return sum
+}
+
+// GeohashCoverRadius returns the cells covering a box that contains the
+// circle of meters around a point, as geo.coverRadius does. Some of them
+// may be out of the circle, what they match is to be filtered by distance.
+func GeohashCoverRadius(lat, lon, meters float64, precision int) ([]string, error) {
+	if err := geoPoint(lat, lon); err != nil {
+		return nil, err
+	} else if !(meters >= 0) || math.IsInf(meters, 1) {
+		return nil, fmt.Errorf("radius %v is not a number of meters from 0", meters)
+	}
+
+	dLat := float64(meters/geoEarthRadius) * geoDegPerRad
+	box := GeoBox{South: lat - dLat, West: -180, North: lat + dLat, East: 180}
+	if box.South > -90 && box.North < 90 {
+		dLon := dLat / geoCos(float64(math.Max(-box.South, box.North)*geoRadPerDeg))
+		if dLon < 180 {
+			box.West, box.East = lon-dLon, lon+dLon
+			if box.West < -180 {
+				box.West += 360
+			}
+			if box.East > 180 {
+				box.East -= 360
+			}
+		}
+	}
+	box.South, box.North = math.Max(box.South, -90), math.Min(box.North, 90)
+	return GeohashCoverBox(box, precision)
+}
+
+func geohashSuccessor(hash string) string {
+	for c := len(hash) - 1; c >= 0; c-- {
+		if ch := strings.IndexByte(geohashBase32, hash[c]); ch < len(geohashBase32)-1 {
+			return hash[:c] + string(geohashBase32[ch+1])
+		}
+	}
+	return ""
+}
+
+// GeohashRanges returns the key ranges of geohashes, of any precision,
+// within cells, as geo.ranges does. Adjacent ranges are merged.
+func GeohashRanges(cells []string) ([]GeohashRange, error) {
+	sorted := append([]string(nil), cells...)
+	sort.Strings(sorted)
+
+	ranges := make([]GeohashRange, 0)
+	for _, cell := range sorted {
+		if _, err := GeohashDecode(cell); err != nil {
+			return nil, err
+		}
+
+		var last *GeohashRange
+		if len(ranges) > 0 {
+			last = &ranges[len(ranges)-1]
+		}
+		if last != nil && (last.High == "" || cell < last.High) {
+			continue
+		}
+
+		high := geohashSuccessor(cell)
+		if last != nil && last.High == cell {
+			last.High = high
+		} else {
+			ranges = append(ranges, GeohashRange{Low: cell, High: high})
+		}
+	}
+	return ranges, nil
+}
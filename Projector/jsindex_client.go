@@ -0,0 +1,59 @@
+// Copyright (c) 2014 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package client
+
+import "errors"
+
+import protobuf "github.com/couchbase/indexing/secondary/protobuf/projector"
+
+// ValidateFunction synchronous call to compile a library function in
+// projector's engine, before an index using it is created. Transport
+// errors are returned as error, while compile errors and missing entry
+// points are reported in the response.
+func (client *Client) ValidateFunction(
+	name, code string,
+	entryPoints []string) (*protobuf.ValidateFunctionResponse, error) {
+
+	req := protobuf.NewValidateFunctionRequest(name, code, entryPoints)
+	res := &protobuf.ValidateFunctionResponse{}
+	err := client.withRetry(
+		func() error {
+			return client.ap.Request(req, res)
+		})
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// UpdateInstances synchronous call to swap, in place, the evaluators of
+// index instances already on a topic. The response's current timestamps
+// carry per vbucket the last seqno evaluated with the old definition.
+func (client *Client) UpdateInstances(
+	topic string,
+	instances []*protobuf.Instance) (*protobuf.TimestampResponse, error) {
+
+	req := protobuf.NewUpdateInstancesRequest(topic, instances)
+	res := &protobuf.TimestampResponse{}
+	err := client.withRetry(
+		func() error {
+			err := client.ap.Request(req, res)
+			if err != nil {
+				return err
+			} else if protoerr := res.GetErr(); protoerr != nil {
+				return errors.New(protoerr.GetError())
+			}
+			return err // nil
+		})
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
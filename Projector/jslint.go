@@ -0,0 +1,783 @@
+// Copyright (c) 2014 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package common
+
+import (
+	"fmt"
+	"sort"
+)
+
+// JSDiagnostic is a problem found in the source of a library function,
+// positioned the way editors show it.
+type JSDiagnostic struct {
+	Severity string `json:"severity"` // JSSeverityError or JSSeverityWarning
+	Rule     string `json:"rule"`
+	Message  string `json:"message"`
+	Line     int    `json:"line"`   // 1-based
+	Column   int    `json:"column"` // 1-based
+}
+
+const (
+	JSSeverityError   = "error"
+	JSSeverityWarning = "warning"
+)
+
+// rules checked by LintJSFunction, JSRuleSyntax is also used for
+// compile errors reported by the engine.
+const (
+	JSRuleSyntax         = "syntax"
+	JSRuleEntryPoint     = "entry-point"
+	JSRuleArity          = "arity"
+	JSRuleAsync          = "no-async"
+	JSRuleEval           = "no-eval"
+	JSRuleGlobalMutation = "no-global-mutation"
+	JSRuleRequire        = "require" // see JSRequires
+)
+
+// JSEntryPoint is a function the engine invokes, with the range of
+// arguments it may declare.
+type JSEntryPoint struct {
+	Name    string
+	MinArgs int
+	MaxArgs int
+}
+
+// DefaultJSEntryPoints are required from every library function, the
+// engine calls OnMap(doc, meta).
+var DefaultJSEntryPoints = []JSEntryPoint{
+	{Name: DefaultJSEntryPoint, MinArgs: 1, MaxArgs: 2},
+}
+
+// knownJSEntryPoints have a calling convention of their own, any other
+// entry point is called like OnMap.
+var knownJSEntryPoints = map[string]JSEntryPoint{
+	"OnMap":    {Name: "OnMap", MinArgs: 1, MaxArgs: 2},    // (doc, meta)
+	"OnFilter": {Name: "OnFilter", MinArgs: 1, MaxArgs: 2}, // (doc, meta)
+	"OnReduce": {Name: "OnReduce", MinArgs: 2, MaxArgs: 3}, // (key, values, rereduce)
+}
+
+// JSEntryPoints returns the entry points to lint for the names a library
+// function declares, DefaultJSEntryPoints when it declares none.
+func JSEntryPoints(names []string) []JSEntryPoint {
+	if len(names) == 0 {
+		return DefaultJSEntryPoints
+	}
+
+	eps := make([]JSEntryPoint, 0, len(names))
+	for _, name := range names {
+		ep, ok := knownJSEntryPoints[name]
+		if !ok {
+			ep = JSEntryPoint{Name: name, MinArgs: 1, MaxArgs: 2}
+		}
+		eps = append(eps, ep)
+	}
+	return eps
+}
+
+// LintJSFunction checks the source of a library function for constructs
+// projector cannot evaluate deterministically: entry points missing from
+// the top level or declaring the wrong number of parameters, async
+// functions, eval and the Function constructor, also when referenced
+// without a call, and assignments from within functions to state outside
+// of them, including the global object through this in sloppy mode. The
+// checks are lexical, code shall compile for the result to be meaningful.
+func LintJSFunction(code string, entryPoints []JSEntryPoint) []JSDiagnostic {
+	toks, diag := tokenizeJS(code)
+	if diag != nil {
+		return []JSDiagnostic{*diag}
+	}
+
+	l := &jsLinter{
+		toks:   toks,
+		scopes: []*jsScope{newJSScope(0)},
+		funcs:  make(map[string]*jsFunc),
+		newed:  make(map[string]bool),
+	}
+	// this is the global object at the top level of a script, strict or not
+	l.scopes[0].strict, l.scopes[0].thisGlobal = l.useStrict(0), true
+	for i := range toks {
+		if l.is(i, "new") && l.tok(i+1).kind == jsIdent {
+			l.newed[toks[i+1].text] = true
+		}
+	}
+	l.run()
+
+	for _, ep := range entryPoints {
+		fn, ok := l.funcs[ep.Name]
+		if !ok {
+			l.errorf(JSRuleEntryPoint, 1, 1,
+				"entry point %v is not declared at the top level", ep.Name)
+		} else if fn.params < ep.MinArgs || (fn.params > ep.MaxArgs && !fn.rest) {
+			l.errorf(JSRuleArity, fn.line, fn.col,
+				"entry point %v declares %v parameters, expected %v to %v",
+				ep.Name, fn.params, ep.MinArgs, ep.MaxArgs)
+		}
+	}
+
+	sort.SliceStable(l.diags, func(i, j int) bool {
+		if l.diags[i].Line != l.diags[j].Line {
+			return l.diags[i].Line < l.diags[j].Line
+		}
+		return l.diags[i].Column < l.diags[j].Column
+	})
+	return l.diags
+}
+
+// JSDiagnosticsError returns true if any of diags is an error.
+func JSDiagnosticsError(diags []JSDiagnostic) bool {
+	for _, diag := range diags {
+		if diag.Severity == JSSeverityError {
+			return true
+		}
+	}
+	return false
+}
+
+//---------------
+// tokenizer
+//---------------
+
+type jsTokenKind int
+
+const (
+	jsIdent jsTokenKind = iota + 1 // identifiers and keywords
+	jsNumber
+	jsString // string and template literals
+	jsRegexp
+	jsPunct
+)
+
+type jsToken struct {
+	kind jsTokenKind
+	text string
+	line int
+	col  int
+	nl   bool // preceded by a line break
+}
+
+var jsPuncts = []string{
+	">>>=", "...", "===", "!==", "**=", "<<=", ">>=", ">>>", "&&=", "||=", "??=",
+	"=>", "==", "!=", "<=", ">=", "&&", "||", "??", "?.", "++", "--", "+=", "-=",
+	"*=", "/=", "%=", "&=", "|=", "^=", "**", "<<", ">>",
+}
+
+// keywords after which a slash starts a regular expression.
+var jsRegexpAfter = map[string]bool{
+	"return": true, "typeof": true, "instanceof": true, "in": true, "of": true,
+	"new": true, "delete": true, "void": true, "throw": true, "case": true,
+	"do": true, "else": true, "yield": true, "await": true,
+}
+
+type jsScanner struct {
+	src  []rune
+	pos  int
+	line int
+	col  int
+	nl   bool
+}
+
+func (s *jsScanner) peek(off int) rune {
+	if s.pos+off < len(s.src) {
+		return s.src[s.pos+off]
+	}
+	return 0
+}
+
+func (s *jsScanner) next() rune {
+	r := s.src[s.pos]
+	s.pos++
+	if r == '\n' {
+		s.line, s.col, s.nl = s.line+1, 1, true
+	} else {
+		s.col++
+	}
+	return r
+}
+
+func (s *jsScanner) errorf(line, col int, format string, args ...interface{}) *JSDiagnostic {
+	return &JSDiagnostic{
+		Severity: JSSeverityError,
+		Rule:     JSRuleSyntax,
+		Message:  fmt.Sprintf(format, args...),
+		Line:     line,
+		Column:   col,
+	}
+}
+
+func isJSIdentRune(r rune, first bool) bool {
+	switch {
+	case r == '_' || r == '$' || r >= 0x80:
+		return true
+	case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z':
+		return true
+	case r >= '0' && r <= '9':
+		return !first
+	}
+	return false
+}
+
+func tokenizeJS(code string) ([]jsToken, *JSDiagnostic) {
+	s := &jsScanner{src: []rune(code), line: 1, col: 1}
+	toks := make([]jsToken, 0, len(code)/4)
+
+	for s.pos < len(s.src) {
+		r := s.peek(0)
+		switch {
+		case r == ' ' || r == '\t' || r == '\r' || r == '\n' || r == '\v' || r == '\f':
+			s.next()
+			continue
+
+		case r == '/' && s.peek(1) == '/':
+			for s.pos < len(s.src) && s.peek(0) != '\n' {
+				s.next()
+			}
+			continue
+
+		case r == '/' && s.peek(1) == '*':
+			line, col := s.line, s.col
+			s.next()
+			s.next()
+			for !(s.peek(0) == '*' && s.peek(1) == '/') {
+				if s.pos >= len(s.src) {
+					return nil, s.errorf(line, col, "unterminated comment")
+				}
+				s.next()
+			}
+			s.next()
+			s.next()
+			continue
+		}
+
+		tok := jsToken{line: s.line, col: s.col, nl: s.nl}
+		s.nl = false
+		start := s.pos
+		switch {
+		case isJSIdentRune(r, true):
+			for s.pos < len(s.src) && isJSIdentRune(s.peek(0), false) {
+				s.next()
+			}
+			tok.kind = jsIdent
+
+		case r >= '0' && r <= '9' || (r == '.' && s.peek(1) >= '0' && s.peek(1) <= '9'):
+			for s.pos < len(s.src) && (isJSIdentRune(s.peek(0), false) || s.peek(0) == '.') {
+				s.next()
+			}
+			tok.kind = jsNumber
+
+		case r == '"' || r == '\'':
+			s.next()
+			for s.peek(0) != r {
+				if s.pos >= len(s.src) || s.peek(0) == '\n' {
+					return nil, s.errorf(tok.line, tok.col, "unterminated string literal")
+				} else if s.next() == '\\' && s.pos < len(s.src) {
+					s.next()
+				}
+			}
+			s.next()
+			tok.kind = jsString
+
+		case r == '`':
+			// substitutions are skipped along with the literal.
+			s.next()
+			depth := 0
+			for depth > 0 || s.peek(0) != '`' {
+				if s.pos >= len(s.src) {
+					return nil, s.errorf(tok.line, tok.col, "unterminated template literal")
+				}
+				switch c := s.next(); {
+				case c == '\\' && s.pos < len(s.src):
+					s.next()
+				case c == '$' && s.peek(0) == '{':
+					s.next()
+					depth++
+				case c == '{' && depth > 0:
+					depth++
+				case c == '}' && depth > 0:
+					depth--
+				}
+			}
+			s.next()
+			tok.kind = jsString
+
+		case r == '/' && jsRegexpAllowed(toks):
+			s.next()
+			inClass := false
+			for inClass || s.peek(0) != '/' {
+				if s.pos >= len(s.src) || s.peek(0) == '\n' {
+					return nil, s.errorf(tok.line, tok.col, "unterminated regular expression")
+				}
+				switch s.next() {
+				case '\\':
+					if s.pos < len(s.src) {
+						s.next()
+					}
+				case '[':
+					inClass = true
+				case ']':
+					inClass = false
+				}
+			}
+			s.next()
+			for s.pos < len(s.src) && isJSIdentRune(s.peek(0), false) {
+				s.next()
+			}
+			tok.kind = jsRegexp
+
+		default:
+			tok.kind = jsPunct
+			n := 1
+			for _, p := range jsPuncts {
+				if end := s.pos + len(p); end <= len(s.src) && string(s.src[s.pos:end]) == p {
+					n = len(p)
+					break
+				}
+			}
+			for i := 0; i < n; i++ {
+				s.next()
+			}
+		}
+		tok.text = string(s.src[start:s.pos])
+		toks = append(toks, tok)
+	}
+	return toks, nil
+}
+
+func jsRegexpAllowed(toks []jsToken) bool {
+	if len(toks) == 0 {
+		return true
+	}
+	prev := toks[len(toks)-1]
+	switch prev.kind {
+	case jsIdent:
+		return jsRegexpAfter[prev.text]
+	case jsPunct:
+		return prev.text != ")" && prev.text != "]" && prev.text != "}" &&
+			prev.text != "++" && prev.text != "--"
+	}
+	return false
+}
+
+//---------------
+// linter
+//---------------
+
+var jsAssignOps = map[string]bool{
+	"=": true, "+=": true, "-=": true, "*=": true, "/=": true, "%=": true,
+	"**=": true, "<<=": true, ">>=": true, ">>>=": true, "&=": true, "|=": true,
+	"^=": true, "&&=": true, "||=": true, "??=": true,
+}
+
+var jsBrackets = map[string]string{
+	"(": ")", "[": "]", "{": "}", ")": "(", "]": "[", "}": "{",
+}
+
+type jsScope struct {
+	names map[string]bool
+	depth int // brace depth of the function body
+
+	strict     bool
+	thisGlobal bool // this is the global object when the function is called
+}
+
+func newJSScope(depth int) *jsScope {
+	return &jsScope{names: make(map[string]bool), depth: depth}
+}
+
+type jsFunc struct {
+	params    int // parameters counted by Function.length
+	rest      bool
+	line, col int
+}
+
+type jsLinter struct {
+	toks   []jsToken
+	diags  []JSDiagnostic
+	scopes []*jsScope // scopes[0] is the global scope
+	funcs  map[string]*jsFunc
+	newed  map[string]bool // functions called as constructors
+
+	depth   int      // brace depth
+	pending *jsScope // scope of a function whose body is about to open
+
+	// state of a var, let or const statement
+	inDecl     bool
+	declNest   int
+	expectName bool
+}
+
+func (l *jsLinter) errorf(rule string, line, col int, format string, args ...interface{}) {
+	l.diags = append(l.diags, JSDiagnostic{
+		Severity: JSSeverityError,
+		Rule:     rule,
+		Message:  fmt.Sprintf(format, args...),
+		Line:     line,
+		Column:   col,
+	})
+}
+
+func (l *jsLinter) tok(i int) jsToken {
+	if i >= 0 && i < len(l.toks) {
+		return l.toks[i]
+	}
+	return jsToken{}
+}
+
+func (l *jsLinter) is(i int, text string) bool {
+	t := l.tok(i)
+	return (t.kind == jsPunct || t.kind == jsIdent) && t.text == text
+}
+
+// property access or key, not a reference to a variable.
+func (l *jsLinter) isProperty(i int) bool {
+	return l.is(i-1, ".") || l.is(i-1, "?.")
+}
+
+func (l *jsLinter) declare(name string) {
+	l.scopes[len(l.scopes)-1].names[name] = true
+}
+
+func (l *jsLinter) global() bool {
+	return len(l.scopes) == 1
+}
+
+// "use strict" directive at token i.
+func (l *jsLinter) useStrict(i int) bool {
+	t := l.tok(i)
+	return t.kind == jsString && (t.text == `"use strict"` || t.text == `'use strict'`)
+}
+
+// a reference to name resolves to the builtin, no scope declares it.
+func (l *jsLinter) builtin(name string) bool {
+	return !l.local(name) && !l.scopes[0].names[name]
+}
+
+// declared by an enclosing function, globals excluded.
+func (l *jsLinter) local(name string) bool {
+	for _, scope := range l.scopes[1:] {
+		if scope.names[name] {
+			return true
+		}
+	}
+	return false
+}
+
+func (l *jsLinter) run() {
+	for i := 0; i < len(l.toks); i++ {
+		t := l.toks[i]
+		if l.inDecl {
+			i = l.declaration(i)
+			t = l.toks[i]
+		}
+
+		switch {
+		case t.kind == jsPunct && t.text == "{":
+			l.depth++
+			if l.pending != nil {
+				l.pending.depth = l.depth
+				l.scopes = append(l.scopes, l.pending)
+				l.pending = nil
+			}
+
+		case t.kind == jsPunct && t.text == "}":
+			if top := l.scopes[len(l.scopes)-1]; !l.global() && top.depth == l.depth {
+				l.scopes = l.scopes[:len(l.scopes)-1]
+			}
+			l.depth--
+
+		case t.kind == jsPunct && t.text == "=>":
+			l.arrow(i)
+
+		case t.kind == jsPunct && jsAssignOps[t.text]:
+			l.assignment(i, l.lvalueRoot(i-1))
+
+		case t.kind == jsPunct && (t.text == "++" || t.text == "--"):
+			if p := l.tok(i - 1); !t.nl && (p.kind == jsIdent || p.text == "]") {
+				l.assignment(i, l.lvalueRoot(i-1))
+			} else if l.tok(i+1).kind == jsIdent {
+				l.assignment(i, l.lvalueRoot(l.memberEnd(i+1)))
+			}
+
+		case t.kind != jsIdent || l.isProperty(i):
+
+		case t.text == "function":
+			i = l.function(i)
+
+		case t.text == "var" || t.text == "let" || t.text == "const":
+			l.inDecl, l.declNest, l.expectName = true, 0, true
+
+		case t.text == "catch" && l.is(i+1, "(") && l.tok(i+2).kind == jsIdent:
+			l.declare(l.toks[i+2].text)
+
+		case t.text == "async":
+			if n := l.tok(i + 1); !n.nl && (n.text == "function" || n.text == "(" || n.kind == jsIdent) {
+				l.errorf(JSRuleAsync, t.line, t.col,
+					"async functions are not allowed, documents are evaluated synchronously")
+			}
+
+		// calls, and references that may be called later, as in
+		// var f = Function; members and instanceof tests are fine.
+		case (t.text == "eval" || t.text == "Function") && l.builtin(t.text) &&
+			!l.is(i+1, ".") && !l.is(i+1, "?.") && !l.is(i-1, "instanceof"):
+			if t.text == "eval" {
+				l.errorf(JSRuleEval, t.line, t.col, "eval is not allowed")
+			} else {
+				l.errorf(JSRuleEval, t.line, t.col,
+					"Function constructor is not allowed, it evaluates strings as code")
+			}
+		}
+	}
+}
+
+// declaration tracks names declared by a var, let or const statement
+// starting at token i, returns the token for run to carry on with.
+func (l *jsLinter) declaration(i int) int {
+	t := l.toks[i]
+	if l.expectName {
+		l.expectName = false
+		switch {
+		case t.kind == jsIdent:
+			l.declare(t.text)
+			return i
+		case t.text == "{" || t.text == "[":
+			end := l.matching(i)
+			for _, name := range l.patternNames(i+1, end) {
+				l.declare(name)
+			}
+			if end+1 < len(l.toks) {
+				return end + 1
+			}
+			return end
+		}
+	}
+
+	switch t.text {
+	case "(", "[", "{":
+		l.declNest++
+	case ")", "]", "}":
+		if l.declNest--; l.declNest < 0 {
+			l.inDecl = false
+		}
+	case ",":
+		l.expectName = l.declNest == 0
+	case ";", "in", "of":
+		l.inDecl = l.declNest != 0
+	}
+	return i
+}
+
+// function handles the function keyword at i, returns the index of the
+// closing parenthesis of its parameters.
+func (l *jsLinter) function(i int) int {
+	t := l.toks[i]
+	j := i + 1
+	if l.is(j, "*") {
+		j++
+	}
+	name := ""
+	if l.tok(j).kind == jsIdent {
+		name = l.toks[j].text
+		l.declare(name)
+		j++
+	} else if l.is(i-1, "=") && l.tok(i-2).kind == jsIdent && !l.isProperty(i-2) {
+		name = l.toks[i-2].text // name = function (...)
+	}
+	if !l.is(j, "(") {
+		return i
+	}
+
+	params, count, rest, end := l.params(j)
+	if name != "" && l.global() {
+		l.funcs[name] = &jsFunc{params: count, rest: rest, line: t.line, col: t.col}
+	}
+	if l.is(end+1, "{") {
+		l.pending = newJSScope(0)
+		for _, param := range params {
+			l.pending.names[param] = true
+		}
+		// Called plainly, a sloppy function gets the global object as
+		// this; methods and constructors get their object.
+		method := l.is(i-1, ":") || (l.is(i-1, "=") && l.isProperty(i-2))
+		l.pending.strict = l.scopes[len(l.scopes)-1].strict || l.useStrict(end+2)
+		l.pending.thisGlobal = !l.pending.strict && !method && !l.newed[name]
+	}
+	return end
+}
+
+// arrow handles => at i.
+func (l *jsLinter) arrow(i int) {
+	var params []string
+	var count, start int
+	var rest bool
+	if l.is(i-1, ")") {
+		start = l.matching(i - 1)
+		params, count, rest, _ = l.params(start)
+	} else if l.tok(i-1).kind == jsIdent {
+		start = i - 1
+		params, count = []string{l.toks[i-1].text}, 1
+	} else {
+		return
+	}
+
+	if l.is(start-1, "=") && l.tok(start-2).kind == jsIdent && !l.isProperty(start-2) && l.global() {
+		name := l.toks[start-2]
+		l.funcs[name.text] = &jsFunc{params: count, rest: rest, line: name.line, col: name.col}
+	}
+
+	if l.is(i+1, "{") {
+		// arrow functions take this from the enclosing function
+		top := l.scopes[len(l.scopes)-1]
+		l.pending = newJSScope(0)
+		l.pending.strict, l.pending.thisGlobal = top.strict, top.thisGlobal
+		for _, param := range params {
+			l.pending.names[param] = true
+		}
+	} else {
+		// expression body, parameters leak into the enclosing scope.
+		for _, param := range params {
+			l.declare(param)
+		}
+	}
+}
+
+// params parses parameters in parenthesis opening at i, count excludes
+// rest and defaulted parameters like Function.length.
+func (l *jsLinter) params(i int) (names []string, count int, rest bool, end int) {
+	end = l.matching(i)
+	expect, defaulted := true, false
+	for j := i + 1; j < end; j++ {
+		t := l.toks[j]
+		switch {
+		case t.kind != jsPunct && t.kind != jsIdent:
+			expect = false
+		case t.text == ",":
+			expect = true
+		case t.text == "=":
+			defaulted = true
+		case expect && t.text == "...":
+			rest = true
+		case expect && t.kind == jsIdent:
+			names = append(names, t.text)
+			defaulted = defaulted || l.is(j+1, "=")
+			if !rest && !defaulted {
+				count++
+			}
+			expect = false
+		case expect && (t.text == "{" || t.text == "["):
+			close := l.matching(j)
+			names = append(names, l.patternNames(j+1, close)...)
+			defaulted = defaulted || l.is(close+1, "=")
+			if !rest && !defaulted {
+				count++
+			}
+			expect, j = false, close
+		case t.text == "(" || t.text == "{" || t.text == "[":
+			j = l.matching(j) // default values
+		}
+	}
+	return names, count, rest, end
+}
+
+// patternNames returns the names bound by a destructuring pattern
+// between tokens start and end.
+func (l *jsLinter) patternNames(start, end int) []string {
+	names := make([]string, 0)
+	for j := start; j < end; j++ {
+		t := l.toks[j]
+		if t.kind == jsIdent && !l.is(j+1, ":") && !l.is(j-1, "=") {
+			names = append(names, t.text)
+		}
+	}
+	return names
+}
+
+// matching returns the index of the bracket closing, or opening, the one
+// at i. Returns the last, or first, token when unbalanced.
+func (l *jsLinter) matching(i int) int {
+	open, close := l.toks[i].text, jsBrackets[l.toks[i].text]
+	step := 1
+	if open == ")" || open == "]" || open == "}" {
+		step = -1
+	}
+	nest := 0
+	for j := i; j >= 0 && j < len(l.toks); j += step {
+		if t := l.toks[j]; t.kind == jsPunct && t.text == open {
+			nest++
+		} else if t.kind == jsPunct && t.text == close {
+			if nest--; nest == 0 {
+				return j
+			}
+		}
+	}
+	if step < 0 {
+		return 0
+	}
+	return len(l.toks) - 1
+}
+
+// memberEnd returns the last token of a member expression starting with
+// the identifier at i.
+func (l *jsLinter) memberEnd(i int) int {
+	for {
+		if (l.is(i+1, ".") || l.is(i+1, "?.")) && l.tok(i+2).kind == jsIdent {
+			i += 2
+		} else if l.is(i+1, "[") {
+			i = l.matching(i + 1)
+		} else {
+			return i
+		}
+	}
+}
+
+// lvalueRoot returns the variable at the root of the member expression
+// ending at token i, -1 if there is none.
+func (l *jsLinter) lvalueRoot(i int) int {
+	for i >= 0 {
+		t := l.toks[i]
+		switch {
+		case t.kind == jsIdent && l.isProperty(i):
+			i -= 2
+		case t.kind == jsIdent:
+			return i
+		case t.kind == jsPunct && t.text == "]":
+			if i = l.matching(i) - 1; l.tok(i).kind != jsIdent && !l.is(i, "]") {
+				return -1 // array literal, destructuring
+			}
+		default:
+			return -1 // call results, literals
+		}
+	}
+	return -1
+}
+
+// assignment flags writes, by operator at i, to a variable or to
+// properties of a variable not declared by an enclosing function.
+func (l *jsLinter) assignment(i, root int) {
+	if root < 0 || l.global() {
+		return
+	}
+	name := l.toks[root].text
+	t := l.toks[i]
+	if name == "this" {
+		if l.scopes[len(l.scopes)-1].thisGlobal {
+			l.errorf(JSRuleGlobalMutation, t.line, t.col,
+				"%v modifies the global object through this, functions shall not keep state across documents", t.text)
+		}
+		return
+	} else if l.local(name) {
+		return
+	}
+	if l.scopes[0].names[name] {
+		l.errorf(JSRuleGlobalMutation, t.line, t.col,
+			"%v modifies global %v, functions shall not keep state across documents", t.text, name)
+	} else {
+		l.errorf(JSRuleGlobalMutation, t.line, t.col,
+			"%v modifies %v, which is not declared by the function", t.text, name)
+	}
+}
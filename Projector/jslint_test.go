@@ -0,0 +1,119 @@
+package common
+
+import (
+	"reflect"
+	"testing"
+)
+
+func lintRules(code string) []string {
+	rules := make([]string, 0)
+	for _, diag := range LintJSFunction(code, DefaultJSEntryPoints) {
+		rules = append(rules, diag.Rule)
+	}
+	return rules
+}
+
+func TestLintJSFunction(t *testing.T) {
+	tests := []struct {
+		name  string
+		code  string
+		rules []string
+	}{
+		{"clean",
+			"function OnMap(doc, meta) { var x = doc.a; emit(x, meta.id); }",
+			[]string{}},
+		{"missing entry point",
+			"function map(doc) { emit(doc.a); }",
+			[]string{JSRuleEntryPoint}},
+		{"arity",
+			"function OnMap(doc, meta, extra) { emit(doc.a); }",
+			[]string{JSRuleArity}},
+		{"arity with defaulted parameter",
+			"function OnMap(doc, meta, extra = 1) { emit(doc.a); }",
+			[]string{}},
+		{"arrow entry point",
+			"var OnMap = (doc) => { emit(doc.a); };",
+			[]string{}},
+		{"async",
+			"async function OnMap(doc) { emit(doc.a); }",
+			[]string{JSRuleAsync}},
+		{"eval",
+			"function OnMap(doc) { emit(eval(doc.code)); }",
+			[]string{JSRuleEval}},
+		{"eval alias",
+			"function OnMap(doc) { var e = eval; emit(e(doc.code)); }",
+			[]string{JSRuleEval}},
+		{"Function constructor",
+			"function OnMap(doc) { emit(new Function('return 1')()); }",
+			[]string{JSRuleEval}},
+		{"Function alias",
+			"function OnMap(doc) { var f = Function; emit(f('return 1')()); }",
+			[]string{JSRuleEval}},
+		{"Function members and instanceof",
+			"function OnMap(doc) { if (!(doc.f instanceof Function)) { emit(Function.prototype.toString.call(doc.f)); } }",
+			[]string{}},
+		{"eval as property",
+			"function OnMap(doc) { emit(doc.eval(1)); }",
+			[]string{}},
+		{"global write",
+			"var n = 0;\nfunction OnMap(doc) { n++; emit(n); }",
+			[]string{JSRuleGlobalMutation}},
+		{"global property write",
+			"var seen = {};\nfunction OnMap(doc) { seen[doc.id] = true; }",
+			[]string{JSRuleGlobalMutation}},
+		{"undeclared write",
+			"function OnMap(doc) { total = doc.a; emit(total); }",
+			[]string{JSRuleGlobalMutation}},
+		{"local writes",
+			"function OnMap(doc) { var a = [], o = {}; let n = 0; a[0] = 1; o.x = 2; n += 1; for (const k of doc.l) { a.push(k); } emit(n); }",
+			[]string{}},
+		{"closure writes its own variables",
+			"function OnMap(doc) { var n = 0; doc.l.forEach(function(x) { n += x; }); emit(n); }",
+			[]string{}},
+		{"this in sloppy function",
+			"function OnMap(doc) { this.last = doc.id; emit(doc.a); }",
+			[]string{JSRuleGlobalMutation}},
+		{"this in arrow of sloppy function",
+			"function OnMap(doc) { doc.l.forEach((x) => { this.last = x; }); }",
+			[]string{JSRuleGlobalMutation}},
+		{"this in strict script",
+			"'use strict';\nfunction OnMap(doc) { this.last = doc.id; emit(doc.a); }",
+			[]string{}},
+		{"this in strict function",
+			"function OnMap(doc) { \"use strict\"; this.last = doc.id; emit(doc.a); }",
+			[]string{}},
+		{"this in constructor",
+			"function Point(x) { this.x = x; }\nfunction OnMap(doc) { emit(new Point(doc.a).x); }",
+			[]string{}},
+		{"this in method",
+			"function OnMap(doc) { var o = { set: function(v) { this.v = v; } }; o.set(doc.a); emit(o.v); }",
+			[]string{}},
+		{"unterminated string",
+			"function OnMap(doc) { emit('a); }",
+			[]string{JSRuleSyntax}},
+	}
+
+	for _, test := range tests {
+		if rules := lintRules(test.code); !reflect.DeepEqual(rules, test.rules) {
+			t.Errorf("%v: expected rules %v, got %v", test.name, test.rules, rules)
+		}
+	}
+}
+
+func TestLintJSFunctionPosition(t *testing.T) {
+	diags := LintJSFunction("var n = 0;\nfunction OnMap(doc) {\n  n = doc.a;\n}", DefaultJSEntryPoints)
+	if len(diags) != 1 {
+		t.Fatalf("expected one diagnostic, got %v", diags)
+	}
+	if diags[0].Line != 3 || diags[0].Column != 5 || diags[0].Severity != JSSeverityError {
+		t.Errorf("expected error at line 3 column 5, got %+v", diags[0])
+	}
+}
+
+func TestLintJSFunctionRegexp(t *testing.T) {
+	// a slash after an identifier divides, after return it opens a regexp
+	code := "function OnMap(doc) { var r = doc.a / 2 / 3; if (doc.s) { return /a\"b/.test(doc.s); } emit(r); }"
+	if rules := lintRules(code); len(rules) != 0 {
+		t.Errorf("expected no diagnostics, got %v", rules)
+	}
+}
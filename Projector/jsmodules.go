@@ -0,0 +1,221 @@
+package common
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Library functions marked as modules can be required by other library
+// functions, as require("name") or require("name@version"), and export
+// through module.exports or exports like CommonJS modules. Eventing
+// service manager resolves every require to a version when a function is
+// saved and records those as its dependencies, so that the dependency
+// graph of a version never changes. Projector compiles one flat script:
+// BundleJSFunction appends the modules of the graph to the code of the
+// function, which keeps its line numbers.
+
+// JSRequire is a module required by the code of a library function,
+// Version is 0 for the latest version at save time.
+type JSRequire struct {
+	Name    string
+	Version uint64
+	Line    int
+	Column  int
+}
+
+// JSModule is a version of a library function marked as module.
+type JSModule struct {
+	Name         string
+	Version      uint64
+	Code         string
+	Module       bool
+	Dependencies map[string]uint64 // modules it requires, by name
+}
+
+// JSModuleLoader returns the given version of a library function, nil
+// when there is no such version.
+type JSModuleLoader func(name string, version uint64) (*JSModule, error)
+
+// JSRequires returns the modules code requires, in order of appearance,
+// along with diagnostics for calls to require that do not name a module
+// with a string literal, and for import. ES modules are not supported:
+// projector compiles one script, where import is a syntax error.
+func JSRequires(code string) ([]JSRequire, []JSDiagnostic) {
+	toks, diag := tokenizeJS(code)
+	if diag != nil {
+		return nil, []JSDiagnostic{*diag}
+	}
+
+	requires := make([]JSRequire, 0)
+	diags := make([]JSDiagnostic, 0)
+	seen := make(map[string]bool)
+
+	for i, tok := range toks {
+		if tok.kind == jsIdent && tok.text == "import" &&
+			(i == 0 || (toks[i-1].text != "." && toks[i-1].text != "?.")) &&
+			(i+1 >= len(toks) || toks[i+1].text != ":") {
+			diags = append(diags, JSDiagnostic{
+				Severity: JSSeverityError,
+				Rule:     JSRuleRequire,
+				Message:  "import is not supported, require modules with require(\"name\")",
+				Line:     tok.line,
+				Column:   tok.col,
+			})
+			continue
+		}
+
+		if tok.kind != jsIdent || tok.text != "require" || i+1 >= len(toks) || toks[i+1].text != "(" ||
+			(i > 0 && (toks[i-1].text == "." || toks[i-1].text == "?." || toks[i-1].text == "function")) {
+			continue
+		}
+
+		spec := ""
+		if i+3 < len(toks) && toks[i+2].kind == jsString && toks[i+3].text == ")" &&
+			!strings.HasPrefix(toks[i+2].text, "`") {
+			spec = toks[i+2].text[1 : len(toks[i+2].text)-1]
+		}
+
+		req, ok := parseJSRequire(spec)
+		if !ok {
+			diags = append(diags, JSDiagnostic{
+				Severity: JSSeverityError,
+				Rule:     JSRuleRequire,
+				Message:  "require takes the name of a module, with an optional @version, as a string literal",
+				Line:     tok.line,
+				Column:   tok.col,
+			})
+			continue
+		}
+
+		req.Line, req.Column = tok.line, tok.col
+		if !seen[spec] {
+			seen[spec] = true
+			requires = append(requires, req)
+		}
+	}
+	return requires, diags
+}
+
+func parseJSRequire(spec string) (req JSRequire, ok bool) {
+	req.Name = spec
+	if at := strings.LastIndexByte(spec, '@'); at >= 0 {
+		version, err := strconv.ParseUint(spec[at+1:], 10, 64)
+		if err != nil || version == 0 {
+			return req, false
+		}
+		req.Name, req.Version = spec[:at], version
+	}
+	return req, req.Name != "" && !strings.ContainsAny(req.Name, "/\\\"'")
+}
+
+// ResolveJSModules loads the modules a library function depends on,
+// directly or through other modules, ordered so that every module comes
+// after those it requires. A module that is missing, is not marked as
+// module, or requires, directly or not, a function on its own path,
+// including the function itself, is an error.
+func ResolveJSModules(name string, deps map[string]uint64, load JSModuleLoader) ([]*JSModule, error) {
+	modules := make([]*JSModule, 0)
+	done := make(map[string]bool)
+	path := []string{name}
+
+	var visit func(deps map[string]uint64) error
+	visit = func(deps map[string]uint64) error {
+		names := make([]string, 0, len(deps))
+		for dep := range deps {
+			names = append(names, dep)
+		}
+		sort.Strings(names)
+
+		for _, dep := range names {
+			version := deps[dep]
+			for i, on := range path {
+				if on == dep {
+					return fmt.Errorf("cyclic dependency %v -> %v",
+						strings.Join(path[i:], " -> "), dep)
+				}
+			}
+
+			key := dep + "@" + strconv.FormatUint(version, 10)
+			if done[key] {
+				continue
+			}
+
+			module, err := load(dep, version)
+			if err != nil {
+				return err
+			} else if module == nil {
+				return fmt.Errorf("module %v version %v, required by %v, not found",
+					dep, version, path[len(path)-1])
+			} else if !module.Module {
+				return fmt.Errorf("library function %v, required by %v, is not a module",
+					dep, path[len(path)-1])
+			}
+
+			path = append(path, dep)
+			if err = visit(module.Dependencies); err != nil {
+				return err
+			}
+			path = path[:len(path)-1]
+
+			done[key] = true
+			modules = append(modules, module)
+		}
+		return nil
+	}
+
+	if err := visit(deps); err != nil {
+		return nil, err
+	}
+	return modules, nil
+}
+
+// BundleJSFunction returns the code of a library function along with the
+// modules it depends on, as returned by ResolveJSModules, in one script.
+// The code comes first and unchanged, so that error lines within it stay
+// the same; require is hoisted and links modules on first use. code is
+// returned as is when it depends on no module.
+func BundleJSFunction(code string, deps map[string]uint64, modules []*JSModule) string {
+	if len(deps) == 0 {
+		return code
+	}
+
+	key := func(name string, version uint64) string {
+		return name + "@" + strconv.FormatUint(version, 10)
+	}
+	links := func(deps map[string]uint64) string {
+		linked := make(map[string]string, len(deps))
+		for name, version := range deps {
+			linked[name] = key(name, version)
+		}
+		data, _ := json.Marshal(linked) // keys are sorted
+		return string(data)
+	}
+	quote := func(s string) string {
+		data, _ := json.Marshal(s)
+		return string(data)
+	}
+
+	var b bytes.Buffer
+	b.WriteString(code)
+	b.WriteString("\n;\nfunction require(name) {\n")
+	b.WriteString("\tif (!require.root) {\n\t\trequire.root = __jsLinkModules();\n\t}\n")
+	b.WriteString("\treturn require.root(name);\n}\n")
+	b.WriteString("function __jsLinkModules() {\n\tvar defs = {}, cache = {};\n")
+	for _, module := range modules {
+		fmt.Fprintf(&b, "\tdefs[%v] = {deps: %v, factory: function(module, exports, require) {\n%v\n\t}};\n",
+			quote(key(module.Name, module.Version)), links(module.Dependencies), module.Code)
+	}
+	b.WriteString("\tfunction link(deps) {\n\t\treturn function(name) {\n")
+	b.WriteString("\t\t\tvar key = deps[name.split('@')[0]];\n")
+	b.WriteString("\t\t\tif (key === undefined) {\n\t\t\t\tthrow new Error('module ' + name + ' is not a dependency');\n\t\t\t}\n")
+	b.WriteString("\t\t\tif (!cache.hasOwnProperty(key)) {\n")
+	b.WriteString("\t\t\t\tvar module = {exports: {}};\n\t\t\t\tcache[key] = module;\n")
+	b.WriteString("\t\t\t\tdefs[key].factory(module, module.exports, link(defs[key].deps));\n\t\t\t}\n")
+	b.WriteString("\t\t\treturn cache[key].exports;\n\t\t};\n\t}\n")
+	fmt.Fprintf(&b, "\treturn link(%v);\n}\n", links(deps))
+	return b.String()
+}
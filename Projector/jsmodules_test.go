@@ -0,0 +1,109 @@
+package common
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// testJSModules loads versions of library functions from a map keyed by
+// name@version.
+func testJSModules(modules ...*JSModule) JSModuleLoader {
+	byKey := make(map[string]*JSModule, len(modules))
+	for _, module := range modules {
+		byKey[fmt.Sprintf("%v@%v", module.Name, module.Version)] = module
+	}
+	return func(name string, version uint64) (*JSModule, error) {
+		return byKey[fmt.Sprintf("%v@%v", name, version)], nil
+	}
+}
+
+func moduleKeys(modules []*JSModule) []string {
+	keys := make([]string, 0, len(modules))
+	for _, module := range modules {
+		keys = append(keys, fmt.Sprintf("%v@%v", module.Name, module.Version))
+	}
+	return keys
+}
+
+func TestJSRequires(t *testing.T) {
+	code := "var a = require('a'), b = require(\"b@2\");\nrequire('a'); obj.require('c'); emit(require(name));"
+	requires, diags := JSRequires(code)
+	expected := []JSRequire{{Name: "a", Line: 1, Column: 9}, {Name: "b", Version: 2, Line: 1, Column: 27}}
+	if !reflect.DeepEqual(requires, expected) {
+		t.Errorf("expected requires %+v, got %+v", expected, requires)
+	}
+	if len(diags) != 1 || diags[0].Line != 2 || diags[0].Rule != JSRuleRequire {
+		t.Errorf("expected one diagnostic for require(name), got %+v", diags)
+	}
+
+	for _, code := range []string{"import a from 'a';", "var m = import('a');"} {
+		if _, diags := JSRequires(code); len(diags) != 1 || diags[0].Rule != JSRuleRequire {
+			t.Errorf("%v: expected import to be reported, got %+v", code, diags)
+		}
+	}
+	if _, diags := JSRequires("var o = {import: 1}; emit(o.import);"); len(diags) != 0 {
+		t.Errorf("expected no diagnostics for import as a property, got %+v", diags)
+	}
+}
+
+func TestResolveJSModules(t *testing.T) {
+	load := testJSModules(
+		&JSModule{Name: "a", Version: 1, Module: true, Dependencies: map[string]uint64{"b": 1, "c": 2}},
+		&JSModule{Name: "b", Version: 1, Module: true, Dependencies: map[string]uint64{"c": 2}},
+		&JSModule{Name: "c", Version: 2, Module: true},
+		&JSModule{Name: "plain", Version: 1},
+		&JSModule{Name: "m", Version: 1, Module: true, Dependencies: map[string]uint64{"gone": 1}},
+		&JSModule{Name: "x", Version: 1, Module: true, Dependencies: map[string]uint64{"y": 1}},
+		&JSModule{Name: "y", Version: 1, Module: true, Dependencies: map[string]uint64{"x": 1}},
+		&JSModule{Name: "self", Version: 1, Module: true, Dependencies: map[string]uint64{"fn": 1}},
+	)
+
+	modules, err := ResolveJSModules("fn", map[string]uint64{"a": 1}, load)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	} else if keys := moduleKeys(modules); !reflect.DeepEqual(keys, []string{"c@2", "b@1", "a@1"}) {
+		t.Errorf("expected modules after those they require, got %v", keys)
+	}
+
+	tests := []struct {
+		name string
+		deps map[string]uint64
+		err  string
+	}{
+		{"missing module", map[string]uint64{"a": 3}, "module a version 3, required by fn, not found"},
+		{"missing module of a module", map[string]uint64{"m": 1}, "module gone version 1, required by m, not found"},
+		{"not a module", map[string]uint64{"plain": 1}, "library function plain, required by fn, is not a module"},
+		{"cycle", map[string]uint64{"y": 1}, "cyclic dependency y -> x -> y"},
+		{"cycle through the function", map[string]uint64{"self": 1}, "cyclic dependency fn -> self -> fn"},
+	}
+
+	for _, test := range tests {
+		if _, err := ResolveJSModules("fn", test.deps, load); err == nil || err.Error() != test.err {
+			t.Errorf("%v: expected error %q, got %v", test.name, test.err, err)
+		}
+	}
+}
+
+func TestBundleJSFunction(t *testing.T) {
+	code := "function OnMap(doc) {\n\temit(require('a').f(doc));\n}"
+	if bundled := BundleJSFunction(code, nil, nil); bundled != code {
+		t.Errorf("expected the code as is without dependencies, got %q", bundled)
+	}
+
+	modules := []*JSModule{
+		{Name: "b", Version: 1, Code: "exports.g = 1;", Module: true},
+		{Name: "a", Version: 2, Code: "exports.f = require('b').g;", Module: true, Dependencies: map[string]uint64{"b": 1}},
+	}
+	bundled := BundleJSFunction(code, map[string]uint64{"a": 2}, modules)
+	if !strings.HasPrefix(bundled, code+"\n") {
+		t.Errorf("expected the code first and unchanged, got %q", bundled)
+	}
+	for _, part := range []string{`defs["b@1"] = {deps: {}`, `defs["a@2"] = {deps: {"b":"b@1"}`,
+		"exports.f = require('b').g;", `return link({"a":"a@2"});`} {
+		if !strings.Contains(bundled, part) {
+			t.Errorf("expected the bundle to contain %q, got %q", part, bundled)
+		}
+	}
+}
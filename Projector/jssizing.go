@@ -0,0 +1,214 @@
+package protobuf
+
+import "bufio"
+import "encoding/json"
+import "errors"
+import "fmt"
+import "io"
+import "math/rand"
+import "os"
+import "time"
+import "github.com/couchbase/indexing/secondary/logging"
+import c "github.com/couchbase/indexing/secondary/common"
+
+// ErrorJSSizingEmpty is returned when sizing finds no document to sample.
+var ErrorJSSizingEmpty = errors.New("protobuf.jsSizingNoDocuments")
+
+// JSSampleDoc is a document fed to the sizing service.
+type JSSampleDoc struct {
+	ID  string          `json:"id"`
+	Doc json.RawMessage `json:"doc"`
+}
+
+// JSSampleSource supplies documents to the sizing service, Next returns
+// io.EOF after the last document.
+type JSSampleSource interface {
+	Next() (*JSSampleDoc, error)
+}
+
+type sliceSampleSource struct {
+	docs []*JSSampleDoc
+}
+
+// NewSliceSampleSource returns a source over documents supplied by the
+// caller.
+func NewSliceSampleSource(docs []*JSSampleDoc) JSSampleSource {
+	return &sliceSampleSource{docs: docs}
+}
+
+func (s *sliceSampleSource) Next() (*JSSampleDoc, error) {
+	if len(s.docs) == 0 {
+		return nil, io.EOF
+	}
+	doc := s.docs[0]
+	s.docs = s.docs[1:]
+	return doc, nil
+}
+
+type linesSampleSource struct {
+	scanner *bufio.Scanner
+	line    int
+}
+
+// NewLinesSampleSource returns a source streaming one JSSampleDoc, as
+// JSON, per line of r. Blank lines are skipped.
+func NewLinesSampleSource(r io.Reader) JSSampleSource {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 20*1024*1024) // max document size
+	return &linesSampleSource{scanner: scanner}
+}
+
+// NewFileSampleSource streams documents from a local file in the format
+// of NewLinesSampleSource, stand-in for a scan of the bucket. Caller
+// shall close the returned file once sizing is done.
+func NewFileSampleSource(path string) (JSSampleSource, *os.File, error) {
+	fd, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	return NewLinesSampleSource(fd), fd, nil
+}
+
+func (s *linesSampleSource) Next() (*JSSampleDoc, error) {
+	for s.scanner.Scan() {
+		s.line++
+		line := s.scanner.Bytes()
+		if len(line) == 0 {
+			continue
+		}
+		doc := &JSSampleDoc{}
+		if err := json.Unmarshal(line, doc); err != nil {
+			return nil, fmt.Errorf("line %v: %v", s.line, err)
+		}
+		return doc, nil
+	}
+	if err := s.scanner.Err(); err != nil {
+		return nil, err
+	}
+	return nil, io.EOF
+}
+
+// SampleDocuments picks, uniformly at random, at most n documents from
+// src and also counts the documents src supplied.
+func SampleDocuments(
+	src JSSampleSource, n int, rnd *rand.Rand) ([]*JSSampleDoc, uint64, error) {
+
+	sample := make([]*JSSampleDoc, 0, n)
+	total := uint64(0)
+	for {
+		doc, err := src.Next()
+		if err == io.EOF {
+			return sample, total, nil
+		} else if err != nil {
+			return nil, total, err
+		}
+		total++
+		if len(sample) < n { // reservoir sampling
+			sample = append(sample, doc)
+		} else if i := rnd.Int63n(int64(total)); i < int64(n) {
+			sample[i] = doc
+		}
+	}
+}
+
+// JSSizing is the outcome of evaluating a JavaScript index on a sample
+// of documents.
+type JSSizing struct {
+	TotalDocs     uint64  `json:"totalDocs"`     // documents supplied by source
+	Sampled       uint64  `json:"sampled"`       // documents evaluated
+	Indexed       uint64  `json:"indexed"`       // documents that got a key
+	Failed        uint64  `json:"failed"`        // documents the function threw on
+	AvgSecKeySize float64 `json:"avgSecKeySize"` // encoded entry, over index entries
+	AvgDocKeySize float64 `json:"avgDocKeySize"` // docid, over indexed documents
+	EntriesPerDoc float64 `json:"entriesPerDoc"` // index entries, over indexed documents
+	Selectivity   float64 `json:"selectivity"`   // indexed / sampled
+}
+
+// EstimateJSSizing runs the library function of defn, which shall be
+// resolved, on at most sampleSize documents picked at random from src.
+func EstimateJSSizing(
+	defn *c.IndexDefn, src JSSampleSource, sampleSize int) (*JSSizing, error) {
+
+	if defn.FuncCode == "" {
+		return nil, ErrorJSFunctionMissing
+	} else if c.JSFunctionHash(defn.FuncCode) != defn.FuncHash {
+		return nil, ErrorJSFunctionHash
+	}
+	entryPoint := defn.FuncEntryPoint
+	if entryPoint == "" {
+		entryPoint = c.DefaultJSEntryPoint
+	}
+
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+	docs, total, err := SampleDocuments(src, sampleSize, rnd)
+	if err != nil {
+		return nil, err
+	} else if len(docs) == 0 {
+		return nil, ErrorJSSizingEmpty
+	}
+
+	// Loaded under its own name, unloading it leaves the code of live
+	// indexes of the same function in place
+	J := NewJSEvaluator("sizing:"+defn.FuncName+"@"+defn.FuncHash, defn.FuncCode, entryPoint)
+	defer J.Close()
+	J.ArrayKey = defn.IsJSArrayIndex()
+	if err := J.Compile(); err != nil {
+		return nil, fmt.Errorf("function %v: %v", defn.FuncName, err)
+	}
+
+	sizing := &JSSizing{TotalDocs: total, Sampled: uint64(len(docs))}
+	var secKeyBytes, docKeyBytes, entries uint64
+	encodeBuf := make([]byte, 0, 1024)
+	nullEntry := []int{len(jsNullKey(nil))}
+	for _, doc := range docs {
+		meta := map[string]interface{}{"id": doc.ID}
+		_, sizes, failed := J.RunStats([]byte(doc.ID), []byte(doc.Doc), meta, encodeBuf)
+		if failed {
+			sizing.Failed++
+			if defn.FuncFailurePolicy != c.JSIndexNull {
+				continue
+			}
+			sizes = nullEntry
+		} else if len(sizes) == 0 {
+			continue
+		}
+		sizing.Indexed++
+		for _, size := range sizes {
+			secKeyBytes += uint64(size)
+		}
+		entries += uint64(len(sizes))
+		docKeyBytes += uint64(len(doc.ID))
+	}
+
+	sizing.Selectivity = float64(sizing.Indexed) / float64(sizing.Sampled)
+	if sizing.Indexed > 0 {
+		sizing.AvgSecKeySize = float64(secKeyBytes) / float64(entries)
+		sizing.AvgDocKeySize = float64(docKeyBytes) / float64(sizing.Indexed)
+		sizing.EntriesPerDoc = float64(entries) / float64(sizing.Indexed)
+	}
+	logging.Infof("EstimateJSSizing: index %v function %v: %v",
+		defn.Name, defn.FuncName, sizing)
+	return sizing, nil
+}
+
+// Apply fills the sizing fields of defn read by the planner. NumDoc is
+// scaled to numDocs, documents in the bucket, when known, otherwise to
+// documents supplied by the source.
+func (s *JSSizing) Apply(defn *c.IndexDefn, numDocs uint64) {
+	if numDocs == 0 {
+		numDocs = s.TotalDocs
+	}
+	defn.NumDoc = uint64(float64(numDocs)*s.Selectivity + 0.5)
+	defn.SecKeySize = uint64(s.AvgSecKeySize + 0.5)
+	defn.DocKeySize = uint64(s.AvgDocKeySize + 0.5)
+	if defn.IsArrayIndex && s.Indexed > 0 {
+		defn.ArrSize = uint64(s.EntriesPerDoc + 0.5)
+	}
+}
+
+func (s *JSSizing) String() string {
+	return fmt.Sprintf("sampled:%v/%v indexed:%v failed:%v "+
+		"secKeySize:%.1f docKeySize:%.1f entriesPerDoc:%.2f selectivity:%.3f",
+		s.Sampled, s.TotalDocs, s.Indexed, s.Failed, s.AvgSecKeySize,
+		s.AvgDocKeySize, s.EntriesPerDoc, s.Selectivity)
+}
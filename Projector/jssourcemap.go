@@ -0,0 +1,119 @@
+package common
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Library functions authored in TypeScript, or JavaScript newer than
+// projector's engine, are stored transpiled along with a version 3 source
+// map. Projector only ever sees the generated code, so line numbers in its
+// errors are mapped back to the source with the helpers below.
+
+const jsSourceMapDigits = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789+/"
+
+type jsSourceMap struct {
+	Version  int    `json:"version"`
+	Mappings string `json:"mappings"`
+}
+
+// "line N" and "line N column M", as in compile errors and exceptions
+// reported by projector.
+var jsErrorLineRe = regexp.MustCompile(`line (\d+)(?: column (\d+))?`)
+
+func decodeJSSourceMapVLQ(segment string) ([]int, error) {
+	values := make([]int, 0, 5)
+	value, shift := 0, uint(0)
+
+	for i := 0; i < len(segment); i++ {
+		digit := strings.IndexByte(jsSourceMapDigits, segment[i])
+		if digit < 0 {
+			return nil, fmt.Errorf("invalid source map segment %v", segment)
+		}
+
+		value += (digit & 31) << shift
+		if digit&32 != 0 {
+			shift += 5
+			continue
+		}
+
+		if value&1 != 0 {
+			value = -(value >> 1)
+		} else {
+			value >>= 1
+		}
+		values = append(values, value)
+		value, shift = 0, 0
+	}
+
+	if shift != 0 {
+		return nil, fmt.Errorf("truncated source map segment %v", segment)
+	}
+	return values, nil
+}
+
+// JSOriginalPosition returns the position in the source of a position in
+// the generated code, lines are 1-based and columns 0-based. ok is false
+// when the source map does not cover the position, as for helpers the
+// transpiler generates.
+func JSOriginalPosition(sourceMap string, line, column int) (srcLine, srcColumn int, ok bool) {
+	var sm jsSourceMap
+	if line < 1 || json.Unmarshal([]byte(sourceMap), &sm) != nil || sm.Version != 3 {
+		return 0, 0, false
+	}
+
+	// generated column, source, source line, source column, name
+	var state [5]int
+	for i, mappings := range strings.Split(sm.Mappings, ";") {
+		state[0] = 0
+		for _, segment := range strings.Split(mappings, ",") {
+			if segment == "" {
+				continue
+			}
+
+			fields, err := decodeJSSourceMapVLQ(segment)
+			if err != nil || len(fields) > len(state) {
+				return 0, 0, false
+			}
+			for j, field := range fields {
+				state[j] += field
+			}
+
+			// last segment at or before column, else the first of the line
+			if i+1 == line && len(fields) >= 4 && (!ok || state[0] <= column) {
+				srcLine, srcColumn, ok = state[2]+1, state[3], true
+			}
+		}
+
+		if i+1 == line {
+			break
+		}
+	}
+	return
+}
+
+// MapJSErrorLines rewrites the lines, and columns, an error of the
+// generated code refers to into those of the source. Lines the source map
+// does not cover are left as they are.
+func MapJSErrorLines(sourceMap, msg string) string {
+	if sourceMap == "" {
+		return msg
+	}
+
+	return jsErrorLineRe.ReplaceAllStringFunc(msg, func(match string) string {
+		sub := jsErrorLineRe.FindStringSubmatch(match)
+		line, _ := strconv.Atoi(sub[1])
+		column, _ := strconv.Atoi(sub[2])
+
+		srcLine, srcColumn, ok := JSOriginalPosition(sourceMap, line, column)
+		if !ok {
+			return match
+		} else if sub[2] == "" {
+			return fmt.Sprintf("line %v", srcLine)
+		}
+		return fmt.Sprintf("line %v column %v", srcLine, srcColumn)
+	})
+}
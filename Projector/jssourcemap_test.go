@@ -0,0 +1,90 @@
+package common
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDecodeJSSourceMapVLQ(t *testing.T) {
+	tests := []struct {
+		segment string
+		values  []int
+	}{
+		{"A", []int{0}},
+		{"C", []int{1}},
+		{"D", []int{-1}},
+		{"gB", []int{16}},
+		{"hB", []int{-16}},
+		{"2H", []int{123}},
+		{"AAAA", []int{0, 0, 0, 0}},
+		{"AACAC", []int{0, 0, 1, 0, 1}},
+		{"SAAS", []int{9, 0, 0, 9}},
+	}
+
+	for _, test := range tests {
+		values, err := decodeJSSourceMapVLQ(test.segment)
+		if err != nil {
+			t.Errorf("%v: unexpected error %v", test.segment, err)
+		} else if !reflect.DeepEqual(values, test.values) {
+			t.Errorf("%v: expected %v, got %v", test.segment, test.values, values)
+		}
+	}
+
+	for _, segment := range []string{"g", "AA*A"} {
+		if _, err := decodeJSSourceMapVLQ(segment); err == nil {
+			t.Errorf("%v: expected an error", segment)
+		}
+	}
+}
+
+// generated line 1 is source line 1, line 2 has segments at columns 0
+// and 4 mapping to source line 3 columns 2 and 6, line 3 is not mapped.
+const testJSSourceMap = `{"version":3,"mappings":"AAAA;AAEE,IAAI;"}`
+
+func TestJSOriginalPosition(t *testing.T) {
+	tests := []struct {
+		line, column       int
+		srcLine, srcColumn int
+		ok                 bool
+	}{
+		{1, 0, 1, 0, true},
+		{2, 0, 3, 2, true},
+		{2, 3, 3, 2, true},
+		{2, 4, 3, 6, true},
+		{2, 10, 3, 6, true},
+		{3, 0, 0, 0, false},
+		{0, 0, 0, 0, false},
+	}
+
+	for _, test := range tests {
+		line, column, ok := JSOriginalPosition(testJSSourceMap, test.line, test.column)
+		if ok != test.ok || line != test.srcLine || column != test.srcColumn {
+			t.Errorf("%v:%v: expected %v:%v %v, got %v:%v %v", test.line, test.column,
+				test.srcLine, test.srcColumn, test.ok, line, column, ok)
+		}
+	}
+
+	if _, _, ok := JSOriginalPosition(`{"version":2,"mappings":"AAAA"}`, 1, 0); ok {
+		t.Errorf("expected a version 2 source map to be rejected")
+	}
+}
+
+func TestMapJSErrorLines(t *testing.T) {
+	tests := []struct {
+		msg, mapped string
+	}{
+		{"SyntaxError at line 2", "SyntaxError at line 3"},
+		{"TypeError at line 2 column 4", "TypeError at line 3 column 6"},
+		{"ReferenceError at line 3", "ReferenceError at line 3"},
+		{"no position", "no position"},
+	}
+
+	for _, test := range tests {
+		if mapped := MapJSErrorLines(testJSSourceMap, test.msg); mapped != test.mapped {
+			t.Errorf("expected %q, got %q", test.mapped, mapped)
+		}
+	}
+	if msg := MapJSErrorLines("", "at line 2"); msg != "at line 2" {
+		t.Errorf("expected the message as is without a source map, got %q", msg)
+	}
+}
@@ -0,0 +1,71 @@
+package protobuf
+
+import "encoding/hex"
+import "encoding/json"
+import "fmt"
+import "time"
+import "github.com/couchbase/indexing/secondary/collatejson"
+import c "github.com/couchbase/indexing/secondary/common"
+
+// JSTryTimeout bounds how long the entry point may run for one document
+// tried, a function looping forever fails that document.
+const JSTryTimeout = time.Second
+
+// Meta of a tried document, as passed to the entry point
+func jsTryMeta(meta *c.JSTryMeta) map[string]interface{} {
+	return map[string]interface{}{
+		"id":         meta.ID,
+		"cas":        meta.Cas,
+		"flags":      meta.Flags,
+		"expiration": meta.Expiration,
+		"locktime":   meta.Locktime,
+		"nru":        meta.Nru,
+		"byseqno":    meta.Byseqno,
+		"revseqno":   meta.Revseqno,
+	}
+}
+
+// TryJSFunction compiles code and evaluates its entry point against docs,
+// the way projector evaluates it for mutations, but for JSTryTimeout. The
+// function is unloaded from the engine once tried.
+func TryJSFunction(
+	name, code, entryPoint string, docs []*c.JSTryDoc) ([]*c.JSTryResult, error) {
+
+	if len(docs) > c.JSTryMaxDocs {
+		return nil, c.ErrorJSTryTooManyDocs
+	}
+	if entryPoint == "" {
+		entryPoint = c.DefaultJSEntryPoint
+	}
+
+	J := NewJSEvaluator("try:"+name+"@"+c.JSFunctionHash(code), code, entryPoint)
+	defer J.Close()
+	J.Timeout = JSTryTimeout
+	if err := J.Compile(); err != nil {
+		return nil, fmt.Errorf("function %v: %v", name, err)
+	}
+
+	codec := collatejson.NewCodec(16)
+	results := make([]*c.JSTryResult, 0, len(docs))
+	for _, doc := range docs {
+		trace := J.RunTrace([]byte(doc.Meta.ID), []byte(doc.Doc), jsTryMeta(&doc.Meta), make([]byte, 0, 1024))
+		result := &c.JSTryResult{
+			ID:         doc.Meta.ID,
+			Emits:      trace.Emits,
+			Console:    trace.Console,
+			Exception:  trace.Exception,
+			DurationUs: trace.Duration.Nanoseconds() / 1000,
+		}
+		if len(trace.Key) > 0 {
+			result.Collated = hex.EncodeToString(trace.Key)
+			key, err := codec.Decode(trace.Key, make([]byte, 0, 3*len(trace.Key)+16))
+			if err != nil {
+				result.KeyError = err.Error()
+			} else {
+				result.Key = json.RawMessage(key)
+			}
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
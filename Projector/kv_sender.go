@@ -23,6 +23,7 @@ import (
 	"github.com/golang/protobuf/proto"
 	"net"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -43,6 +44,14 @@ type kvSender struct {
 
 	cInfoCache *c.ClusterInfoCache
 	config     c.Config
+
+	//buckets of each stream whose JavaScript instances have a topic of
+	//their own
+	jsTopicLock sync.Mutex
+	jsTopics    map[c.StreamId]map[string]bool
+
+	//closed on shutdown, stops observing library function references
+	jsRefsCancelCh chan struct{}
 }
 
 func NewKVSender(supvCmdch MsgChannel, supvRespch MsgChannel,
@@ -62,12 +71,16 @@ func NewKVSender(supvCmdch MsgChannel, supvRespch MsgChannel,
 		supvRespch: supvRespch,
 		cInfoCache: cinfo,
 		config:     config,
+		jsTopics:   make(map[c.StreamId]map[string]bool),
+
+		jsRefsCancelCh: make(chan struct{}),
 	}
 
 	k.cInfoCache.SetMaxRetries(MAX_CLUSTER_FETCH_RETRY)
 	k.cInfoCache.SetLogPrefix("KVSender: ")
 	//start kvsender loop which listens to commands from its supervisor
 	go k.run()
+	go k.observeFunctionRefs()
 
 	return k, &MsgSuccess{}
 
@@ -86,6 +99,7 @@ loop:
 			if ok {
 				if cmd.GetMsgType() == KV_SENDER_SHUTDOWN {
 					logging.Infof("KVSender::run Shutting Down")
+					close(k.jsRefsCancelCh)
 					k.supvCmdch <- &MsgSuccess{}
 					break loop
 				}
@@ -124,6 +138,12 @@ func (k *kvSender) handleSupvervisorCommands(cmd Message) {
 	case CONFIG_SETTINGS_UPDATE:
 		k.handleConfigUpdate(cmd)
 
+	case KV_SENDER_VALIDATE_FUNCTION:
+		k.handleValidateFunction(cmd)
+
+	case KV_SENDER_UPDATE_INDEX_LIST_IN_STREAM:
+		k.handleUpdateIndexListInStream(cmd)
+
 	default:
 		logging.Errorf("KVSender::handleSupvervisorCommands "+
 			"Received Unknown Command %v", cmd)
@@ -178,6 +198,7 @@ func (k *kvSender) handleRemoveIndexListFromStream(cmd Message) {
 	respCh := cmd.(*MsgStreamUpdate).GetResponseChannel()
 	stopCh := cmd.(*MsgStreamUpdate).GetStopChannel()
 
+	unregisterFunctionRefs(delIndexList)
 	go k.deleteIndexesFromStream(streamId, delIndexList, respCh, stopCh)
 
 	k.supvCmdch <- &MsgSuccess{}
@@ -194,6 +215,10 @@ func (k *kvSender) handleRemoveBucketFromStream(cmd Message) {
 		return fmt.Sprintf("KVSender::handleRemoveBucketFromStream %v %v %v", streamId, bucket, cmd)
 	})
 
+	//dropping the last index of a bucket removes the bucket, with the
+	//dropped instance in the index list
+	unregisterFunctionRefs(cmd.(*MsgStreamUpdate).GetIndexList())
+
 	go k.deleteBucketsFromStream(streamId, []string{bucket}, respCh, stopCh)
 
 	k.supvCmdch <- &MsgSuccess{}
@@ -269,28 +294,39 @@ func (k *kvSender) openMutationStream(streamId c.StreamId, indexInstList []c.Ind
 
 	var rollbackTs *protobuf.TsVbuuid
 	var activeTs *protobuf.TsVbuuid
-	topic := getTopicForStreamId(streamId)
+	topicInsts := k.splitInstancesByTopic(streamId, bucket, protoInstList, true)
+	topics := topicsOf(topicInsts)
+	activeTsByTopic := make(map[string]*protobuf.TsVbuuid)
+	instErrs := make(map[c.IndexInstId]string)
 
 	fn := func(r int, err error) error {
 
 		//clear the error before every retry
 		err = nil
+		if len(topicInsts) == 0 {
+			//projectors could evaluate none of the instances
+			return nil
+		}
 		for _, addr := range addrs {
 
 			execWithStopCh(func() {
 				ap := newProjClient(addr)
-				if res, ret := k.sendMutationTopicRequest(ap, topic, restartTsList, protoInstList); ret != nil {
-					//for all errors, retry
-					logging.Errorf("KVSender::openMutationStream %v %v Error Received %v from %v",
-						streamId, bucket, ret, addr)
-					err = ret
-				} else {
-					activeTs = updateActiveTsFromResponse(bucket, activeTs, res)
-					if rollbackTs != nil {
-						logging.Infof("KVSender::openMutationStream %v %v Projector %v Rollback Received %v",
-							streamId, bucket, addr, rollbackTs)
+				for topic, topicInstList := range topicInsts {
+					if res, ret := k.sendMutationTopicRequest(ap, topic, restartTsList, topicInstList); ret != nil {
+						//for all errors, retry. instances projector
+						//cannot evaluate are left out of the retry.
+						logging.Errorf("KVSender::openMutationStream %v %v Error Received %v from %v",
+							streamId, bucket, ret, addr)
+						collectInstanceErrors(ret, instErrs, topicInsts)
+						err = ret
+					} else {
+						activeTsByTopic[topic] = updateActiveTsFromResponse(bucket, activeTsByTopic[topic], res)
+						if rollbackTs != nil {
+							logging.Infof("KVSender::openMutationStream %v %v Projector %v Rollback Received %v",
+								streamId, bucket, addr, rollbackTs)
+						}
+						rollbackTs = updateRollbackTsFromResponse(bucket, rollbackTs, res)
 					}
-					rollbackTs = updateRollbackTsFromResponse(bucket, rollbackTs, res)
 				}
 			}, stopCh)
 		}
@@ -302,11 +338,16 @@ func (k *kvSender) openMutationStream(streamId c.StreamId, indexInstList []c.Ind
 			//retry for any error
 			return err
 		} else {
-			//check if we have received activeTs for all vbuckets
+			//check if we have received activeTs for all vbuckets,
+			//on every topic of the stream
 			retry := false
-			if activeTs == nil || activeTs.Len() != len(vbnos) {
-				retry = true
+			for topic := range topicInsts {
+				ts := activeTsByTopic[topic]
+				if ts == nil || ts.Len() != len(vbnos) {
+					retry = true
+				}
 			}
+			activeTs = streamTs(streamId, activeTsByTopic)
 
 			if retry {
 				return errors.New("ErrPartialVbStart")
@@ -320,6 +361,17 @@ func (k *kvSender) openMutationStream(streamId c.StreamId, indexInstList []c.Ind
 	rh := c.NewRetryHelper(MAX_KV_REQUEST_RETRY, time.Second, BACKOFF_FACTOR, fn)
 	err = rh.Run()
 
+	k.reportInstanceErrors(streamId, bucket, addrs, topics, instErrs)
+	if len(topicInsts) == 0 {
+		logging.Errorf("KVSender::openMutationStream %v %v No instance can be evaluated",
+			streamId, bucket)
+		respCh <- &MsgError{
+			err: Error{code: ERROR_KVSENDER_STREAM_REQUEST_ERROR,
+				severity: NORMAL,
+				cause:    ErrNoInstanceEvaluated}}
+		return
+	}
+
 	if rollbackTs != nil {
 		//convert from protobuf to native format
 		numVbuckets := k.config["numVbuckets"].Int()
@@ -341,6 +393,8 @@ func (k *kvSender) openMutationStream(streamId c.StreamId, indexInstList []c.Ind
 				severity: FATAL,
 				cause:    err}}
 	} else {
+		_, jsTopic := topicInsts[getJSTopicForStreamId(streamId)]
+		k.setJSTopic(streamId, bucket, jsTopic)
 		numVbuckets := k.config["numVbuckets"].Int()
 		respCh <- &MsgSuccessOpenStream{activeTs: activeTs.ToTsVbuuid(numVbuckets)}
 	}
@@ -368,7 +422,7 @@ func (k *kvSender) restartVbuckets(streamId c.StreamId, restartTs *c.TsVbuuid,
 	protoRestartTs = protoTs.FromTsVbuuid(restartTs)
 
 	var rollbackTs *protobuf.TsVbuuid
-	topic := getTopicForStreamId(streamId)
+	topics := k.getTopicsForStreamId(streamId, restartTs.Bucket)
 	rollback := false
 	aborted := false
 
@@ -378,13 +432,15 @@ func (k *kvSender) restartVbuckets(streamId c.StreamId, restartTs *c.TsVbuuid,
 			aborted = execWithStopCh(func() {
 				ap := newProjClient(addr)
 
-				if res, ret := k.sendRestartVbuckets(ap, topic, connErrVbs, protoRestartTs); ret != nil {
-					//retry for all errors
-					logging.Errorf("KVSender::restartVbuckets %v %v Error Received %v from %v",
-						streamId, restartTs.Bucket, ret, addr)
-					err = ret
-				} else {
-					rollbackTs = updateRollbackTsFromResponse(restartTs.Bucket, rollbackTs, res)
+				for _, topic := range topics {
+					if res, ret := k.sendRestartVbuckets(ap, topic, connErrVbs, protoRestartTs); ret != nil {
+						//retry for all errors
+						logging.Errorf("KVSender::restartVbuckets %v %v Error Received %v from %v",
+							streamId, restartTs.Bucket, ret, addr)
+						err = ret
+					} else {
+						rollbackTs = updateRollbackTsFromResponse(restartTs.Bucket, rollbackTs, res)
+					}
 				}
 			}, stopCh)
 		}
@@ -448,39 +504,71 @@ func (k *kvSender) addIndexForExistingBucket(streamId c.StreamId, bucket string,
 		return
 	}
 
-	var currentTs *protobuf.TsVbuuid
 	protoInstList := convertIndexListToProto(k.config, k.cInfoCache, indexInstList, streamId)
-	topic := getTopicForStreamId(streamId)
+	protoInstList, currentTs := k.openJSTopic(streamId, bucket, addrs, protoInstList)
+	if len(protoInstList) == 0 {
+		//every instance is on the JavaScript topic just opened
+		numVbuckets := k.config["numVbuckets"].Int()
+		respCh <- &MsgStreamUpdate{mType: MSG_SUCCESS,
+			streamId:  streamId,
+			bucket:    bucket,
+			restartTs: currentTs.ToTsVbuuid(numVbuckets)}
+		return
+	}
 
+	topicInsts := k.splitInstancesByTopic(streamId, bucket, protoInstList, false)
+	topics := topicsOf(topicInsts)
+	currentTsByTopic := make(map[string]*protobuf.TsVbuuid)
+	instErrs := make(map[c.IndexInstId]string)
 	fn := func(r int, err error) error {
 
 		//clear the error before every retry
 		err = nil
+		if len(topicInsts) == 0 {
+			//projectors could evaluate none of the instances
+			return nil
+		}
 		for _, addr := range addrs {
 			execWithStopCh(func() {
 				ap := newProjClient(addr)
-				if res, ret := sendAddInstancesRequest(ap, topic, protoInstList); ret != nil {
-					logging.Errorf("KVSender::addIndexForExistingBucket %v %v Error Received %v from %v",
-						streamId, bucket, ret, addr)
-					err = ret
-				} else {
-					currentTs = updateCurrentTsFromResponse(bucket, currentTs, res)
+				for topic, topicInstList := range topicInsts {
+					if res, ret := sendAddInstancesRequest(ap, topic, topicInstList); ret != nil {
+						logging.Errorf("KVSender::addIndexForExistingBucket %v %v Error Received %v from %v",
+							streamId, bucket, ret, addr)
+						collectInstanceErrors(ret, instErrs, topicInsts)
+						err = ret
+					} else {
+						currentTsByTopic[topic] = updateCurrentTsFromResponse(bucket, currentTsByTopic[topic], res)
+					}
 				}
 			}, stopCh)
 		}
 
-		//check if we have received currentTs for all vbuckets
+		//check if we have received currentTs for all vbuckets,
+		//on every topic of the request
 		numVbuckets := k.config["numVbuckets"].Int()
-		if currentTs == nil || currentTs.Len() != numVbuckets {
-			return errors.New("ErrPartialVbStart")
-		} else {
-			return err
+		for topic := range topicInsts {
+			if ts := currentTsByTopic[topic]; ts == nil || ts.Len() != numVbuckets {
+				return errors.New("ErrPartialVbStart")
+			}
 		}
+		currentTs = streamTs(streamId, currentTsByTopic)
+		return err
 
 	}
 
 	rh := c.NewRetryHelper(MAX_KV_REQUEST_RETRY, time.Second, BACKOFF_FACTOR, fn)
 	err = rh.Run()
+	k.reportInstanceErrors(streamId, bucket, addrs, topics, instErrs)
+	if len(topicInsts) == 0 {
+		logging.Errorf("KVSender::addIndexForExistingBucket %v %v No instance can be evaluated",
+			streamId, bucket)
+		respCh <- &MsgError{
+			err: Error{code: ERROR_KVSENDER_STREAM_REQUEST_ERROR,
+				severity: NORMAL,
+				cause:    ErrNoInstanceEvaluated}}
+		return
+	}
 	if err != nil {
 		logging.Errorf("KVSender::addIndexForExistingBucket %v %v Error from Projector %v",
 			streamId, bucket, err)
@@ -519,7 +607,7 @@ func (k *kvSender) deleteIndexesFromStream(streamId c.StreamId, indexInstList []
 		uuids = append(uuids, uint64(indexInst.InstId))
 	}
 
-	topic := getTopicForStreamId(streamId)
+	topics := k.getTopicsForStreamId(streamId, indexInstList[0].Defn.Bucket)
 
 	fn := func(r int, err error) error {
 
@@ -528,17 +616,19 @@ func (k *kvSender) deleteIndexesFromStream(streamId c.StreamId, indexInstList []
 		for _, addr := range addrs {
 			execWithStopCh(func() {
 				ap := newProjClient(addr)
-				if ret := sendDelInstancesRequest(ap, topic, uuids); ret != nil {
-					logging.Errorf("KVSender::deleteIndexesFromStream %v %v Error Received %v from %v",
-						streamId, indexInstList[0].Defn.Bucket, ret, addr)
-					//Treat TopicMissing/GenServer.Closed/InvalidBucket as success
-					if ret.Error() == projClient.ErrorTopicMissing.Error() ||
-						ret.Error() == c.ErrorClosed.Error() ||
-						ret.Error() == projClient.ErrorInvalidBucket.Error() {
-						logging.Infof("KVSender::deleteIndexesFromStream %v %v Treating %v As Success",
-							streamId, indexInstList[0].Defn.Bucket, ret)
-					} else {
-						err = ret
+				for _, topic := range topics {
+					if ret := sendDelInstancesRequest(ap, topic, uuids); ret != nil {
+						logging.Errorf("KVSender::deleteIndexesFromStream %v %v Error Received %v from %v",
+							streamId, indexInstList[0].Defn.Bucket, ret, addr)
+						//Treat TopicMissing/GenServer.Closed/InvalidBucket as success
+						if ret.Error() == projClient.ErrorTopicMissing.Error() ||
+							ret.Error() == c.ErrorClosed.Error() ||
+							ret.Error() == projClient.ErrorInvalidBucket.Error() {
+							logging.Infof("KVSender::deleteIndexesFromStream %v %v Treating %v As Success",
+								streamId, indexInstList[0].Defn.Bucket, ret)
+						} else {
+							err = ret
+						}
 					}
 				}
 			}, stopCh)
@@ -576,6 +666,7 @@ func (k *kvSender) deleteBucketsFromStream(streamId c.StreamId, buckets []string
 	}
 
 	topic := getTopicForStreamId(streamId)
+	jsBuckets, jsShutdown := k.jsTopicBucketsToDrop(streamId, buckets)
 
 	fn := func(r int, err error) error {
 
@@ -584,6 +675,11 @@ func (k *kvSender) deleteBucketsFromStream(streamId c.StreamId, buckets []string
 		for _, addr := range addrs {
 			execWithStopCh(func() {
 				ap := newProjClient(addr)
+				if ret := k.dropJSTopicBuckets(ap, streamId, jsBuckets, jsShutdown); ret != nil {
+					logging.Errorf("KVSender::deleteBucketsFromStream %v %v Error Received %v from %v",
+						streamId, buckets[0], ret, addr)
+					err = ret
+				}
 				if ret := sendDelBucketsRequest(ap, topic, buckets); ret != nil {
 					logging.Errorf("KVSender::deleteBucketsFromStream %v %v Error Received %v from %v",
 						streamId, buckets[0], ret, addr)
@@ -613,6 +709,9 @@ func (k *kvSender) deleteBucketsFromStream(streamId c.StreamId, buckets []string
 		return
 	}
 
+	for _, bucket := range jsBuckets {
+		k.setJSTopic(streamId, bucket, false)
+	}
 	respCh <- &MsgSuccess{}
 }
 
@@ -631,6 +730,7 @@ func (k *kvSender) closeMutationStream(streamId c.StreamId, bucket string,
 	}
 
 	topic := getTopicForStreamId(streamId)
+	jsBuckets, jsShutdown := k.jsTopicBucketsToDrop(streamId, []string{bucket})
 
 	fn := func(r int, err error) error {
 
@@ -639,6 +739,11 @@ func (k *kvSender) closeMutationStream(streamId c.StreamId, bucket string,
 		for _, addr := range addrs {
 			execWithStopCh(func() {
 				ap := newProjClient(addr)
+				if ret := k.dropJSTopicBuckets(ap, streamId, jsBuckets, jsShutdown); ret != nil {
+					logging.Errorf("KVSender::closeMutationStream %v %v Error Received %v from %v",
+						streamId, bucket, ret, addr)
+					err = ret
+				}
 				if ret := sendShutdownTopic(ap, topic); ret != nil {
 					logging.Errorf("KVSender::closeMutationStream %v %v Error Received %v from %v",
 						streamId, bucket, ret, addr)
@@ -668,6 +773,7 @@ func (k *kvSender) closeMutationStream(streamId c.StreamId, bucket string,
 		return
 	}
 
+	k.setJSTopic(streamId, bucket, false)
 	respCh <- &MsgSuccess{}
 
 }
@@ -1163,17 +1269,7 @@ func convertIndexDefnToProtobuf(indexDefn c.IndexDefn) *protobuf.IndexDefn {
 
 	using := protobuf.StorageType(
 		protobuf.StorageType_value[strings.ToLower(string(indexDefn.Using))]).Enum()
-	//exprType := protobuf.ExprType(protobuf.ExprType_value["JAVASCRIPT"]).Enum() //This line if the it contains jsfile path
 	exprType := protobuf.ExprType(protobuf.ExprType_value[strings.ToUpper(string(indexDefn.ExprType))]).Enum()
-
-	//Should take it from metakv
-	jsExprs := map[string]map[string]string{
-		"B1": map[string]string{"`age1`": "/Users/ankitdamodarprabhu/GOC/jsFiles/age2.js", "`company`": "/Users/ankitdamodarprabhu/GOC/jsFiles/company.js", "`age`": "/Users/ankitdamodarprabhu/GOC/jsFiles/age1.js", "`docid`": "/Users/ankitdamodarprabhu/GOC/jsFiles/docid.js"},
-		"B2": map[string]string{"`age1`": "/Users/ankitdamodarprabhu/GOC/jsFiles/age2.js", "`company`": "/Users/ankitdamodarprabhu/GOC/jsFiles/company.js", "`age`": "/Users/ankitdamodarprabhu/GOC/jsFiles/age1.js", "`docid`": "/Users/ankitdamodarprabhu/GOC/jsFiles/docid.js"},
-		"B3": map[string]string{"`age1`": "/Users/ankitdamodarprabhu/GOC/jsFiles/age2.js", "`company`": "/Users/ankitdamodarprabhu/GOC/jsFiles/company.js", "`age`": "/Users/ankitdamodarprabhu/GOC/jsFiles/age1.js", "`docid`": "/Users/ankitdamodarprabhu/GOC/jsFiles/docid.js"},
-		"B4": map[string]string{"`age1`": "/Users/ankitdamodarprabhu/GOC/jsFiles/age2.js", "`company`": "/Users/ankitdamodarprabhu/GOC/jsFiles/company.js", "`age`": "/Users/ankitdamodarprabhu/GOC/jsFiles/age1.js", "`docid`": "/Users/ankitdamodarprabhu/GOC/jsFiles/docid.js"},
-	}
-
 	partnScheme := protobuf.PartitionScheme(
 		protobuf.PartitionScheme_value[string(c.SINGLE)]).Enum()
 	if c.IsPartitioned(indexDefn.PartitionScheme) {
@@ -1193,7 +1289,20 @@ func convertIndexDefnToProtobuf(indexDefn c.IndexDefn) *protobuf.IndexDefn {
 		PartnExpressions:   indexDefn.PartitionKeys,
 		WhereExpression:    proto.String(indexDefn.WhereExpr),
 		RetainDeletedXATTR: proto.Bool(indexDefn.RetainDeletedXATTR),
-		JSPath:             proto.String(jsExprs[indexDefn.Bucket][indexDefn.SecExprs[0]]), //Path to the JSFILE
+	}
+
+	//library function resolved at CREATE INDEX time, shipped with its
+	//source so that every projector compiles identical code
+	if indexDefn.ExprType == c.JavaScript {
+		defn.FuncName = proto.String(indexDefn.FuncName)
+		defn.FuncVersion = proto.Uint64(indexDefn.FuncVersion)
+		defn.FuncHash = proto.String(indexDefn.FuncHash)
+		defn.FuncEntryPoints = []string{indexDefn.FuncEntryPoint}
+		if policy, ok := protobuf.JSFailurePolicy_value[string(indexDefn.FuncFailurePolicy)]; ok {
+			defn.FuncFailurePolicy = protobuf.JSFailurePolicy(policy).Enum()
+		}
+		defn.FuncCode = proto.String(indexDefn.FuncCode)
+		defn.FuncArrayKey = proto.Bool(indexDefn.IsJSArrayIndex())
 	}
 
 	return defn
@@ -1355,11 +1464,11 @@ func formatInstances(instances []*protobuf.Instance) string {
 		instanceStr += fmt.Sprintf("instId:%v ", inst.GetInstId())
 		instanceStr += fmt.Sprintf("state:%v ", inst.GetState())
 		instanceStr += fmt.Sprintf(" definition:<defnID:%v bucket:%v isPrimary:%v name:%v using:%v "+
-			"exprType:%v secExpressions:%v partitionScheme:%v whereExpression:%v > ",
+			"exprType:%v secExpressions:%v partitionScheme:%v whereExpression:%v funcName:%v funcVersion:%v funcHash:%v > ",
 			defn.GetDefnID(), defn.GetBucket(), defn.GetIsPrimary(),
 			defn.GetName(), defn.GetUsing(), defn.GetExprType(),
 			logging.TagUD(defn.GetSecExpressions()), defn.GetPartitionScheme(),
-			logging.TagUD(defn.GetWhereExpression()))
+			logging.TagUD(defn.GetWhereExpression()), defn.GetFuncName(), defn.GetFuncVersion(), defn.GetFuncHash())
 		instanceStr += fmt.Sprintf("singlePartn:%v", inst.GetSinglePartn())
 		instanceStr += "> "
 	}
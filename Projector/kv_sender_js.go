@@ -0,0 +1,699 @@
+// Copyright (c) 2014 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package indexer
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/couchbase/cbauth/metakv"
+	c "github.com/couchbase/indexing/secondary/common"
+	"github.com/couchbase/indexing/secondary/logging"
+	projClient "github.com/couchbase/indexing/secondary/projector/client"
+	protobuf "github.com/couchbase/indexing/secondary/protobuf/projector"
+)
+
+// Requests kvSender serves for JavaScript indexes. They extend the
+// message types in message.go.
+const (
+	KV_SENDER_VALIDATE_FUNCTION MsgType = iota + 1000
+	KV_SENDER_UPDATE_INDEX_LIST_IN_STREAM
+	KV_SENDER_INDEX_INST_ERRORS
+	KV_SENDER_FUNCTION_REF_ERRORS
+)
+
+//ErrNoInstanceEvaluated is sent back when projectors could build an
+//evaluator for none of the instances of a stream request.
+var ErrNoInstanceEvaluated = errors.New("ErrNoInstanceEvaluated")
+
+//MsgValidateFunction asks kvSender to compile the library function of a
+//JavaScript index on every projector, during CREATE INDEX.
+type MsgValidateFunction struct {
+	defn   c.IndexDefn
+	respCh MsgChannel
+}
+
+func (m *MsgValidateFunction) GetMsgType() MsgType {
+	return KV_SENDER_VALIDATE_FUNCTION
+}
+
+func (m *MsgValidateFunction) GetIndexDefn() c.IndexDefn {
+	return m.defn
+}
+
+func (m *MsgValidateFunction) GetResponseChannel() MsgChannel {
+	return m.respCh
+}
+
+func (m *MsgValidateFunction) String() string {
+	return fmt.Sprintf("\n\tMessage: MsgValidateFunction\n\tDefn: %v", m.defn)
+}
+
+func (k *kvSender) handleValidateFunction(cmd Message) {
+
+	defn := cmd.(*MsgValidateFunction).GetIndexDefn()
+	respCh := cmd.(*MsgValidateFunction).GetResponseChannel()
+
+	logging.LazyDebug(func() string {
+		return fmt.Sprintf("KVSender::handleValidateFunction %v %v", defn.Name, defn.FuncName)
+	})
+
+	go k.validateFunction(defn, respCh)
+
+	k.supvCmdch <- &MsgSuccess{}
+}
+
+//validateFunction compiles the function with the engine of every projector,
+//so that a broken function fails CREATE INDEX instead of the stream.
+func (k *kvSender) validateFunction(defn c.IndexDefn, respCh MsgChannel) {
+
+	addrs, err := k.getAllProjectorAddrs()
+	if err != nil {
+		logging.Errorf("KVSender::validateFunction %v Error in fetching cluster info %v",
+			defn.Name, err)
+		respCh <- &MsgError{
+			err: Error{code: ERROR_KVSENDER_STREAM_REQUEST_ERROR,
+				severity: FATAL,
+				cause:    err}}
+		return
+	}
+
+	for _, addr := range addrs {
+		ap := newProjClient(addr)
+		res, err := ap.ValidateFunction(defn.FuncName, defn.FuncCode,
+			[]string{defn.FuncEntryPoint})
+		if err != nil {
+			logging.Errorf("KVSender::validateFunction %v Error Received %v from %v",
+				defn.Name, err, addr)
+			respCh <- &MsgError{
+				err: Error{code: ERROR_KVSENDER_STREAM_REQUEST_ERROR,
+					severity: FATAL,
+					cause:    err}}
+			return
+		}
+
+		if ferr := res.ToError(); ferr != nil {
+			logging.Errorf("KVSender::validateFunction %v Function %v version %v "+
+				"rejected by %v: %v", defn.Name, defn.FuncName, defn.FuncVersion, addr, ferr)
+			respCh <- &MsgError{
+				err: Error{code: ERROR_KVSENDER_STREAM_REQUEST_ERROR,
+					severity: NORMAL,
+					cause: fmt.Errorf("library function %v version %v: %v",
+						defn.FuncName, defn.FuncVersion, ferr)}}
+			return
+		}
+	}
+
+	//eventing refuses to delete the function while the index refers to it
+	if err := defn.RegisterJSFunctionRef(); err != nil {
+		logging.Errorf("KVSender::validateFunction %v Error in recording reference "+
+			"to function %v: %v", defn.Name, defn.FuncName, err)
+		respCh <- &MsgError{
+			err: Error{code: ERROR_KVSENDER_STREAM_REQUEST_ERROR,
+				severity: FATAL,
+				cause:    err}}
+		return
+	}
+
+	respCh <- &MsgSuccess{}
+}
+
+//handleUpdateIndexListInStream swaps the definitions of indexes already on
+//a stream, typically JavaScript indexes moved to another version of their
+//library function, without closing the stream.
+func (k *kvSender) handleUpdateIndexListInStream(cmd Message) {
+
+	streamId := cmd.(*MsgStreamUpdate).GetStreamId()
+	bucket := cmd.(*MsgStreamUpdate).GetBucket()
+	updateIndexList := cmd.(*MsgStreamUpdate).GetIndexList()
+	respCh := cmd.(*MsgStreamUpdate).GetResponseChannel()
+	stopCh := cmd.(*MsgStreamUpdate).GetStopChannel()
+
+	logging.LazyDebug(func() string {
+		return fmt.Sprintf("KVSender::handleUpdateIndexListInStream %v %v %v", streamId, bucket, cmd)
+	})
+
+	go k.updateIndexesInStream(streamId, bucket, updateIndexList, respCh, stopCh)
+
+	k.supvCmdch <- &MsgSuccess{}
+}
+
+//updateIndexesInStream sends UpdateInstancesRequest to every projector. On
+//success restartTs of the response carries, per vbucket, the last seqno
+//indexed with the old definition, entries up to it need to be rebuilt.
+func (k *kvSender) updateIndexesInStream(streamId c.StreamId, bucket string,
+	indexInstList []c.IndexInst, respCh MsgChannel, stopCh StopChannel) {
+
+	addrs, err := k.getAllProjectorAddrs()
+	if err != nil {
+		logging.Errorf("KVSender::updateIndexesInStream %v %v Error in fetching cluster info %v",
+			streamId, bucket, err)
+		respCh <- &MsgError{
+			err: Error{code: ERROR_KVSENDER_STREAM_REQUEST_ERROR,
+				severity: FATAL,
+				cause:    err}}
+		return
+	}
+
+	var switchTs *protobuf.TsVbuuid
+	protoInstList := convertIndexListToProto(k.config, k.cInfoCache, indexInstList, streamId)
+	topicInsts := k.splitInstancesByTopic(streamId, bucket, protoInstList, false)
+	//responses, per projector and topic, of the updates applied. Those
+	//already swapped their evaluators and are not asked again on retry.
+	applied := make(map[string]map[string]*protobuf.TimestampResponse)
+	fn := func(r int, err error) error {
+
+		//clear the error before every retry
+		err = nil
+		for _, addr := range addrs {
+			execWithStopCh(func() {
+				ap := newProjClient(addr)
+				for topic, topicInstList := range topicInsts {
+					if _, ok := applied[addr][topic]; ok {
+						continue
+					}
+					if res, ret := sendUpdateInstancesRequest(ap, topic, topicInstList); ret != nil {
+						logging.Errorf("KVSender::updateIndexesInStream %v %v Error Received %v from %v",
+							streamId, bucket, ret, addr)
+						err = ret
+					} else {
+						if applied[addr] == nil {
+							applied[addr] = make(map[string]*protobuf.TimestampResponse)
+						}
+						applied[addr][topic] = res
+					}
+				}
+			}, stopCh)
+		}
+
+		//rebuild the switch seqnos from every update applied so far
+		switchTs = nil
+		for _, responses := range applied {
+			for _, res := range responses {
+				switchTs = updateCurrentTsFromResponse(bucket, switchTs, res)
+			}
+		}
+
+		//check if we have received the switch seqno for all vbuckets
+		numVbuckets := k.config["numVbuckets"].Int()
+		if switchTs == nil || switchTs.Len() != numVbuckets {
+			return errors.New("ErrPartialVbUpdate")
+		} else {
+			return err
+		}
+
+	}
+
+	rh := c.NewRetryHelper(MAX_KV_REQUEST_RETRY, time.Second, BACKOFF_FACTOR, fn)
+	err = rh.Run()
+	if err != nil {
+		logging.Errorf("KVSender::updateIndexesInStream %v %v Error from Projector %v",
+			streamId, bucket, err)
+		respCh <- &MsgError{
+			err: Error{code: ERROR_KVSENDER_STREAM_REQUEST_ERROR,
+				severity: FATAL,
+				cause:    err}}
+		return
+	}
+
+	//references follow the version the indexes are now evaluated with
+	for _, indexInst := range indexInstList {
+		if err := indexInst.Defn.RegisterJSFunctionRef(); err != nil {
+			logging.Warnf("KVSender::updateIndexesInStream %v %v Error in recording reference "+
+				"of index %v to function %v: %v", streamId, bucket, indexInst.Defn.Name,
+				indexInst.Defn.FuncName, err)
+		}
+	}
+
+	numVbuckets := k.config["numVbuckets"].Int()
+	nativeTs := switchTs.ToTsVbuuid(numVbuckets)
+
+	respCh <- &MsgStreamUpdate{mType: MSG_SUCCESS,
+		streamId:  streamId,
+		bucket:    bucket,
+		restartTs: nativeTs}
+}
+
+//send the actual UpdateInstances request on adminport
+func sendUpdateInstancesRequest(ap *projClient.Client,
+	topic string,
+	instances []*protobuf.Instance) (*protobuf.TimestampResponse, error) {
+
+	logging.Infof("KVSender::sendUpdateInstancesRequest Projector %v Topic %v \nInstances %v",
+		ap, topic, formatInstances(instances))
+
+	res, err := ap.UpdateInstances(topic, instances)
+	if err != nil {
+		logging.Errorf("KVSender::sendUpdateInstancesRequest Unexpected Error During "+
+			"Update Instances Request Projector %v Topic %v IndexInst %v. Err %v", ap,
+			topic, formatInstances(instances), err)
+		return res, err
+	}
+
+	logging.Infof("KVSender::sendUpdateInstancesRequest Success Projector %v Topic %v",
+		ap, topic)
+	logging.LazyDebug(func() string {
+		return fmt.Sprintf(
+			"KVSender::sendUpdateInstancesRequest \n\tSwitchTs %v ", debugPrintTs(res.GetCurrentTimestamps(), ""))
+	})
+	return res, nil
+}
+
+//config key placing JavaScript index instances on a topic of their own,
+//so that a slow function only delays its own indexes. Optional, off
+//unless set.
+//
+//Both topics of a stream feed the same endpoint, which then receives the
+//control messages of each vbucket, StreamBegin, StreamEnd, Snapshot and
+//Sync, once per topic and at the pace of each. Indexer de-duplicates
+//them: a vbucket begins with its first StreamBegin and ends with its
+//last StreamEnd, and its stability seqno is the lowest of its topics.
+const jsTopicConfigKey = "javascript.dedicatedTopic"
+
+func getJSTopicForStreamId(streamId c.StreamId) string {
+	return getTopicForStreamId(streamId) + "_JS"
+}
+
+func (k *kvSender) jsTopicEnabled() bool {
+	if v, ok := k.config[jsTopicConfigKey]; ok {
+		return v.Bool()
+	}
+	return false
+}
+
+//hasJSTopic tells whether the JavaScript topic of the stream carries the
+//bucket, or any bucket when bucket is empty
+func (k *kvSender) hasJSTopic(streamId c.StreamId, bucket string) bool {
+	k.jsTopicLock.Lock()
+	defer k.jsTopicLock.Unlock()
+	if bucket == "" {
+		return len(k.jsTopics[streamId]) > 0
+	}
+	return k.jsTopics[streamId][bucket]
+}
+
+func (k *kvSender) setJSTopic(streamId c.StreamId, bucket string, jsTopic bool) {
+	k.jsTopicLock.Lock()
+	defer k.jsTopicLock.Unlock()
+	if jsTopic {
+		if k.jsTopics[streamId] == nil {
+			k.jsTopics[streamId] = make(map[string]bool)
+		}
+		k.jsTopics[streamId][bucket] = true
+	} else if buckets := k.jsTopics[streamId]; buckets != nil {
+		delete(buckets, bucket)
+		if len(buckets) == 0 {
+			delete(k.jsTopics, streamId)
+		}
+	}
+}
+
+//getTopicsForStreamId returns every projector topic that carries the
+//bucket on the stream
+func (k *kvSender) getTopicsForStreamId(streamId c.StreamId, bucket string) []string {
+	topics := []string{getTopicForStreamId(streamId)}
+	if k.hasJSTopic(streamId, bucket) {
+		topics = append(topics, getJSTopicForStreamId(streamId))
+	}
+	return topics
+}
+
+//jsTopicBucketsToDrop returns those of buckets the JavaScript topic of the
+//stream carries, and whether it carries no other bucket, in which case the
+//topic is shut down rather than left empty
+func (k *kvSender) jsTopicBucketsToDrop(streamId c.StreamId,
+	buckets []string) (jsBuckets []string, shutdown bool) {
+
+	k.jsTopicLock.Lock()
+	defer k.jsTopicLock.Unlock()
+	for _, bucket := range buckets {
+		if k.jsTopics[streamId][bucket] {
+			jsBuckets = append(jsBuckets, bucket)
+		}
+	}
+	return jsBuckets, len(jsBuckets) > 0 && len(jsBuckets) == len(k.jsTopics[streamId])
+}
+
+//dropJSTopicBuckets removes jsBuckets from the JavaScript topic of the
+//stream on a projector, or shuts the topic down
+func (k *kvSender) dropJSTopicBuckets(ap *projClient.Client, streamId c.StreamId,
+	jsBuckets []string, shutdown bool) error {
+
+	if len(jsBuckets) == 0 {
+		return nil
+	}
+
+	var err error
+	if jsTopic := getJSTopicForStreamId(streamId); shutdown {
+		err = sendShutdownTopic(ap, jsTopic)
+	} else {
+		err = sendDelBucketsRequest(ap, jsTopic, jsBuckets)
+	}
+	//Treat TopicMissing/GenServer.Closed as success
+	if err != nil && (err.Error() == projClient.ErrorTopicMissing.Error() ||
+		err.Error() == c.ErrorClosed.Error()) {
+		return nil
+	}
+	return err
+}
+
+//topicsOf returns the topics of a request, sorted
+func topicsOf(topicInsts map[string][]*protobuf.Instance) []string {
+	topics := make([]string, 0, len(topicInsts))
+	for topic := range topicInsts {
+		topics = append(topics, topic)
+	}
+	sort.Strings(topics)
+	return topics
+}
+
+//streamTs returns the timestamp of a stream from those of its topics, all
+//started from the same vbuckets: the one of its main topic, or of its
+//JavaScript topic when the request had no instance for the main one.
+func streamTs(streamId c.StreamId, tsByTopic map[string]*protobuf.TsVbuuid) *protobuf.TsVbuuid {
+	if ts := tsByTopic[getTopicForStreamId(streamId)]; ts != nil {
+		return ts
+	}
+	return tsByTopic[getJSTopicForStreamId(streamId)]
+}
+
+//openJSTopic subscribes the bucket to the JavaScript topic of a stream,
+//opening the topic when the stream has none, when JavaScript instances of
+//a bucket the topic does not carry are added with the topic enabled. The
+//bucket starts there from the current timestamp of the main topic, the
+//one instances added there would start from. Returns the instances left
+//for the main topic, every instance when the bucket could not be
+//subscribed, and the current timestamp. JavaScript instances already on
+//the main topic stay.
+func (k *kvSender) openJSTopic(streamId c.StreamId, bucket string, addrs []string,
+	protoInstList []*protobuf.Instance) ([]*protobuf.Instance, *protobuf.TsVbuuid) {
+
+	var n1qlInsts, jsInsts []*protobuf.Instance
+	for _, inst := range protoInstList {
+		if isJSInstance(inst) {
+			jsInsts = append(jsInsts, inst)
+		} else {
+			n1qlInsts = append(n1qlInsts, inst)
+		}
+	}
+	if !k.jsTopicEnabled() || k.hasJSTopic(streamId, bucket) || len(jsInsts) == 0 {
+		return protoInstList, nil
+	}
+
+	topic, jsTopic := getTopicForStreamId(streamId), getJSTopicForStreamId(streamId)
+
+	//AddInstances without instances returns the current timestamp
+	var currentTs *protobuf.TsVbuuid
+	for _, addr := range addrs {
+		res, err := sendAddInstancesRequest(newProjClient(addr), topic, nil)
+		if err != nil {
+			logging.Warnf("KVSender::openJSTopic %v %v Error %v from %v, instances go to %v",
+				streamId, bucket, err, addr, topic)
+			return protoInstList, nil
+		}
+		currentTs = updateCurrentTsFromResponse(bucket, currentTs, res)
+	}
+	if currentTs == nil || currentTs.Len() != k.config["numVbuckets"].Int() {
+		logging.Warnf("KVSender::openJSTopic %v %v Partial current ts, instances go to %v",
+			streamId, bucket, topic)
+		return protoInstList, nil
+	}
+
+	opened := make([]string, 0, len(addrs))
+	for _, addr := range addrs {
+		res, err := k.sendMutationTopicRequest(newProjClient(addr), jsTopic, currentTs, jsInsts)
+		if err == nil && updateRollbackTsFromResponse(bucket, nil, res) != nil {
+			err = errors.New("rollback")
+		}
+		if err != nil {
+			logging.Warnf("KVSender::openJSTopic %v %v Error %v from %v, instances go to %v",
+				streamId, bucket, err, addr, topic)
+			//other buckets of the stream keep the topic
+			others := k.hasJSTopic(streamId, "")
+			for _, o := range opened {
+				ret := k.dropJSTopicBuckets(newProjClient(o), streamId, []string{bucket}, !others)
+				if ret != nil {
+					logging.Warnf("KVSender::openJSTopic %v %v Ignoring %v from %v",
+						streamId, bucket, ret, o)
+				}
+			}
+			return protoInstList, nil
+		}
+		opened = append(opened, addr)
+	}
+
+	logging.Infof("KVSender::openJSTopic %v %v Opened %v", streamId, bucket, jsTopic)
+	k.setJSTopic(streamId, bucket, true)
+	return n1qlInsts, currentTs
+}
+
+//splitInstancesByTopic returns the instances to send on each topic of the
+//stream. When opening a stream, JavaScript instances get a topic of their
+//own only if enabled and the stream also carries N1QL instances, later
+//they get one from openJSTopic. Other requests follow the topics that
+//carry the bucket on the stream.
+func (k *kvSender) splitInstancesByTopic(streamId c.StreamId, bucket string,
+	protoInstList []*protobuf.Instance, open bool) map[string][]*protobuf.Instance {
+
+	var n1qlInsts, jsInsts []*protobuf.Instance
+	for _, inst := range protoInstList {
+		if isJSInstance(inst) {
+			jsInsts = append(jsInsts, inst)
+		} else {
+			n1qlInsts = append(n1qlInsts, inst)
+		}
+	}
+
+	var jsTopic bool
+	if open {
+		jsTopic = k.jsTopicEnabled() && len(jsInsts) > 0 && len(n1qlInsts) > 0
+	} else {
+		jsTopic = k.hasJSTopic(streamId, bucket)
+	}
+
+	topic := getTopicForStreamId(streamId)
+	if !jsTopic || len(jsInsts) == 0 {
+		return map[string][]*protobuf.Instance{topic: protoInstList}
+	}
+
+	topicInsts := map[string][]*protobuf.Instance{
+		getJSTopicForStreamId(streamId): jsInsts,
+	}
+	if len(n1qlInsts) > 0 {
+		topicInsts[topic] = n1qlInsts
+	}
+	return topicInsts
+}
+
+func isJSInstance(inst *protobuf.Instance) bool {
+	if indexInst := inst.GetIndexInstance(); indexInst != nil && indexInst.GetDefinition() != nil {
+		return indexInst.GetDefinition().GetExprType() == protobuf.ExprType_JAVASCRIPT
+	}
+	return false
+}
+
+//MsgIndexInstErrors is sent to the supervisor when projectors could not
+//build evaluators for some instances of a stream request, for example
+//because their function does not compile. The stream proceeds without them.
+type MsgIndexInstErrors struct {
+	streamId c.StreamId
+	bucket   string
+	errors   map[c.IndexInstId]string
+}
+
+func (m *MsgIndexInstErrors) GetMsgType() MsgType {
+	return KV_SENDER_INDEX_INST_ERRORS
+}
+
+func (m *MsgIndexInstErrors) GetStreamId() c.StreamId {
+	return m.streamId
+}
+
+func (m *MsgIndexInstErrors) GetBucket() string {
+	return m.bucket
+}
+
+func (m *MsgIndexInstErrors) GetErrors() map[c.IndexInstId]string {
+	return m.errors
+}
+
+//Apply records the cause on each instance and moves it to
+//INDEX_STATE_FUNC_ERROR, so that users see why the index is not building.
+func (m *MsgIndexInstErrors) Apply(indexInstMap c.IndexInstMap) {
+	for instId, cause := range m.errors {
+		if inst, ok := indexInstMap[instId]; ok {
+			inst.Error = cause
+			inst.State = c.INDEX_STATE_FUNC_ERROR
+			indexInstMap[instId] = inst
+		}
+	}
+}
+
+//MsgFunctionRefErrors is sent to the supervisor when the library function
+//of indexes was deleted by force. Like MsgIndexInstErrors, Apply moves
+//their instances to INDEX_STATE_FUNC_ERROR.
+type MsgFunctionRefErrors struct {
+	errors map[c.IndexDefnId]string
+}
+
+func (m *MsgFunctionRefErrors) GetMsgType() MsgType {
+	return KV_SENDER_FUNCTION_REF_ERRORS
+}
+
+func (m *MsgFunctionRefErrors) GetErrors() map[c.IndexDefnId]string {
+	return m.errors
+}
+
+//Apply records the cause on every instance of the definitions
+func (m *MsgFunctionRefErrors) Apply(indexInstMap c.IndexInstMap) {
+	for instId, inst := range indexInstMap {
+		if cause, ok := m.errors[inst.Defn.DefnId]; ok && inst.State != c.INDEX_STATE_DELETED {
+			inst.Error = cause
+			inst.State = c.INDEX_STATE_FUNC_ERROR
+			indexInstMap[instId] = inst
+		}
+	}
+}
+
+func (m *MsgFunctionRefErrors) String() string {
+	return fmt.Sprintf("\n\tMessage: MsgFunctionRefErrors\n\tErrors: %v", m.errors)
+}
+
+//unregisterFunctionRefs removes the references of dropped JavaScript
+//indexes to their library function. Instances removed from a stream for
+//any other reason, like a stream merge, are not in INDEX_STATE_DELETED.
+func unregisterFunctionRefs(indexInstList []c.IndexInst) {
+	for _, indexInst := range indexInstList {
+		if indexInst.State != c.INDEX_STATE_DELETED {
+			continue
+		}
+		if err := indexInst.Defn.UnregisterJSFunctionRef(); err != nil {
+			logging.Errorf("KVSender::unregisterFunctionRefs Error in removing reference "+
+				"of index %v to function %v: %v", indexInst.Defn.Name, indexInst.Defn.FuncName, err)
+		}
+	}
+}
+
+//observeFunctionRefs watches the references of indexes to library
+//functions, eventing sets JSFunctionRef.Error on those of a function it
+//deleted by force. Runs until kvSender shuts down.
+func (k *kvSender) observeFunctionRefs() {
+
+	callb := func(path string, value []byte, rev interface{}) error {
+		if value == nil {
+			return nil
+		}
+		var ref c.JSFunctionRef
+		if err := json.Unmarshal(value, &ref); err != nil {
+			logging.Errorf("KVSender::observeFunctionRefs Error in reading %v: %v", path, err)
+			return nil
+		}
+		if ref.Error != "" {
+			logging.Infof("KVSender::observeFunctionRefs Index %v.%v: %v", ref.Bucket, ref.Name, ref.Error)
+			k.supvRespch <- &MsgFunctionRefErrors{
+				errors: map[c.IndexDefnId]string{ref.DefnId: ref.Error}}
+		}
+		return nil
+	}
+
+	for {
+		err := metakv.RunObserveChildren(c.JSFunctionRefsMetakvPath, callb, k.jsRefsCancelCh)
+		select {
+		case <-k.jsRefsCancelCh:
+			return
+		default:
+		}
+		logging.Errorf("KVSender::observeFunctionRefs Error %v, retrying", err)
+		time.Sleep(time.Second)
+	}
+}
+
+func (m *MsgIndexInstErrors) String() string {
+	return fmt.Sprintf("\n\tMessage: MsgIndexInstErrors\n\tStream: %v"+
+		"\n\tBucket: %v\n\tErrors: %v", m.streamId, m.bucket, m.errors)
+}
+
+//collectInstanceErrors records per instance errors received from a
+//projector, and drops those instances from the topic requests so that
+//the retry proceeds without them. Returns false for any other error.
+//Lines in the errors are mapped, once, to the source of transpiled
+//library functions.
+func collectInstanceErrors(err error, instErrs map[c.IndexInstId]string,
+	topicInsts map[string][]*protobuf.Instance) bool {
+
+	ie, ok := protobuf.ParseInstanceErrors(err)
+	if !ok {
+		return false
+	}
+
+	for topic, insts := range topicInsts {
+		var kept []*protobuf.Instance
+		for _, inst := range insts {
+			cause, failed := ie[inst.GetUuid()]
+			if !failed {
+				kept = append(kept, inst)
+				continue
+			}
+			instId := c.IndexInstId(inst.GetUuid())
+			if _, ok := instErrs[instId]; !ok {
+				defn := inst.GetIndexInstance().GetDefinition()
+				instErrs[instId] = c.MapJSFunctionError(
+					defn.GetFuncName(), defn.GetFuncVersion(), cause)
+			}
+		}
+		if len(kept) == 0 {
+			delete(topicInsts, topic)
+		} else {
+			topicInsts[topic] = kept
+		}
+	}
+	for uuid, cause := range ie {
+		if _, ok := instErrs[c.IndexInstId(uuid)]; !ok {
+			instErrs[c.IndexInstId(uuid)] = cause
+		}
+	}
+	return true
+}
+
+//reportInstanceErrors tells the supervisor about instances left out of
+//the stream. Projectors that did build them before another projector
+//failed are asked to drop them from the topics of the request, best
+//effort.
+func (k *kvSender) reportInstanceErrors(streamId c.StreamId, bucket string,
+	addrs []string, topics []string, instErrs map[c.IndexInstId]string) {
+
+	if len(instErrs) == 0 {
+		return
+	}
+
+	logging.Errorf("KVSender::reportInstanceErrors %v %v Instances left out of stream %v",
+		streamId, bucket, instErrs)
+
+	var uuids []uint64
+	for instId := range instErrs {
+		uuids = append(uuids, uint64(instId))
+	}
+	for _, addr := range addrs {
+		ap := newProjClient(addr)
+		for _, topic := range topics {
+			if err := sendDelInstancesRequest(ap, topic, uuids); err != nil {
+				logging.Warnf("KVSender::reportInstanceErrors %v %v Ignoring %v from %v",
+					streamId, bucket, err, addr)
+			}
+		}
+	}
+
+	k.supvRespch <- &MsgIndexInstErrors{streamId: streamId,
+		bucket: bucket,
+		errors: instErrs}
+}
@@ -1,15 +1,28 @@
 package protobuf
 
 import "errors"
+import "fmt"
 import "sort"
 
 import c "github.com/couchbase/indexing/secondary/common"
+import "github.com/couchbase/indexing/secondary/logging"
 import "github.com/couchbase/indexing/secondary/dcp"
 import mc "github.com/couchbase/indexing/secondary/dcp/transport/client"
 import "github.com/golang/protobuf/proto"
 
 var ErrorInvalidVbmap = errors.New("protobuf.errorInvalidVbmap")
 
+// ErrorFeedVersion is returned when a request's feed version cannot carry
+// its index instances, or is newer than this projector understands.
+var ErrorFeedVersion = errors.New("protobuf.errorFeedVersion")
+
+// ErrorNotJSInstance is returned when an UpdateInstancesRequest names an
+// instance that is not a JavaScript index of the feed.
+var ErrorNotJSInstance = errors.New("protobuf.errorNotJSInstance")
+
+// maxFeedVersion is the latest feed version this projector understands.
+const maxFeedVersion = FeedVersion_javascript
+
 //************
 //VbmapRequest
 //************
@@ -241,7 +254,7 @@ func NewMutationTopicRequest(
 		EndpointType:  proto.String(endpointType),
 		ReqTimestamps: make([]*TsVbuuid, 0),
 		Instances:     instances,
-		Version:       FeedVersion_watson.Enum(),
+		Version:       feedVersion(instances),
 	}
 }
 
@@ -555,7 +568,7 @@ func NewAddBucketsRequest(
 		Topic:         proto.String(topic),
 		ReqTimestamps: make([]*TsVbuuid, 0),
 		Instances:     instances,
-		Version:       FeedVersion_watson.Enum(),
+		Version:       feedVersion(instances),
 	}
 }
 
@@ -648,7 +661,7 @@ func NewAddInstancesRequest(
 	return &AddInstancesRequest{
 		Topic:     proto.String(topic),
 		Instances: instances,
-		Version:   FeedVersion_watson.Enum(),
+		Version:   feedVersion(instances),
 	}
 }
 
@@ -780,26 +793,281 @@ func (req *ShutdownTopicRequest) Decode(data []byte) (err error) {
 	return proto.Unmarshal(data, req)
 }
 
+// ***********************
+// ValidateFunctionRequest
+// ***********************
+
+// NewValidateFunctionRequest creates a ValidateFunctionRequest to
+// compile library function `name` in projector's engine, checking that
+// it declares entryPoints.
+func NewValidateFunctionRequest(
+	name, code string, entryPoints []string) *ValidateFunctionRequest {
+
+	return &ValidateFunctionRequest{
+		Name:        proto.String(name),
+		Code:        proto.String(code),
+		EntryPoints: entryPoints,
+	}
+}
+
+// Name implement MessageMarshaller{} interface
+func (req *ValidateFunctionRequest) Name() string {
+	return "validateFunctionRequest"
+}
+
+// ContentType implement MessageMarshaller{} interface
+func (req *ValidateFunctionRequest) ContentType() string {
+	return "application/protobuf"
+}
+
+// Encode implement MessageMarshaller{} interface
+func (req *ValidateFunctionRequest) Encode() (data []byte, err error) {
+	return proto.Marshal(req)
+}
+
+// Decode implement MessageMarshaller{} interface
+func (req *ValidateFunctionRequest) Decode(data []byte) (err error) {
+	return proto.Unmarshal(data, req)
+}
+
+// Validate compiles the function in this projector's engine, without
+// disturbing functions already compiled for index instances.
+func (req *ValidateFunctionRequest) Validate() *ValidateFunctionResponse {
+	v := ValidateJS(req.GetCode())
+	res := &ValidateFunctionResponse{
+		EngineVersion: proto.String(JSEngineVersion()),
+		EntryPoints:   v.EntryPoints,
+		Errors:        make([]*FunctionError, 0),
+		FeedVersion:   maxFeedVersion.Enum(),
+	}
+	if v.Error != "" {
+		res.Errors = append(res.Errors, &FunctionError{
+			Message: proto.String(v.Error),
+			Line:    proto.Uint32(uint32(v.Line)),
+			Column:  proto.Uint32(uint32(v.Column)),
+		})
+		return res
+	}
+
+	declared := make(map[string]bool)
+	for _, entryPoint := range v.EntryPoints {
+		declared[entryPoint] = true
+	}
+	for _, entryPoint := range req.GetEntryPoints() {
+		if !declared[entryPoint] {
+			msg := fmt.Sprintf("entry point %v is not declared by function %v",
+				entryPoint, req.GetName())
+			res.Errors = append(res.Errors, &FunctionError{Message: proto.String(msg)})
+		}
+	}
+	return res
+}
+
+// ************************
+// ValidateFunctionResponse
+// ************************
+
+// Name implement MessageMarshaller{} interface
+func (res *ValidateFunctionResponse) Name() string {
+	return "validateFunctionResponse"
+}
+
+// ContentType implement MessageMarshaller{} interface
+func (res *ValidateFunctionResponse) ContentType() string {
+	return "application/protobuf"
+}
+
+// Encode implement MessageMarshaller{} interface
+func (res *ValidateFunctionResponse) Encode() (data []byte, err error) {
+	return proto.Marshal(res)
+}
+
+// Decode implement MessageMarshaller{} interface
+func (res *ValidateFunctionResponse) Decode(data []byte) (err error) {
+	return proto.Unmarshal(data, res)
+}
+
+// ToError returns the errors reported by projector as one error, nil if
+// the function is valid and projector's feed version can carry it.
+func (res *ValidateFunctionResponse) ToError() error {
+	if version := res.GetFeedVersion(); version < FeedVersion_javascript {
+		return fmt.Errorf("projector feed version %v cannot carry JavaScript indexes, %v needed",
+			version, FeedVersion_javascript)
+	}
+
+	errs := res.GetErrors()
+	if len(errs) == 0 {
+		return nil
+	}
+
+	msg := ""
+	for i, ferr := range errs {
+		if i > 0 {
+			msg += "; "
+		}
+		if line := ferr.GetLine(); line > 0 {
+			msg += fmt.Sprintf("line %v column %v: ", line, ferr.GetColumn())
+		}
+		msg += ferr.GetMessage()
+	}
+	return fmt.Errorf("%v (engine %v)", msg, res.GetEngineVersion())
+}
+
+// **********************
+// UpdateInstancesRequest
+// **********************
+
+// NewUpdateInstancesRequest creates an UpdateInstancesRequest
+// for topic to swap the evaluators of instances already on it.
+func NewUpdateInstancesRequest(
+	topic string, instances []*Instance) *UpdateInstancesRequest {
+	return &UpdateInstancesRequest{
+		Topic:     proto.String(topic),
+		Instances: instances,
+		Version:   feedVersion(instances),
+	}
+}
+
+// Name implement MessageMarshaller{} interface
+func (req *UpdateInstancesRequest) Name() string {
+	return "updateInstancesRequest"
+}
+
+// ContentType implement MessageMarshaller{} interface
+func (req *UpdateInstancesRequest) ContentType() string {
+	return "application/protobuf"
+}
+
+// Encode implement MessageMarshaller{} interface
+func (req *UpdateInstancesRequest) Encode() (data []byte, err error) {
+	return proto.Marshal(req)
+}
+
+// Decode implement MessageMarshaller{} interface
+func (req *UpdateInstancesRequest) Decode(data []byte) (err error) {
+	return proto.Unmarshal(data, req)
+}
+
+// GetEvaluators impelement Subscriber{} interface, evaluators are
+// built for the new definitions before any is swapped in, so that a
+// broken function leaves the topic untouched.
+func (req *UpdateInstancesRequest) GetEvaluators() (map[uint64]c.Evaluator, error) {
+	return getEvaluators(req.GetInstances(), req.GetVersion())
+}
+
+// GetRouters impelement Subscriber{} interface
+func (req *UpdateInstancesRequest) GetRouters() (map[uint64]c.Router, error) {
+	return getRouters(req.GetInstances())
+}
+
+// UpdateEvaluators swaps, in place, the evaluators of engines, the ones
+// of a bucket's feed, for the instances of the request. New evaluators
+// are built before any is swapped, so that a broken function leaves the
+// engines untouched. The response carries per vbucket the last seqno
+// evaluated with an old definition.
+func (req *UpdateInstancesRequest) UpdateEvaluators(
+	pooln, bucketn string,
+	engines map[uint64]c.Evaluator) (*TimestampResponse, error) {
+
+	nextEngines, err := req.GetEvaluators()
+	if err != nil {
+		return nil, err
+	}
+
+	olds := make(map[uint64]*IndexJSEvaluator)
+	for uuid, next := range nextEngines {
+		if next.Bucket() != bucketn {
+			continue
+		}
+		old, ok := engines[uuid].(*IndexJSEvaluator)
+		if _, isJS := next.(*IndexJSEvaluator); !ok || !isJS {
+			logging.Errorf("UpdateEvaluators: instance %v of topic %v is not a JavaScript index on bucket %v",
+				uuid, req.GetTopic(), bucketn)
+			closeEvaluators(nextEngines)
+			return nil, ErrorNotJSInstance
+		}
+		olds[uuid] = old
+	}
+
+	switchSeqnos := make(map[uint16]uint64)
+	for uuid, old := range olds {
+		// the highest seqno, any instance may have evaluated up to it
+		// with its old function
+		for vbno, seqno := range old.Update(nextEngines[uuid].(*IndexJSEvaluator)) {
+			if seqno > switchSeqnos[vbno] {
+				switchSeqnos[vbno] = seqno
+			}
+		}
+	}
+	for uuid, next := range nextEngines {
+		if ie, ok := next.(*IndexJSEvaluator); ok && olds[uuid] == nil {
+			ie.Close() // of another bucket
+		}
+	}
+
+	tsResp := &TimestampResponse{Topic: proto.String(req.GetTopic())}
+	return tsResp.AddCurrentTimestamp(pooln, bucketn, switchSeqnos), nil
+}
+
 //-- local functions
 
+// feedVersion returns the oldest feed version that can carry instances.
+// Only requests with JavaScript indexes need FeedVersion_javascript, so
+// that projectors predating it keep serving N1QL indexes.
+func feedVersion(instances []*Instance) *FeedVersion {
+	for _, instance := range instances {
+		if val := instance.GetIndexInstance(); val != nil && val.GetDefinition() != nil &&
+			val.GetDefinition().GetExprType() == ExprType_JAVASCRIPT {
+			return FeedVersion_javascript.Enum()
+		}
+	}
+	return FeedVersion_watson.Enum()
+}
+
 // TODO: add other types of engines
+// Evaluators are built for all instances or none. When the evaluator of
+// a JavaScript instance cannot be built the error is InstanceErrors, so
+// that indexer can retry the request without the instances it names.
 func getEvaluators(instances []*Instance,
 	version FeedVersion) (map[uint64]c.Evaluator, error) {
 
+	if version > maxFeedVersion {
+		logging.Errorf("getEvaluators: feed version %v not supported, max %v",
+			version, maxFeedVersion)
+		return nil, ErrorFeedVersion
+	}
+
 	var err error
 	var ie c.Evaluator
 	engines := make(map[uint64]c.Evaluator)
+	instErrs := make(InstanceErrors)
 	for _, instance := range instances {
 		uuid := instance.GetUuid()
 		if val := instance.GetIndexInstance(); val != nil {
-			switch val.GetDefinition().GetExprType() {
+			defn := val.GetDefinition()
+			switch defn.GetExprType() {
 			case ExprType_JAVASCRIPT:
-				ie, err = NewIndexJSEvaluator(val, version)
+				// library function is only shipped from this version on
+				if version < FeedVersion_javascript {
+					logging.Errorf("getEvaluators: JavaScript index %v needs feed version %v, got %v",
+						defn.GetName(), FeedVersion_javascript, version)
+					closeEvaluators(engines)
+					return nil, ErrorFeedVersion
+				}
+				if ie, err = NewIndexJSEvaluator(val, version); err != nil {
+					instErrs[uuid] = err.Error()
+					continue
+				}
 
 			case ExprType_N1QL:
 				ie, err = NewIndexEvaluator(val, version)
+
+			default:
+				err = fmt.Errorf("index %v has unknown expression type %v",
+					defn.GetName(), defn.GetExprType())
 			}
 			if err != nil {
+				closeEvaluators(engines)
 				return nil, err
 			}
 			engines[uuid] = ie
@@ -807,9 +1075,23 @@ func getEvaluators(instances []*Instance,
 			//TODO: should we panic ?
 		}
 	}
+	if len(instErrs) > 0 {
+		closeEvaluators(engines)
+		return nil, instErrs
+	}
 	return engines, nil
 }
 
+// closeEvaluators frees the functions of JavaScript evaluators that
+// will not be used.
+func closeEvaluators(engines map[uint64]c.Evaluator) {
+	for _, engine := range engines {
+		if ie, ok := engine.(*IndexJSEvaluator); ok {
+			ie.Close()
+		}
+	}
+}
+
 // TODO: add other types of engines
 func getRouters(instances []*Instance) (map[uint64]c.Router, error) {
 	routers := make(map[uint64]c.Router)
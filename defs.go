@@ -1,12 +1,14 @@
 package servicemanager
 
 import (
+	"encoding/json"
 	"sync"
 	"time"
 
 	"github.com/couchbase/cbauth/service"
 	"github.com/couchbase/eventing/common"
 	"github.com/couchbase/eventing/util"
+	c "github.com/couchbase/indexing/secondary/common"
 )
 
 const (
@@ -21,6 +23,9 @@ const (
 	stopRebalance            = "stopRebalance"
 	metakvTempViewAppsPath   = metakvEventingPath + "viewTemp/"
 	metakvViewAppsPath       = metakvEventingPath + "view/"
+	metakvViewVersionsPath   = metakvEventingPath + "viewVersions/" // immutable library function versions
+	metakvViewRefsPath       = metakvEventingPath + "viewRefs/"     // index definitions bound to library functions, written by indexer
+	metakvViewTestsPath      = metakvEventingPath + "viewTests/"    // test cases of library functions
 )
 
 const (
@@ -71,6 +76,8 @@ type ServiceMgr struct {
 	statusCodes   statusCodes
 	statusPayload []byte
 	errorCodes    map[int]errorPayload
+
+	libraryDeps sync.Map // Dependencies of library function versions, by name@version
 }
 
 type doneCallback func(err error, cancel <-chan struct{})
@@ -125,6 +132,162 @@ type jsonType struct {
 	Name        string `json:"appname"`
 	AppCode     string `json:"appcode"`
 	Description string `json:"description"`
+	Version     uint64 `json:"version"`
+	Hash        string `json:"hash"`
+	Rev         string `json:"rev,omitempty"` // metakv revision on reads, expected revision on updates
+	Author      string `json:"author,omitempty"`
+
+	// Source of typescript and esnext functions, AppCode is then generated
+	// from it on save along with SourceMap
+	Source    string `json:"source,omitempty"`
+	SourceMap string `json:"source_map,omitempty"`
+
+	// Versions of the modules the code requires, resolved on save
+	Dependencies map[string]uint64 `json:"dependencies,omitempty"`
+
+	// Metadata, a change of which is a new version like a change of code
+	Language       string   `json:"language,omitempty"`
+	Module         bool     `json:"module,omitempty"` // may be required by other functions
+	RuntimeVersion string   `json:"runtime_version,omitempty"`
+	EntryPoints    []string `json:"entry_points,omitempty"` // OnMap when none
+	Tags           []string `json:"tags,omitempty"`
+	Owner          string   `json:"owner,omitempty"`    // author of the first version unless set
+	Buckets        []string `json:"buckets,omitempty"`  // buckets the function is intended to index
+	Created        string   `json:"created,omitempty"`  // RFC 3339, when the first version was stored
+	Modified       string   `json:"modified,omitempty"` // RFC 3339, when this version was stored
+
+	// When versions stored before created and modified were, read into
+	// them and never written
+	Timestamp string `json:"timestamp,omitempty"`
+
+	Tests *libraryTestReport `json:"tests,omitempty"` // outcome of the test cases on save, never stored
+}
+
+// Response of a library function rejected on save, runtime_info of
+// ERR_HANDLER_COMPILATION
+type libraryDiagnostics struct {
+	CompileSuccess bool             `json:"compile_success"`
+	Diagnostics    []c.JSDiagnostic `json:"diagnostics"`
+}
+
+// runtime_info of a library function delete refused because indexes are
+// bound to it
+type libraryDependents struct {
+	Message string            `json:"message"`
+	Indexes []c.JSFunctionRef `json:"indexes"`
+}
+
+// Unified diff between two revisions of a library function, the version
+// of a draft is the version it was started from
+type libraryDiff struct {
+	Name        string `json:"appname"`
+	FromVersion uint64 `json:"from_version"`
+	ToVersion   uint64 `json:"to_version"`
+	Identical   bool   `json:"identical"`
+	Diff        string `json:"diff"`
+}
+
+// Library functions exported from a cluster, with every version. Payload
+// is the JSON of a libraryBundlePayload, carried as a string so that its
+// bytes survive any reformatting of the bundle, and the signature is the
+// HMAC-SHA256 of those bytes.
+type libraryBundle struct {
+	Format    int    `json:"format"`
+	Payload   string `json:"payload"`
+	Signature string `json:"signature"`
+}
+
+type libraryBundlePayload struct {
+	ExportedAt string                  `json:"exported_at"`
+	Functions  []libraryBundleFunction `json:"functions"`
+}
+
+type libraryBundleFunction struct {
+	Name     string     `json:"appname"`
+	Versions []jsonType `json:"versions"` // ascending, hash is the checksum of appcode
+}
+
+// Outcome of a library bundle import, also its plan on dry run
+type libraryImportResult struct {
+	DryRun    bool                  `json:"dry_run"`
+	Functions []libraryImportAction `json:"functions"`
+}
+
+type libraryImportAction struct {
+	Name        string             `json:"appname"`
+	ImportedAs  string             `json:"imported_as,omitempty"`
+	Action      string             `json:"action"`   // create, overwrite, rename, skip or unchanged
+	Versions    int                `json:"versions"` // versions written
+	Version     uint64             `json:"version,omitempty"`
+	Diagnostics []c.JSDiagnostic   `json:"diagnostics,omitempty"` // of version, which failed validation
+	Tests       *libraryTestReport `json:"tests,omitempty"`       // of version, whose test cases failed
+	Error       string             `json:"error,omitempty"`
+}
+
+// Request of the try-it endpoint, appcode is tried as given or, when
+// empty, read from the library function, its latest version unless
+// version is set
+type libraryTryRequest struct {
+	Name       string        `json:"appname"`
+	Version    uint64        `json:"version"`
+	AppCode    string        `json:"appcode"`
+	Language   string        `json:"language"` // of source, tried instead of appcode when set
+	Source     string        `json:"source"`
+	EntryPoint string        `json:"entry_point"` // first declared entry point when empty
+	Docs       []*c.JSTryDoc `json:"docs"`
+}
+
+type libraryTryResponse struct {
+	Name       string           `json:"appname"`
+	Version    uint64           `json:"version"` // 0 for code given in the request
+	EntryPoint string           `json:"entry_point"`
+	Results    []*c.JSTryResult `json:"results"`
+}
+
+// Test case of a library function, the entry point is run against doc
+// and meta and shall emit expected, the emit arguments as a JSON array,
+// or null when nothing shall be emitted. With exception set, the entry
+// point shall throw instead.
+type libraryTestCase struct {
+	Name      string          `json:"name"`
+	Meta      c.JSTryMeta     `json:"meta"`
+	Doc       json.RawMessage `json:"doc"`
+	Expected  json.RawMessage `json:"expected"`
+	Exception bool            `json:"exception,omitempty"`
+}
+
+type libraryTestResult struct {
+	Name      string          `json:"name"`
+	Passed    bool            `json:"passed"`
+	Expected  json.RawMessage `json:"expected"`
+	Actual    json.RawMessage `json:"actual"`
+	Exception string          `json:"exception,omitempty"`
+	Console   string          `json:"console,omitempty"`
+}
+
+// Outcome of running the test cases of a library function
+type libraryTestReport struct {
+	Name     string              `json:"appname"`
+	Version  uint64              `json:"version"`
+	Passed   bool                `json:"passed"`
+	Failures int                 `json:"failures"`
+	Error    string              `json:"error,omitempty"` // the function could not be run
+	Cases    []libraryTestResult `json:"cases"`
+}
+
+// Criteria of a library function listing, zero values match everything
+type libraryFilter struct {
+	Query          string   // substring of name or description, any case
+	Tags           []string // all of them
+	Bucket         string
+	EntryPoint     string
+	Owner          string
+	Language       string
+	ModifiedSince  time.Time
+	ModifiedBefore time.Time
+	Sort           string // name, modified or created, "-" prefix for descending
+	Offset         int
+	Limit          int // 0 for no limit
 }
 
 type depCfg struct {
@@ -160,8 +323,10 @@ type stats struct {
 }
 
 type config struct {
-	RAMQuota       int    `json:"ram_quota"`
-	MetadataBucket string `json:"metadata_bucket"`
+	RAMQuota            int    `json:"ram_quota"`
+	MetadataBucket      string `json:"metadata_bucket"`
+	LibrarySigningKey   string `json:"library_signing_key,omitempty"`   // shared by clusters library bundles move between
+	LibraryHistoryLimit int    `json:"library_history_limit,omitempty"` // versions kept per library function
 }
 
 type configResponse struct {
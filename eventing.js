@@ -293,11 +293,38 @@ angular.module('eventing', ['mnPluggableUiRegistry', 'ui.router', 'mnPoolDefault
                          self.isEventingRunning = isEventingRunning;
                          self.appList = ViewService.local.getAllApps();
                          self.disableEditButton = false;
+                         self.filter = {query: '', tag: ''};
+                         self.pageSize = 20;
+                         self.pageIndex = 0;
                          $rootScope.$broadcast('isEventingRunning', self.isEventingRunning);
                          self.isAppListEmpty = function() {
                             return Object.keys(self.appList).length === 0;
                          };
 
+                         // Functions matching the filter, by name, like the list endpoint filters them.
+                         self.filteredApps = function() {
+                         var query = self.filter.query.toLowerCase();
+                         return Object.keys(self.appList).sort()
+                         .map(function(appName) {
+                              return self.appList[appName];
+                              })
+                         .filter(function(app) {
+                                 return (!query || app.appname.toLowerCase().includes(query) ||
+                                         (app.description || '').toLowerCase().includes(query)) &&
+                                 (!self.filter.tag || (app.tags || []).indexOf(self.filter.tag) > -1);
+                                 });
+                         };
+
+                         self.pageCount = function() {
+                         return Math.max(1, Math.ceil(self.filteredApps().length / self.pageSize));
+                         };
+
+                         self.pagedApps = function() {
+                         self.pageIndex = Math.min(self.pageIndex, self.pageCount() - 1);
+                         var start = self.pageIndex * self.pageSize;
+                         return self.filteredApps().slice(start, start + self.pageSize);
+                         };
+
                          self.Indexes= function(appName){
                             return "[\"ABC\"]";//Gives all indexes which are created by this onmap function -> call the metakv to get the details
                          }
@@ -317,16 +344,20 @@ angular.module('eventing', ['mnPluggableUiRegistry', 'ui.router', 'mnPoolDefault
                                         scope: $scope
                                         }).result
                          .then(function(response) {
-                                //Check if its in some index files
-                               return ViewService.tempStore.deleteApp(appName);
+                               // Refused while indexes are bound to the function.
+                               return ViewService.primaryStore.deleteApp(appName);
                                })
                          .then(function(response) {
                                var responseCode = ViewService.status.getResponseCode(response);
                                if (responseCode) {
+                               var dependents = parseDependents(response.data);
+                               if (dependents) {
+                               showErrorAlert(`${appName} is used by indexes: ${dependents.join(', ')}`);
+                               return $q.reject(response.data);
+                               }
                                return $q.reject(ViewService.status.getErrorMsg(responseCode, response.data));
                                }
-                               //Check if its using some index files
-                               return ViewService.primaryStore.deleteApp(appName);
+                               return ViewService.tempStore.deleteApp(appName);
                                })
                          .then(function(response) {
                                // Delete the local copy of the app in the browser
@@ -338,6 +369,18 @@ angular.module('eventing', ['mnPluggableUiRegistry', 'ui.router', 'mnPoolDefault
                                 });
                          };
 
+                         // Returns the indexes that refused a delete, null for other errors.
+                         function parseDependents(data) {
+                         try {
+                         var info = JSON.parse(data.runtime_info);
+                         return info.indexes ? info.indexes.map(function(ref) {
+                                                                return `${ref.bucket}.${ref.name}`;
+                                                                }) : null;
+                         } catch (e) {
+                         return null;
+                         }
+                         };
+
                          // Utility function to show success alert.
                          function showSuccessAlert(message) {
                          self.successMessage = message;
@@ -522,12 +565,62 @@ angular.module('eventing', ['mnPluggableUiRegistry', 'ui.router', 'mnPoolDefault
                             createViewApp(scope);
                            };
 
+                           // Imports a bundle exported from another cluster, after confirming its dry run.
+                           function importBundle(bundle) {
+                           var conflict = prompt('Library functions that exist: skip, overwrite or rename?', 'skip');
+                           if (!conflict) {
+                           return;
+                           }
+                           ViewService.bundle.importBundle(bundle, conflict, true)
+                           .then(function(response) {
+                                 var plan = response.data.functions.map(function(fn) {
+                                                                       return `${fn.appname}: ${fn.action}` + (fn.imported_as !== fn.appname ? ` as ${fn.imported_as}` : '');
+                                                                       });
+                                 if (!confirm(`Import library bundle?\n${plan.join('\n')}`)) {
+                                 return $q.reject('Import cancelled');
+                                 }
+                                 return ViewService.bundle.importBundle(bundle, conflict, false);
+                                 })
+                           .then(function(response) {
+                                 $state.reload();
+                                 })
+                           .catch(function(errResponse) {
+                                  if (errResponse.data) {
+                                  alert(`Import failed: ${errResponse.data.runtime_info}`);
+                                  }
+                                  console.error(errResponse);
+                                  });
+                           }
+
+                           self.exportAll = function() {
+                           ViewService.bundle.exportAll()
+                           .then(function(response) {
+                                 var fileName = 'library.json';
+                                 var fileToSave = new Blob([JSON.stringify(response.data)], {
+                                                           type: 'application/json',
+                                                           name: fileName
+                                                           });
+                                 saveAs(fileToSave, fileName);
+                                 })
+                           .catch(function(errResponse) {
+                                  if (errResponse.data) {
+                                  alert(`Export failed: ${errResponse.data.runtime_info}`);
+                                  }
+                                  console.error(errResponse);
+                                  });
+                           };
+
                            self.importConfig = function() {
                            function handleFileSelect() {
                            var reader = new FileReader();
                            reader.onloadend = function() {
                            try {
                            var app = JSON.parse(reader.result);
+                           if (app.payload && app.signature) {
+                           importBundle(app);
+                           return;
+                           }
+
                            var scope = $scope.$new(true);
                            scope.appModel = new LibraryModel(app);
 
@@ -961,11 +1054,26 @@ angular.module('eventing', ['mnPluggableUiRegistry', 'ui.router', 'mnPoolDefault
 
                             debugScope.appName = app.appname;
 
-                            self.handler = app.appcode;
-                            self.pristineHandler = app.appcode;
+                            // typescript and esnext functions are edited as their source, appcode is generated on save.
+                            var transpiled = app.language === 'typescript' || app.language === 'esnext';
+                            function setCode(code) {
+                            if (transpiled) {
+                            app.source = code;
+                            } else {
+                            app.appcode = code;
+                            }
+                            }
+
+                            // A function switched to typescript or esnext starts from its code.
+                            self.handler = transpiled ? (app.source || app.appcode) : app.appcode;
+                            self.pristineHandler = self.handler;
                             self.debugToolTip = 'Displays a URL that connects the Chrome Dev-Tools with the application handler. Code must be deployed in order to debug.';
                             self.disableCancelButton = true;
                             self.disableSaveButton = true;
+                            // Only a saved draft can be published.
+                            self.disableDeployButton = !app.draft;
+                            self.diff = null;
+                            self.history = null;
 
                             $state.current.data.title = app.appname;
 
@@ -979,20 +1087,31 @@ angular.module('eventing', ['mnPluggableUiRegistry', 'ui.router', 'mnPoolDefault
                             // Need to disable the syntax checking.
                             // TODO : Figure out how to add N1QL grammar to ace editor.
                             editor.getSession().setUseWorker(false);
+                            if (app.language === 'typescript') {
+                            editor.getSession().setMode('ace/mode/typescript');
+                            }
 
-                            // If the compilation wasn't successful, show error info in UI.
-                            if (app.compilationInfo && !app.compilationInfo.compile_success) {
-                            var line = app.compilationInfo.line_number - 1,
-                            col = app.compilationInfo.column_number - 1;
+                            // Show diagnostics of the last rejected save inline.
+                            function showDiagnostics(diagnostics) {
+                            var annotations = [];
+                            for (var diag of diagnostics) {
+                            var line = diag.line - 1,
+                            col = diag.column - 1;
 
                             var markerId = editor.session.addMarker(new Range(line, col, line, col + 1), "functions-editor-info", "text");
                             markers.push(markerId);
-                            editor.getSession().setAnnotations([{
-                                                                row: line,
-                                                                column: col,
-                                                                text: app.compilationInfo.description,
-                                                                type: "error"
-                                                                }]);
+                            annotations.push({
+                                             row: line,
+                                             column: col,
+                                             text: `${diag.message} (${diag.rule})`,
+                                             type: diag.severity
+                                             });
+                            }
+                            editor.getSession().setAnnotations(annotations);
+                            }
+
+                            if (app.diagnostics) {
+                            showDiagnostics(app.diagnostics);
                             }
 
                             editor.on('focus', function(event) {
@@ -1019,7 +1138,9 @@ angular.module('eventing', ['mnPluggableUiRegistry', 'ui.router', 'mnPoolDefault
                             }
 
                             markers = [];
-                            }
+                            session.clearAnnotations();
+                            },
+                            showDiagnostics: showDiagnostics
                             };
                             };
 
@@ -1028,25 +1149,267 @@ angular.module('eventing', ['mnPluggableUiRegistry', 'ui.router', 'mnPoolDefault
                             self.disableDeployButton = true;
                             };
 
+                            // Reports a save made against a revision someone else replaced.
+                            function showConflictAlert(action, errResponse) {
+                            if (errResponse.status !== 409 && errResponse.status !== 428) {
+                            return false;
+                            }
+                            showErrorAlert(`${action} failed: ${app.appname} was changed by someone else, reload it and try again`);
+                            return true;
+                            }
+
                             self.ViewsaveEdit = function() {
-                            app.appcode = self.handler;
-                            ViewService.tempStore.saveApp(app)
+                            setCode(self.handler);
+                            // The revision checked on save is the draft's. Only the first draft is
+                            // saved without one, the server refuses it once a draft exists.
+                            var draft = app.clone();
+                            if (!app.draft) {
+                            delete draft.rev;
+                            }
+                            ViewService.tempStore.saveApp(draft)
                             .then(function(response) {
-                                  showSuccessAlert('Code saved successfully!');
+                                  var responseCode = ViewService.status.getResponseCode(response);
+                                  if (responseCode) {
+                                  return $q.reject(response);
+                                  }
+
+                                  showSuccessAlert('Draft saved successfully!');
                                   self.disableCancelButton = self.disableSaveButton = true;
                                   self.disableDeployButton = false;
+                                  app.draft = true;
+                                  app.rev = response.data.rev;
+                                  self.diff = null;
+                                  self.testReport = response.data.tests;
+                                  if (self.testReport && !self.testReport.passed) {
+                                  showWarningAlert(`${self.testReport.failures} test(s) failed, the draft cannot be published yet`);
+                                  }
+                                  console.log(response.data);
+                                  })
+                            .catch(function(errResponse) {
+                                   if (!showConflictAlert('Save', errResponse) && errResponse.data) {
+                                   showErrorAlert(`Save failed: ${errResponse.data.runtime_info}`);
+                                   }
+                                   console.error(errResponse);
+                                   });
+                            };
+
+                            // Promotes the saved draft to a new published version.
+                            self.publishEdit = function() {
+                            ViewService.tempStore.publishApp(app.appname, app.rev)
+                            .then(function(response) {
+                                  var responseCode = ViewService.status.getResponseCode(response);
+                                  if (responseCode) {
+                                  return $q.reject(response);
+                                  }
+
+                                  showSuccessAlert(`${app.appname} published successfully!`);
+                                  app.rev = response.data.rev;
+                                  app.version = response.data.version;
+                                  self.disableDeployButton = true;
+                                  self.pristineHandler = self.handler;
+                                  app.appcode = response.data.appcode;
+                                  app.draft = false;
+                                  self.diff = null;
 
                                   self.aceEditor.clearMarkersAndAnnotations();
-                                  delete app.compilationInfo;
+                                  delete app.diagnostics;
                                   console.log(response.data);
                                   })
                             .catch(function(errResponse) {
+                                   self.aceEditor.clearMarkersAndAnnotations();
+                                   if (showConflictAlert('Publish', errResponse)) {
+                                   console.error(errResponse);
+                                   return;
+                                   }
+                                   if (errResponse.data && (errResponse.data.name === 'ERR_HANDLER_COMPILATION')) {
+                                   var info = JSON.parse(errResponse.data.runtime_info);
+                                   if (info.cases) {
+                                   // A test report, the code compiled but its tests failed.
+                                   self.testReport = info;
+                                   showErrorAlert(`Publish failed: ${info.failures} test(s) failed`);
+                                   } else {
+                                   app.diagnostics = info.diagnostics;
+                                   self.aceEditor.showDiagnostics(info.diagnostics);
+                                   showErrorAlert(`Publish failed: ${info.diagnostics.length} problem(s) found, see the editor`);
+                                   }
+                                   } else if (errResponse.data) {
+                                   showErrorAlert(`Publish failed: ${errResponse.data.runtime_info}`);
+                                   }
+                                   console.error(errResponse);
+                                   });
+                            };
+
+                            // Shows the changes of the draft against the published code.
+                            self.showDiff = function() {
+                            ViewService.tempStore.getDiff(app.appname)
+                            .then(function(response) {
+                                  var responseCode = ViewService.status.getResponseCode(response);
+                                  if (responseCode) {
+                                  return $q.reject(response);
+                                  }
+
+                                  self.diff = response.data.identical ? 'No changes against the published code.' : response.data.diff;
+                                  })
+                            .catch(function(errResponse) {
+                                   if (errResponse.data) {
+                                   showErrorAlert(`Diff failed: ${errResponse.data.runtime_info}`);
+                                   }
+                                   console.error(errResponse);
+                                   });
+                            };
+
+                            // Lists the retained versions, newest first.
+                            self.showHistory = function() {
+                            ViewService.history.getVersions(app.appname)
+                            .then(function(response) {
+                                  var responseCode = ViewService.status.getResponseCode(response);
+                                  if (responseCode) {
+                                  return $q.reject(response);
+                                  }
+
+                                  self.history = response.data.reverse();
+                                  })
+                            .catch(function(errResponse) {
+                                   if (errResponse.data) {
+                                   showErrorAlert(`History failed: ${errResponse.data.runtime_info}`);
+                                   }
+                                   console.error(errResponse);
+                                   });
+                            };
+
+                            // Shows the changes from an earlier version to the latest one.
+                            self.diffVersion = function(version) {
+                            ViewService.history.getDiff(app.appname, version, 'latest')
+                            .then(function(response) {
+                                  var responseCode = ViewService.status.getResponseCode(response);
+                                  if (responseCode) {
+                                  return $q.reject(response);
+                                  }
+
+                                  self.diff = response.data.identical ? `No changes since version ${version}.` : response.data.diff;
+                                  })
+                            .catch(function(errResponse) {
+                                   if (errResponse.data) {
+                                   showErrorAlert(`Diff failed: ${errResponse.data.runtime_info}`);
+                                   }
+                                   console.error(errResponse);
+                                   });
+                            };
+
+                            // Publishes the code of an earlier version as a new version.
+                            self.rollback = function(version) {
+                            if (!confirm(`Publish the code of version ${version} of ${app.appname} as a new version?`)) {
+                            return;
+                            }
+
+                            // The revision of a draft is not the one of the latest version, which
+                            // is read first.
+                            var latestRev = app.draft ?
+                            ViewService.primaryStore.getApp(app.appname).then(function(response) {
+                                                                               return response.data.rev;
+                                                                               }) : $q.resolve(app.rev);
+                            latestRev.then(function(rev) {
+                                           return ViewService.history.rollback(app.appname, version, rev);
+                                           })
+                            .then(function(response) {
+                                  var responseCode = ViewService.status.getResponseCode(response);
+                                  if (responseCode) {
+                                  return $q.reject(response);
+                                  }
+
+                                  showSuccessAlert(`${app.appname} rolled back to version ${version} as version ${response.data.version}`);
+                                  if (app.draft) {
+                                  showWarningAlert('The draft was started from an older version, save it again before publishing.');
+                                  } else {
+                                  app.appcode = response.data.appcode;
+                                  app.source = response.data.source;
+                                  self.handler = self.pristineHandler = transpiled ? app.source : app.appcode;
+                                  app.rev = response.data.rev;
+                                  }
+                                  app.version = response.data.version;
+                                  self.diff = null;
+                                  self.showHistory();
+                                  })
+                            .catch(function(errResponse) {
+                                   if (!showConflictAlert('Rollback', errResponse) && errResponse.data) {
+                                   showErrorAlert(`Rollback failed: ${errResponse.data.runtime_info}`);
+                                   }
+                                   console.error(errResponse);
+                                   });
+                            };
+
+                            // Loads the stored test cases for editing as JSON.
+                            self.showTests = function() {
+                            ViewService.tests.getTests(app.appname)
+                            .then(function(response) {
+                                  var responseCode = ViewService.status.getResponseCode(response);
+                                  if (responseCode) {
+                                  return $q.reject(response);
+                                  }
+
+                                  self.tests = JSON.stringify(response.data, null, 2);
+                                  })
+                            .catch(function(errResponse) {
+                                   if (errResponse.data) {
+                                   showErrorAlert(`Loading tests failed: ${errResponse.data.runtime_info}`);
+                                   }
+                                   console.error(errResponse);
+                                   });
+                            };
+
+                            self.saveTests = function() {
+                            var tests;
+                            try {
+                            tests = JSON.parse(self.tests);
+                            } catch (e) {
+                            showErrorAlert(`Tests are not valid JSON: ${e.message}`);
+                            return;
+                            }
+
+                            ViewService.tests.saveTests(app.appname, tests)
+                            .then(function(response) {
+                                  var responseCode = ViewService.status.getResponseCode(response);
+                                  if (responseCode) {
+                                  return $q.reject(response);
+                                  }
+
+                                  showSuccessAlert('Tests saved successfully!');
+                                  })
+                            .catch(function(errResponse) {
+                                   if (errResponse.data) {
+                                   showErrorAlert(`Saving tests failed: ${errResponse.data.runtime_info}`);
+                                   }
+                                   console.error(errResponse);
+                                   });
+                            };
+
+                            // Runs the stored tests against the draft, or the latest version.
+                            self.runTests = function() {
+                            ViewService.tests.runTests(app.appname, app.draft)
+                            .then(function(response) {
+                                  var responseCode = ViewService.status.getResponseCode(response);
+                                  if (responseCode) {
+                                  return $q.reject(response);
+                                  }
+
+                                  self.testReport = response.data;
+                                  if (self.testReport.passed) {
+                                  showSuccessAlert(`${self.testReport.cases.length} test(s) passed`);
+                                  } else {
+                                  showErrorAlert(`${self.testReport.failures} test(s) failed`);
+                                  }
+                                  })
+                            .catch(function(errResponse) {
+                                   if (errResponse.data) {
+                                   showErrorAlert(`Running tests failed: ${errResponse.data.runtime_info}`);
+                                   }
                                    console.error(errResponse);
                                    });
                             };
 
                             self.cancelEdit = function() {
-                            self.handler = app.appcode = self.pristineHandler;
+                            self.handler = self.pristineHandler;
+                            setCode(self.pristineHandler);
                             self.disableDeployButton = self.disableCancelButton = self.disableSaveButton = true;
 
                             $state.go('app.admin.eventing.view');
@@ -1187,7 +1550,7 @@ angular.module('eventing', ['mnPluggableUiRegistry', 'ui.router', 'mnPoolDefault
                                console.log('Obtained error codes');
 
                                errHandler = new ErrorHandler(response.data);
-                               return $http.get('/_p/event/getTempView/');
+                               return $http.get('/_p/event/getView/');
                                })
                          .then(function getAppTempStore(response) {
                                console.log("HI");
@@ -1207,6 +1570,15 @@ angular.module('eventing', ['mnPluggableUiRegistry', 'ui.router', 'mnPoolDefault
                                for (var app of response.data) {
                                 appManager.pushApp(new Library(app));
                                }
+                               return $http.get('/_p/event/getTempView/');
+                               })
+                         .then(function(response) {
+                               // A draft replaces the published code in the editor until it is published.
+                               for (var app of response.data) {
+                               var draft = new Library(app);
+                               draft.draft = true;
+                               appManager.pushApp(draft);
+                               }
                                })
                          .catch(function(errResponse) {
                                 console.error('Failed to get the data:', errResponse);
@@ -1247,7 +1619,7 @@ angular.module('eventing', ['mnPluggableUiRegistry', 'ui.router', 'mnPoolDefault
                          saveApp: function(app) {
                           console.log(app.appname);
                          return $http({
-                                      url: '/_p/event/saveViewAppStore/?name=' + app.appname,
+                                      url: '/_p/event/saveTempView/?name=' + app.appname,
                                       method: 'POST',
                                       mnHttp: {
                                       isNotForm: true
@@ -1260,9 +1632,22 @@ angular.module('eventing', ['mnPluggableUiRegistry', 'ui.router', 'mnPoolDefault
                          },
                          deleteApp: function(appName) {
                          return $http.get('/_p/event/deleteViewTempStore/?name=' + appName);
+                         },
+                         publishApp: function(appName, rev) {
+                         return $http({
+                                      url: '/_p/event/publishView/?name=' + appName,
+                                      method: 'POST',
+                                      headers: {'If-Match': '"' + rev + '"'}
+                                      });
+                         },
+                         getDiff: function(appName) {
+                         return $http.get('/_p/event/getViewDiff/?name=' + appName);
                          }
                          },
                          primaryStore: {
+                         getApp: function(appName) {
+                         return $http.get('/_p/event/api/v1/Library/' + appName);
+                         },
                          deployApp: function(app) {
                          return $http({
                                       url: '/_p/event/saveViewAppStore/?name=' + app.appname,
@@ -1280,6 +1665,60 @@ angular.module('eventing', ['mnPluggableUiRegistry', 'ui.router', 'mnPoolDefault
                          return $http.get('/_p/event/deleteViewLibrary/?name=' + appName);
                          },
 
+                         },
+                         history: {
+                         getVersions: function(appName) {
+                         return $http.get('/_p/event/getViewVersions/?name=' + appName);
+                         },
+                         getDiff: function(appName, from, to) {
+                         return $http.get('/_p/event/getViewDiff/?name=' + appName + '&from=' + from + '&to=' + to);
+                         },
+                         rollback: function(appName, version, rev) {
+                         return $http({
+                                      url: '/_p/event/rollbackView/?name=' + appName + '&version=' + version,
+                                      method: 'POST',
+                                      headers: {'If-Match': '"' + rev + '"'}
+                                      });
+                         }
+                         },
+                         tests: {
+                         getTests: function(appName) {
+                         return $http.get('/_p/event/viewTests/?name=' + appName);
+                         },
+                         saveTests: function(appName, tests) {
+                         return $http({
+                                      url: '/_p/event/viewTests/?name=' + appName,
+                                      method: 'POST',
+                                      mnHttp: {
+                                      isNotForm: true
+                                      },
+                                      headers: {
+                                      'Content-Type': 'application/json'
+                                      },
+                                      data: tests
+                                      });
+                         },
+                         runTests: function(appName, draft) {
+                         return $http.post('/_p/event/runViewTests/?name=' + appName + (draft ? '&draft=true' : ''));
+                         }
+                         },
+                         bundle: {
+                         exportAll: function() {
+                         return $http.get('/_p/event/api/v1/export/Library');
+                         },
+                         importBundle: function(bundle, conflict, dryRun) {
+                         return $http({
+                                      url: '/_p/event/api/v1/import/Library?conflict=' + conflict + '&dryrun=' + dryRun,
+                                      method: 'POST',
+                                      mnHttp: {
+                                      isNotForm: true
+                                      },
+                                      headers: {
+                                      'Content-Type': 'application/json'
+                                      },
+                                      data: bundle
+                                      });
+                         }
                          },
                          status: {
                          isErrorCodesLoaded: function() {
@@ -1400,6 +1400,11 @@ func (m *ServiceMgr) getSourceMap(appName string) string {
 		return m.superSup.GetSourceMap(appName)
 	}
 
+	// Library functions are never deployed, their latest version has a
+	// source map when it was transpiled
+	if app, found, err := m.getLibraryLatest(appName); err == nil && found {
+		return app.SourceMap
+	}
 	return ""
 }
 
@@ -1546,6 +1551,11 @@ func (m *ServiceMgr) configHandler(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
+		// The signing key is never sent back, only whether one is set
+		if c.LibrarySigningKey != "" {
+			c.LibrarySigningKey = librarySigningKeyRedacted
+		}
+
 		response, err := json.Marshal(c)
 		if err != nil {
 			info.Code = m.statusCodes.errMarshalResp.Code
@@ -1576,6 +1586,16 @@ func (m *ServiceMgr) configHandler(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
+		// A config read with GET and posted back keeps its signing key
+		if c.LibrarySigningKey == librarySigningKeyRedacted {
+			current, cInfo := m.getConfig()
+			if cInfo.Code != m.statusCodes.ok.Code {
+				m.sendErrorInfo(w, cInfo)
+				return
+			}
+			c.LibrarySigningKey = current.LibrarySigningKey
+		}
+
 		if info = m.saveConfig(c); info.Code != m.statusCodes.ok.Code {
 			m.sendErrorInfo(w, info)
 		}
@@ -1743,226 +1763,1009 @@ func (m *ServiceMgr) functionsHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 func (m *ServiceMgr) libraryHandler(w http.ResponseWriter, r *http.Request) {
-}
-
-func (m *ServiceMgr) statsHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	if !m.validateAuth(w, r, EventingPermissionManage) {
 		fmt.Fprintln(w, "{\"error\":\"Request not authorized\"}")
 		return
 	}
 
-	if r.Method == "GET" {
-		// Check whether type=full is present in query
-		fullStats := false
-		if typeParam := r.URL.Query().Get("type"); typeParam != "" {
-			fullStats = typeParam == "full"
-		}
-
-		statsList := make([]stats, 0)
-		for _, app := range m.getTempStoreAll() {
-			if m.checkIfDeployed(app.Name) {
-				stats := stats{}
-				stats.EventProcessingStats = m.superSup.GetEventProcessingStats(app.Name)
-				stats.EventsRemaining = backlogStat{DcpBacklog: m.superSup.GetDcpEventsRemainingToProcess(app.Name)}
-				stats.ExecutionStats = m.superSup.GetExecutionStats(app.Name)
-				stats.FailureStats = m.superSup.GetFailureStats(app.Name)
-				stats.FunctionName = app.Name
-				stats.LcbExceptionStats = m.superSup.GetLcbExceptionsStats(app.Name)
-				stats.WorkerPids = m.superSup.GetEventingConsumerPids(app.Name)
-				stats.PlannerStats = m.superSup.PlannerStats(app.Name)
-				stats.VbDistributionStats = m.superSup.VbDistributionStats(app.Name)
+	library := regexp.MustCompile("^/api/v1/Library/?$")
+	libraryName := regexp.MustCompile("^/api/v1/Library/(.+[^/])/?$") // Match is agnostic of trailing '/'
+	libraryNamePublish := regexp.MustCompile("^/api/v1/Library/(.+[^/])/publish/?$")
+	libraryNameDiff := regexp.MustCompile("^/api/v1/Library/(.+[^/])/diff/?$")
+	libraryNameHistory := regexp.MustCompile("^/api/v1/Library/(.+[^/])/history/?$")
+	libraryNameRollback := regexp.MustCompile("^/api/v1/Library/(.+[^/])/rollback/?$")
+	libraryNameTestsRun := regexp.MustCompile("^/api/v1/Library/(.+[^/])/tests/run/?$")
+	libraryNameTests := regexp.MustCompile("^/api/v1/Library/(.+[^/])/tests/?$")
 
-				if fullStats {
-					stats.LatencyStats = m.superSup.GetLatencyStats(app.Name)
+	// draft=true selects the draft of a function instead of its published code
+	draft := r.URL.Query().Get("draft") == "true"
 
-					plasmaStats, err := m.superSup.GetPlasmaStats(app.Name)
-					if err == nil {
-						stats.PlasmaStats = plasmaStats
-					}
+	if match := libraryNamePublish.FindStringSubmatch(r.URL.Path); len(match) != 0 {
+		appName := match[1]
+		switch r.Method {
+		case "POST":
+			audit.Log(auditevent.CreateFunction, r, appName)
 
-					stats.SeqsProcessed = m.superSup.GetSeqsProcessed(app.Name)
-					stats.VbDcpEventsRemaining = m.superSup.VbDcpEventsRemainingToProcess(app.Name)
-					debugStats, err := m.superSup.TimerDebugStats(app.Name)
-					if err == nil {
-						stats.DocTimerDebugStats = debugStats
-					}
-				}
+			// If-Match is the revision of the draft to publish
+			rev, ok := m.requireIfMatch(w, r, appName)
+			if !ok {
+				return
+			}
 
-				statsList = append(statsList, stats)
+			saved, conflict, info := m.publishLibraryDraft(appName, rev, libraryAuthor(r))
+			if conflict {
+				m.sendLibraryConflict(w, saved, info)
+				return
+			} else if info.Code != m.statusCodes.ok.Code {
+				m.sendLibraryError(w, m.libraryHTTPStatus(info), info)
+				return
 			}
-		}
+			m.sendLibraryFunction(w, http.StatusOK, saved)
 
-		response, err := json.Marshal(statsList)
-		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			fmt.Fprintf(w, "{\"error\":\"Failed to marshal response for stats, err: %v\"}", err)
-			return
+		default:
+			m.sendLibraryMethodNotAllowed(w, r)
 		}
 
-		fmt.Fprintf(w, "%s", string(response))
-	} else {
-		w.WriteHeader(http.StatusMethodNotAllowed)
-	}
-
-	return
-}
-
-// Clears up all Eventing related artifacts from metakv, typically will be used for rebalance tests
-func (m *ServiceMgr) cleanupEventing(w http.ResponseWriter, r *http.Request) {
-	if !m.validateAuth(w, r, EventingPermissionManage) {
-		fmt.Fprintln(w, "{\"error\":\"Request not authorized\"}")
-		return
-	}
-
-	audit.Log(auditevent.CleanupEventing, r, nil)
+	} else if match := libraryNameHistory.FindStringSubmatch(r.URL.Path); len(match) != 0 {
+		appName := match[1]
+		switch r.Method {
+		case "GET":
+			audit.Log(auditevent.FetchFunctions, r, appName)
+
+			// Retained versions in ascending order, diff them with /diff?from=&to=
+			versions := m.getLibraryVersions(appName)
+			if len(versions) == 0 {
+				m.sendLibraryError(w, http.StatusNotFound, &runtimeInfo{
+					Code: m.statusCodes.errAppNotFoundTs.Code,
+					Info: fmt.Sprintf("Library function: %v not found", appName),
+				})
+				return
+			}
 
-	util.Retry(util.NewFixedBackoff(time.Second), cleanupEventingMetaKvPath, metakvAppsPath)
-	util.Retry(util.NewFixedBackoff(time.Second), cleanupEventingMetaKvPath, metakvTempAppsPath)
-	util.Retry(util.NewFixedBackoff(time.Second), cleanupEventingMetaKvPath, metakvAppSettingsPath)
-}
+			response, err := json.Marshal(versions)
+			if err != nil {
+				m.sendLibraryError(w, http.StatusInternalServerError, &runtimeInfo{
+					Code: m.statusCodes.errMarshalResp.Code,
+					Info: fmt.Sprintf("Failed to marshal history of library function: %v, err: %v", appName, err),
+				})
+				return
+			}
 
-//FOR VIEWS
+			w.Header().Add(headerKey, strconv.Itoa(m.statusCodes.ok.Code))
+			fmt.Fprintf(w, "%s", string(response))
 
-func (m *ServiceMgr) deleteLibraryHandler(w http.ResponseWriter, r *http.Request) {
-	if !m.validateAuth(w, r, EventingPermissionManage) {
-		return
-	}
+		default:
+			m.sendLibraryMethodNotAllowed(w, r)
+		}
 
-	values := r.URL.Query()
-	appName := values["name"][0]
+	} else if match := libraryNameTestsRun.FindStringSubmatch(r.URL.Path); len(match) != 0 {
+		appName := match[1]
+		switch r.Method {
+		case "POST":
+			audit.Log(auditevent.FetchFunctions, r, appName)
 
-	logging.Infof("Deleting application %v from primary store", appName)
-	audit.Log(auditevent.DeleteFunction, r, appName)
-	m.deleteViewStore(appName)
-}
-func (m *ServiceMgr) deleteViewStore(appName string) {
-	info := &runtimeInfo{}
-	var err error
-	//See whether index is based on this or not if yes then Dont delete give some message
+			// Runs against the latest version, ?draft=true or ?version=N
+			ref := r.URL.Query().Get("version")
+			if draft {
+				ref = "draft"
+			}
 
-	appList := util.ListChildren(metakvViewAppsPath)
-	for _, app := range appList {
-		if app == appName {
+			report, info := m.runLibraryTestsOn(appName, ref)
+			if info.Code != m.statusCodes.ok.Code {
+				m.sendLibraryError(w, m.libraryHTTPStatus(info), info)
+				return
+			}
 
-			appsPath := metakvViewAppsPath + appName
-			err = util.MetaKvDelete(appsPath, nil)
+			response, err := json.Marshal(report)
 			if err != nil {
-				info.Code = m.statusCodes.errDelAppPs.Code
-				info.Info = fmt.Sprintf("Failed to delete app definition for app: %v, err: %v", appName, err)
+				m.sendLibraryError(w, http.StatusInternalServerError, &runtimeInfo{
+					Code: m.statusCodes.errMarshalResp.Code,
+					Info: fmt.Sprintf("Failed to marshal test report of library function: %v, err: %v", appName, err),
+				})
 				return
 			}
 
-			info.Code = m.statusCodes.ok.Code
-			info.Info = fmt.Sprintf("Deleting app: %v in the background", appName)
-			return
+			w.Header().Add(headerKey, strconv.Itoa(m.statusCodes.ok.Code))
+			fmt.Fprintf(w, "%s", string(response))
+
+		default:
+			m.sendLibraryMethodNotAllowed(w, r)
 		}
-	}
 
-	// TODO : This must be changed to app not deployed / found
-	info.Code = m.statusCodes.ok.Code
-	info.Info = fmt.Sprintf("Deleting app: %v in the background", appName)
-	return
-}
+	} else if match := libraryNameTests.FindStringSubmatch(r.URL.Path); len(match) != 0 {
+		appName := match[1]
+		switch r.Method {
+		case "GET":
+			audit.Log(auditevent.FetchFunctions, r, appName)
 
-//No need of this module
-func (m *ServiceMgr) deleteTempLibraryHandler(w http.ResponseWriter, r *http.Request) {
-	if !m.validateAuth(w, r, EventingPermissionManage) {
-		return
-	}
+			tests, err := m.getLibraryTests(appName)
+			if err != nil {
+				m.sendLibraryError(w, http.StatusInternalServerError, &runtimeInfo{
+					Code: m.statusCodes.errGetConfig.Code,
+					Info: fmt.Sprintf("Failed to read test cases of library function: %v, err: %v", appName, err),
+				})
+				return
+			}
 
-	values := r.URL.Query()
-	appName := values["name"][0]
+			response, err := json.Marshal(tests)
+			if err != nil {
+				m.sendLibraryError(w, http.StatusInternalServerError, &runtimeInfo{
+					Code: m.statusCodes.errMarshalResp.Code,
+					Info: fmt.Sprintf("Failed to marshal test cases of library function: %v, err: %v", appName, err),
+				})
+				return
+			}
 
-	audit.Log(auditevent.DeleteDrafts, r, appName)
+			w.Header().Add(headerKey, strconv.Itoa(m.statusCodes.ok.Code))
+			fmt.Fprintf(w, "%s", string(response))
 
-	m.deleteViewTempStore(appName)
-}
+		case "PUT":
+			audit.Log(auditevent.SaveDraft, r, appName)
 
-func (m *ServiceMgr) deleteViewTempStore(appName string) (info *runtimeInfo) {
-	info = &runtimeInfo{}
-	logging.Infof("Deleting drafts from temporary store: %v", appName)
+			tests, info := m.unmarshalLibraryTests(r)
+			if info.Code != m.statusCodes.ok.Code {
+				m.sendLibraryError(w, http.StatusBadRequest, info)
+				return
+			}
 
-	checkIfDeployed := false
-	for _, app := range util.ListChildren(metakvTempViewAppsPath) {
-		if app == appName {
-			checkIfDeployed = true
-		}
-	}
+			if info = m.saveLibraryTests(appName, tests); info.Code != m.statusCodes.ok.Code {
+				m.sendLibraryError(w, m.libraryHTTPStatus(info), info)
+				return
+			}
+			m.sendErrorInfo(w, info)
 
-	if !checkIfDeployed {
-		info.Code = m.statusCodes.errAppNotDeployed.Code
-		info.Info = fmt.Sprintf("App: %v not deployed", appName)
-		return
-	}
+		default:
+			m.sendLibraryMethodNotAllowed(w, r)
+		}
 
-	tempAppList := util.ListChildren(metakvTempViewAppsPath)
+	} else if match := libraryNameRollback.FindStringSubmatch(r.URL.Path); len(match) != 0 {
+		appName := match[1]
+		switch r.Method {
+		case "POST":
+			audit.Log(auditevent.CreateFunction, r, appName)
 
-	for _, tempAppName := range tempAppList {
-		if appName == tempAppName {
-			path := metakvTempViewAppsPath + tempAppName
-			err := util.MetaKvDelete(path, nil)
+			// ?version= to roll back to, If-Match is the revision of the
+			// latest version
+			version, err := strconv.ParseUint(r.URL.Query().Get("version"), 10, 64)
 			if err != nil {
-				info.Code = m.statusCodes.errDelAppTs.Code
-				info.Info = fmt.Sprintf("Failed to delete from temp store for %v, err: %v", appName, err)
+				m.sendLibraryError(w, http.StatusBadRequest, &runtimeInfo{
+					Code: m.statusCodes.errReadReq.Code,
+					Info: fmt.Sprintf("Invalid version: %v for library function: %v", r.URL.Query().Get("version"), appName),
+				})
 				return
 			}
 
-			info.Code = m.statusCodes.ok.Code
-			info.Info = fmt.Sprintf("Deleting app: %v in the background", appName)
-			return
+			rev, ok := m.requireIfMatch(w, r, appName)
+			if !ok {
+				return
+			}
+
+			saved, conflict, info := m.rollbackLibraryFunction(appName, version, rev, libraryAuthor(r))
+			if conflict {
+				m.sendLibraryConflict(w, saved, info)
+				return
+			} else if info.Code != m.statusCodes.ok.Code {
+				m.sendLibraryError(w, m.libraryHTTPStatus(info), info)
+				return
+			}
+			m.sendLibraryFunction(w, http.StatusOK, saved)
+
+		default:
+			m.sendLibraryMethodNotAllowed(w, r)
 		}
-	}
 
-	info.Code = m.statusCodes.ok.Code
-	info.Info = fmt.Sprintf("Deleting app: %v in the background", appName)
-	return
-}
+	} else if match := libraryNameDiff.FindStringSubmatch(r.URL.Path); len(match) != 0 {
+		appName := match[1]
+		switch r.Method {
+		case "GET":
+			audit.Log(auditevent.FetchDrafts, r, appName)
 
-func (m *ServiceMgr) getViewStoreHandler(w http.ResponseWriter, r *http.Request) {
-	if !m.validateAuth(w, r, EventingPermissionManage) {
-		return
-	}
+			// ?from=&to= take "draft" or a version, default to latest against draft
+			params := r.URL.Query()
+			to := params.Get("to")
+			if to == "" {
+				to = "draft"
+			}
 
-	logging.Infof("Getting all applications in primary store")
-	audit.Log(auditevent.FetchFunctions, r, nil)
+			diff, info := m.diffLibraryRevisions(appName, params.Get("from"), to)
+			if info.Code != m.statusCodes.ok.Code {
+				m.sendLibraryError(w, m.libraryHTTPStatus(info), info)
+				return
+			}
 
-	appList := util.ListChildren(metakvViewAppsPath)
-	respData := make([]jsonType, len(appList))
+			response, err := json.Marshal(diff)
+			if err != nil {
+				m.sendLibraryError(w, http.StatusInternalServerError, &runtimeInfo{
+					Code: m.statusCodes.errMarshalResp.Code,
+					Info: fmt.Sprintf("Failed to marshal diff of library function: %v, err: %v", appName, err),
+				})
+				return
+			}
 
-	for index, appName := range appList {
+			w.Header().Add(headerKey, strconv.Itoa(m.statusCodes.ok.Code))
+			fmt.Fprintf(w, "%s", string(response))
 
-		path := metakvViewAppsPath + appName
-		data, err := util.MetakvGet(path)
-		var details jsonType
-		err = json.Unmarshal(data, &details)
-		if err == nil {
-			respData[index] = details
+		default:
+			m.sendLibraryMethodNotAllowed(w, r)
 		}
-	}
 
-	data, err := json.Marshal(respData)
-	if err != nil {
-		w.Header().Add(headerKey, strconv.Itoa(m.statusCodes.errMarshalResp.Code))
-		fmt.Fprintf(w, "Failed to marshal response for get_application, err: %v", err)
-		return
-	}
-
-	w.Header().Add(headerKey, strconv.Itoa(m.statusCodes.ok.Code))
-	fmt.Fprintf(w, "%s\n", data)
-}
+	} else if match := libraryName.FindStringSubmatch(r.URL.Path); len(match) != 0 {
+		appName := match[1]
+		switch r.Method {
+		case "GET":
+			var app jsonType
+			var found bool
+			var err error
+			if draft {
+				audit.Log(auditevent.FetchDrafts, r, appName)
+				app, found, err = m.getLibraryDraft(appName)
+			} else if v := r.URL.Query().Get("version"); v != "" {
+				audit.Log(auditevent.FetchFunctions, r, appName)
+				version, pErr := strconv.ParseUint(v, 10, 64)
+				if pErr != nil {
+					m.sendLibraryError(w, http.StatusBadRequest, &runtimeInfo{
+						Code: m.statusCodes.errReadReq.Code,
+						Info: fmt.Sprintf("Invalid version: %v for library function: %v", v, appName),
+					})
+					return
+				}
+				app, found, err = m.getLibraryVersion(appName, version)
+			} else {
+				audit.Log(auditevent.FetchFunctions, r, appName)
+				app, found, err = m.getLibraryLatest(appName)
+			}
 
-func (m *ServiceMgr) getTempViewHandler(w http.ResponseWriter, r *http.Request) {
-	logging.Infof("GET TEMPHANDLER")
-	if !m.validateAuth(w, r, EventingPermissionManage) {
-		w.WriteHeader(http.StatusUnauthorized)
-		fmt.Fprintln(w, "{\"error\":\"Request not authorized\"}")
-		return
-	}
-	logging.Infof("TEMP HANDLER")
-	respData := m.getTempLibraryStoreAll()
+			if err != nil {
+				m.sendLibraryError(w, http.StatusInternalServerError, &runtimeInfo{
+					Code: m.statusCodes.errGetConfig.Code,
+					Info: fmt.Sprintf("Failed to read library function: %v, err: %v", appName, err),
+				})
+				return
+			} else if !found {
+				m.sendLibraryError(w, http.StatusNotFound, &runtimeInfo{
+					Code: m.statusCodes.errAppNotFoundTs.Code,
+					Info: fmt.Sprintf("Library function: %v not found", appName),
+				})
+				return
+			}
+			m.sendLibraryFunction(w, http.StatusOK, app)
 
-	data, err := json.Marshal(respData)
+		case "POST", "PUT":
+			// POST creates the function, PUT updates an existing one
+			if draft {
+				audit.Log(auditevent.SaveDraft, r, appName)
+			} else {
+				audit.Log(auditevent.CreateFunction, r, appName)
+			}
+
+			app, info := m.unmarshalLibraryFunction(r, appName)
+			if info.Code != m.statusCodes.ok.Code {
+				m.sendLibraryError(w, http.StatusBadRequest, info)
+				return
+			}
+
+			// Updates must name the revision they were made against
+			if r.Method == "POST" {
+				app.Rev = ""
+			} else if app.Rev == "" {
+				m.sendLibraryRevRequired(w, appName)
+				return
+			}
+
+			var found bool
+			var err error
+			if draft {
+				_, found, err = m.getLibraryDraft(appName)
+			} else {
+				_, found, err = m.getLibraryLatest(appName)
+			}
+			if err != nil {
+				m.sendLibraryError(w, http.StatusInternalServerError, &runtimeInfo{
+					Code: m.statusCodes.errGetConfig.Code,
+					Info: fmt.Sprintf("Failed to read library function: %v, err: %v", appName, err),
+				})
+				return
+			} else if found && r.Method == "POST" {
+				m.sendLibraryError(w, http.StatusConflict, &runtimeInfo{
+					Code: m.statusCodes.errSaveAppPs.Code,
+					Info: fmt.Sprintf("Library function: %v already exists, use PUT to update it", appName),
+				})
+				return
+			} else if !found && r.Method == "PUT" {
+				m.sendLibraryError(w, http.StatusNotFound, &runtimeInfo{
+					Code: m.statusCodes.errAppNotFoundTs.Code,
+					Info: fmt.Sprintf("Library function: %v not found, use POST to create it", appName),
+				})
+				return
+			}
+
+			var saved jsonType
+			var conflict bool
+			if draft {
+				saved, conflict, info = m.saveLibraryDraft(app)
+			} else {
+				saved, conflict, info = m.saveLibraryVersion(app)
+			}
+			if conflict {
+				m.sendLibraryConflict(w, saved, info)
+				return
+			} else if info.Code != m.statusCodes.ok.Code {
+				m.sendLibraryError(w, m.libraryHTTPStatus(info), info)
+				return
+			}
+
+			status := http.StatusOK
+			if r.Method == "POST" {
+				status = http.StatusCreated
+			}
+			m.sendLibraryFunction(w, status, saved)
+
+		case "DELETE":
+			var info *runtimeInfo
+			if draft {
+				audit.Log(auditevent.DeleteDrafts, r, appName)
+				info = m.deleteLibraryDraft(appName)
+			} else {
+				audit.Log(auditevent.DeleteFunction, r, appName)
+				info = m.deleteViewStore(appName, r.URL.Query().Get("force") == "true")
+			}
+			if info.Code != m.statusCodes.ok.Code {
+				m.sendLibraryError(w, m.libraryHTTPStatus(info), info)
+				return
+			}
+			m.sendErrorInfo(w, info)
+
+		default:
+			m.sendLibraryMethodNotAllowed(w, r)
+		}
+
+	} else if match := library.FindStringSubmatch(r.URL.Path); len(match) != 0 {
+		switch r.Method {
+		case "GET":
+			var apps []jsonType
+			if draft {
+				audit.Log(auditevent.FetchDrafts, r, nil)
+				apps = m.getLibraryDrafts()
+			} else {
+				audit.Log(auditevent.FetchFunctions, r, nil)
+				apps = m.getLibraryLatestAll()
+			}
+
+			filter, info := m.parseLibraryFilter(r.URL.Query())
+			if info.Code != m.statusCodes.ok.Code {
+				m.sendLibraryError(w, http.StatusBadRequest, info)
+				return
+			}
+			apps, total := filterLibraryFunctions(apps, filter)
+
+			response, err := json.Marshal(apps)
+			if err != nil {
+				m.sendLibraryError(w, http.StatusInternalServerError, &runtimeInfo{
+					Code: m.statusCodes.errMarshalResp.Code,
+					Info: fmt.Sprintf("Failed to marshal library functions, err: %v", err),
+				})
+				return
+			}
+
+			w.Header().Set("X-Total-Count", strconv.Itoa(total))
+			w.Header().Add(headerKey, strconv.Itoa(m.statusCodes.ok.Code))
+			fmt.Fprintf(w, "%s", string(response))
+
+		default:
+			m.sendLibraryMethodNotAllowed(w, r)
+		}
+
+	} else {
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+// Unmarshals the test cases of a library function from the request body
+func (m *ServiceMgr) unmarshalLibraryTests(r *http.Request) (tests []libraryTestCase, info *runtimeInfo) {
+	info = &runtimeInfo{}
+
+	data, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		info.Code = m.statusCodes.errReadReq.Code
+		info.Info = fmt.Sprintf("Failed to read request body, err: %v", err)
+		return
+	}
+
+	if err = json.Unmarshal(data, &tests); err != nil {
+		info.Code = m.statusCodes.errUnmarshalPld.Code
+		info.Info = fmt.Sprintf("Failed to unmarshal test cases, err: %v", err)
+		return
+	}
+
+	info.Code = m.statusCodes.ok.Code
+	return
+}
+
+// Parses the criteria of a library function listing:
+// ?q=&tag=&bucket=&entry_point=&owner=&language=&modified_since=
+// &modified_before=&sort=&offset=&limit=, tag may be repeated and
+// timestamps are RFC 3339
+func (m *ServiceMgr) parseLibraryFilter(params url.Values) (filter libraryFilter, info *runtimeInfo) {
+	info = &runtimeInfo{Code: m.statusCodes.errReadReq.Code}
+
+	filter = libraryFilter{
+		Query:      params.Get("q"),
+		Tags:       params["tag"],
+		Bucket:     params.Get("bucket"),
+		EntryPoint: params.Get("entry_point"),
+		Owner:      params.Get("owner"),
+		Language:   params.Get("language"),
+		Sort:       params.Get("sort"),
+	}
+
+	switch strings.TrimPrefix(filter.Sort, "-") {
+	case "", "name", "modified", "created":
+	default:
+		info.Info = fmt.Sprintf("Invalid sort: %v, expected name, modified or created", filter.Sort)
+		return
+	}
+
+	for key, t := range map[string]*time.Time{"modified_since": &filter.ModifiedSince, "modified_before": &filter.ModifiedBefore} {
+		if v := params.Get(key); v != "" {
+			var err error
+			if *t, err = time.Parse(time.RFC3339, v); err != nil {
+				info.Info = fmt.Sprintf("Invalid %v: %v, err: %v", key, v, err)
+				return
+			}
+		}
+	}
+
+	for key, n := range map[string]*int{"offset": &filter.Offset, "limit": &filter.Limit} {
+		if v := params.Get(key); v != "" {
+			var err error
+			if *n, err = strconv.Atoi(v); err != nil || *n < 0 {
+				info.Info = fmt.Sprintf("Invalid %v: %v", key, v)
+				return
+			}
+		}
+	}
+
+	info.Code = m.statusCodes.ok.Code
+	return
+}
+
+// Unmarshals a library function from the request body, name may be
+// omitted from the body but must otherwise match the URL
+func (m *ServiceMgr) unmarshalLibraryFunction(r *http.Request, appName string) (app jsonType, info *runtimeInfo) {
+	info = &runtimeInfo{}
+
+	data, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		info.Code = m.statusCodes.errReadReq.Code
+		info.Info = fmt.Sprintf("Failed to read request body, err: %v", err)
+		logging.Errorf(info.Info)
+		return
+	}
+
+	err = json.Unmarshal(data, &app)
+	if err != nil {
+		info.Code = m.statusCodes.errUnmarshalPld.Code
+		info.Info = fmt.Sprintf("Failed to unmarshal payload err: %v", err)
+		logging.Errorf(info.Info)
+		return
+	}
+
+	if app.Name == "" {
+		app.Name = appName
+	} else if app.Name != appName {
+		info.Code = m.statusCodes.errAppNameMismatch.Code
+		info.Info = fmt.Sprintf("Library function name in the URL (%s) and body (%s) must be same", appName, app.Name)
+		return
+	}
+
+	// version, hash and timestamps are assigned by the store
+	app.Version, app.Hash, app.Created, app.Modified = 0, "", "", ""
+	app.Author = libraryAuthor(r)
+	if rev := ifMatchRev(r); rev != "" {
+		app.Rev = rev
+	}
+
+	info.Code = m.statusCodes.ok.Code
+	info.Info = "OK"
+	return
+}
+
+// Returns the user a request authenticated as, author of the library
+// function versions it saves
+func libraryAuthor(r *http.Request) string {
+	creds, err := cbauth.AuthWebCreds(r)
+	if err != nil || creds == nil {
+		return ""
+	}
+	return creds.Name()
+}
+
+// Returns the revision named by the If-Match header, ETags are the quoted
+// revision
+func ifMatchRev(r *http.Request) string {
+	return strings.Trim(strings.TrimPrefix(r.Header.Get("If-Match"), "W/"), "\"")
+}
+
+// Returns the If-Match revision of a request, or answers 428 when the
+// request does not name one
+func (m *ServiceMgr) requireIfMatch(w http.ResponseWriter, r *http.Request, appName string) (rev string, ok bool) {
+	if rev = ifMatchRev(r); rev == "" {
+		m.sendLibraryRevRequired(w, appName)
+		return
+	}
+	return rev, true
+}
+
+func (m *ServiceMgr) sendLibraryRevRequired(w http.ResponseWriter, appName string) {
+	m.sendLibraryError(w, http.StatusPreconditionRequired, &runtimeInfo{
+		Code: m.statusCodes.errReadReq.Code,
+		Info: fmt.Sprintf("Update of library function: %v requires If-Match with the revision it was made against", appName),
+	})
+}
+
+func (m *ServiceMgr) sendLibraryMethodNotAllowed(w http.ResponseWriter, r *http.Request) {
+	m.sendLibraryError(w, http.StatusMethodNotAllowed, &runtimeInfo{
+		Code: m.statusCodes.errReadReq.Code,
+		Info: fmt.Sprintf("Method: %v not allowed on %v", r.Method, r.URL.Path),
+	})
+}
+
+// Maps a runtimeInfo returned by the library store to an HTTP status
+func (m *ServiceMgr) libraryHTTPStatus(info *runtimeInfo) int {
+	switch info.Code {
+	case m.statusCodes.ok.Code:
+		return http.StatusOK
+	case m.statusCodes.errAppNotFoundTs.Code:
+		return http.StatusNotFound
+	case m.statusCodes.errAppNotUndeployed.Code:
+		return http.StatusConflict // indexes are bound to the function
+	case m.statusCodes.errReadReq.Code, m.statusCodes.errUnmarshalPld.Code, m.statusCodes.errAppNameMismatch.Code,
+		m.statusCodes.errHandlerCompile.Code:
+		return http.StatusBadRequest
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// Sends info as JSON error body, status header must be set before the
+// HTTP status is written
+func (m *ServiceMgr) sendLibraryError(w http.ResponseWriter, status int, info *runtimeInfo) {
+	w.Header().Add(headerKey, strconv.Itoa(info.Code))
+	w.WriteHeader(status)
+	m.sendErrorInfo(w, info)
+}
+
+// Sends 409 for an update made against a stale revision, with the current
+// revision as ETag
+func (m *ServiceMgr) sendLibraryConflict(w http.ResponseWriter, current jsonType, info *runtimeInfo) {
+	if current.Rev != "" {
+		w.Header().Set("ETag", "\""+current.Rev+"\"")
+	}
+	m.sendLibraryError(w, http.StatusConflict, info)
+}
+
+func (m *ServiceMgr) sendLibraryFunction(w http.ResponseWriter, status int, app jsonType) {
+	response, err := json.Marshal(app)
+	if err != nil {
+		m.sendLibraryError(w, http.StatusInternalServerError, &runtimeInfo{
+			Code: m.statusCodes.errMarshalResp.Code,
+			Info: fmt.Sprintf("Failed to marshal library function: %v, err: %v", app.Name, err),
+		})
+		return
+	}
+
+	if app.Rev != "" {
+		w.Header().Set("ETag", "\""+app.Rev+"\"")
+	}
+	w.Header().Add(headerKey, strconv.Itoa(m.statusCodes.ok.Code))
+	w.WriteHeader(status)
+	fmt.Fprintf(w, "%s", string(response))
+}
+
+// Exports library functions as a signed bundle, ?name= selects functions,
+// it may be repeated, all are exported otherwise
+func (m *ServiceMgr) exportLibraryHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if !m.validateAuth(w, r, EventingPermissionManage) {
+		fmt.Fprintln(w, "{\"error\":\"Request not authorized\"}")
+		return
+	}
+
+	if r.Method != "GET" {
+		m.sendLibraryMethodNotAllowed(w, r)
+		return
+	}
+
+	appNames := r.URL.Query()["name"]
+	audit.Log(auditevent.FetchFunctions, r, appNames)
+
+	bundle, info := m.exportLibraryBundle(appNames)
+	if info.Code != m.statusCodes.ok.Code {
+		m.sendLibraryError(w, m.libraryHTTPStatus(info), info)
+		return
+	}
+
+	response, err := json.Marshal(&bundle)
+	if err != nil {
+		m.sendLibraryError(w, http.StatusInternalServerError, &runtimeInfo{
+			Code: m.statusCodes.errMarshalResp.Code,
+			Info: fmt.Sprintf("Failed to marshal library bundle, err: %v", err),
+		})
+		return
+	}
+
+	w.Header().Set("Content-Disposition", "attachment; filename=library.json")
+	w.Header().Add(headerKey, strconv.Itoa(m.statusCodes.ok.Code))
+	fmt.Fprintf(w, "%s", string(response))
+}
+
+// Imports a library bundle, ?dryrun=true returns the plan without writing
+// anything, ?conflict= is skip, the default, overwrite or rename
+func (m *ServiceMgr) importLibraryHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if !m.validateAuth(w, r, EventingPermissionManage) {
+		fmt.Fprintln(w, "{\"error\":\"Request not authorized\"}")
+		return
+	}
+
+	if r.Method != "POST" {
+		m.sendLibraryMethodNotAllowed(w, r)
+		return
+	}
+
+	params := r.URL.Query()
+	dryRun := params.Get("dryrun") == "true"
+	audit.Log(auditevent.CreateFunction, r, nil)
+
+	data, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		m.sendLibraryError(w, http.StatusBadRequest, &runtimeInfo{
+			Code: m.statusCodes.errReadReq.Code,
+			Info: fmt.Sprintf("Failed to read request body, err: %v", err),
+		})
+		return
+	}
+
+	var bundle libraryBundle
+	if err = json.Unmarshal(data, &bundle); err != nil {
+		m.sendLibraryError(w, http.StatusBadRequest, &runtimeInfo{
+			Code: m.statusCodes.errUnmarshalPld.Code,
+			Info: fmt.Sprintf("Failed to unmarshal library bundle, err: %v", err),
+		})
+		return
+	}
+
+	result, info := m.importLibraryBundle(bundle, params.Get("conflict"), dryRun)
+	if info.Code != m.statusCodes.ok.Code {
+		m.sendLibraryError(w, m.libraryHTTPStatus(info), info)
+		return
+	}
+
+	response, err := json.Marshal(&result)
+	if err != nil {
+		m.sendLibraryError(w, http.StatusInternalServerError, &runtimeInfo{
+			Code: m.statusCodes.errMarshalResp.Code,
+			Info: fmt.Sprintf("Failed to marshal library import result, err: %v", err),
+		})
+		return
+	}
+
+	w.Header().Add(headerKey, strconv.Itoa(m.statusCodes.ok.Code))
+	fmt.Fprintf(w, "%s", string(response))
+}
+
+// Evaluates a library function against documents given in the request,
+// see libraryTryRequest
+func (m *ServiceMgr) tryLibraryHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if !m.validateAuth(w, r, EventingPermissionManage) {
+		fmt.Fprintln(w, "{\"error\":\"Request not authorized\"}")
+		return
+	}
+
+	if r.Method != "POST" {
+		m.sendLibraryMethodNotAllowed(w, r)
+		return
+	}
+
+	data, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		m.sendLibraryError(w, http.StatusBadRequest, &runtimeInfo{
+			Code: m.statusCodes.errReadReq.Code,
+			Info: fmt.Sprintf("Failed to read request body, err: %v", err),
+		})
+		return
+	}
+
+	var req libraryTryRequest
+	if err = json.Unmarshal(data, &req); err != nil {
+		m.sendLibraryError(w, http.StatusBadRequest, &runtimeInfo{
+			Code: m.statusCodes.errUnmarshalPld.Code,
+			Info: fmt.Sprintf("Failed to unmarshal try request, err: %v", err),
+		})
+		return
+	}
+
+	audit.Log(auditevent.FetchFunctions, r, req.Name)
+
+	resp, info := m.tryLibraryFunction(req)
+	if info.Code != m.statusCodes.ok.Code {
+		m.sendLibraryError(w, m.libraryHTTPStatus(info), info)
+		return
+	}
+
+	response, err := json.Marshal(&resp)
+	if err != nil {
+		m.sendLibraryError(w, http.StatusInternalServerError, &runtimeInfo{
+			Code: m.statusCodes.errMarshalResp.Code,
+			Info: fmt.Sprintf("Failed to marshal try response, err: %v", err),
+		})
+		return
+	}
+
+	w.Header().Add(headerKey, strconv.Itoa(m.statusCodes.ok.Code))
+	fmt.Fprintf(w, "%s", string(response))
+}
+
+func (m *ServiceMgr) statsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if !m.validateAuth(w, r, EventingPermissionManage) {
+		fmt.Fprintln(w, "{\"error\":\"Request not authorized\"}")
+		return
+	}
+
+	if r.Method == "GET" {
+		// Check whether type=full is present in query
+		fullStats := false
+		if typeParam := r.URL.Query().Get("type"); typeParam != "" {
+			fullStats = typeParam == "full"
+		}
+
+		statsList := make([]stats, 0)
+		for _, app := range m.getTempStoreAll() {
+			if m.checkIfDeployed(app.Name) {
+				stats := stats{}
+				stats.EventProcessingStats = m.superSup.GetEventProcessingStats(app.Name)
+				stats.EventsRemaining = backlogStat{DcpBacklog: m.superSup.GetDcpEventsRemainingToProcess(app.Name)}
+				stats.ExecutionStats = m.superSup.GetExecutionStats(app.Name)
+				stats.FailureStats = m.superSup.GetFailureStats(app.Name)
+				stats.FunctionName = app.Name
+				stats.LcbExceptionStats = m.superSup.GetLcbExceptionsStats(app.Name)
+				stats.WorkerPids = m.superSup.GetEventingConsumerPids(app.Name)
+				stats.PlannerStats = m.superSup.PlannerStats(app.Name)
+				stats.VbDistributionStats = m.superSup.VbDistributionStats(app.Name)
+
+				if fullStats {
+					stats.LatencyStats = m.superSup.GetLatencyStats(app.Name)
+
+					plasmaStats, err := m.superSup.GetPlasmaStats(app.Name)
+					if err == nil {
+						stats.PlasmaStats = plasmaStats
+					}
+
+					stats.SeqsProcessed = m.superSup.GetSeqsProcessed(app.Name)
+					stats.VbDcpEventsRemaining = m.superSup.VbDcpEventsRemainingToProcess(app.Name)
+					debugStats, err := m.superSup.TimerDebugStats(app.Name)
+					if err == nil {
+						stats.DocTimerDebugStats = debugStats
+					}
+				}
+
+				statsList = append(statsList, stats)
+			}
+		}
+
+		response, err := json.Marshal(statsList)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprintf(w, "{\"error\":\"Failed to marshal response for stats, err: %v\"}", err)
+			return
+		}
+
+		fmt.Fprintf(w, "%s", string(response))
+	} else {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+
+	return
+}
+
+// Clears up all Eventing related artifacts from metakv, typically will be used for rebalance tests
+func (m *ServiceMgr) cleanupEventing(w http.ResponseWriter, r *http.Request) {
+	if !m.validateAuth(w, r, EventingPermissionManage) {
+		fmt.Fprintln(w, "{\"error\":\"Request not authorized\"}")
+		return
+	}
+
+	audit.Log(auditevent.CleanupEventing, r, nil)
+
+	util.Retry(util.NewFixedBackoff(time.Second), cleanupEventingMetaKvPath, metakvAppsPath)
+	util.Retry(util.NewFixedBackoff(time.Second), cleanupEventingMetaKvPath, metakvTempAppsPath)
+	util.Retry(util.NewFixedBackoff(time.Second), cleanupEventingMetaKvPath, metakvAppSettingsPath)
+}
+
+//FOR VIEWS
+
+func (m *ServiceMgr) deleteLibraryHandler(w http.ResponseWriter, r *http.Request) {
+	if !m.validateAuth(w, r, EventingPermissionManage) {
+		return
+	}
+
+	values := r.URL.Query()
+	appName := values["name"][0]
+	force := values.Get("force") == "true"
+
+	logging.Infof("Deleting application %v from primary store, force: %v", appName, force)
+	audit.Log(auditevent.DeleteFunction, r, appName)
+	m.sendErrorInfo(w, m.deleteViewStore(appName, force))
+}
+
+// Deletes the published library function, refused while indexes are bound
+// to it unless force is set, which marks those indexes as errored. Its
+// draft and test cases go with it, its versions stay for the indexes
+// pinned to them and a function created again under the same name is
+// numbered after them.
+func (m *ServiceMgr) deleteViewStore(appName string, force bool) (info *runtimeInfo) {
+	info = &runtimeInfo{}
+	var err error
+
+	if refs := m.getLibraryRefs(appName); len(refs) > 0 {
+		if !force {
+			deps := libraryDependents{
+				Message: fmt.Sprintf("Library function: %v is used by %d index(es), drop them first or delete with force=true", appName, len(refs)),
+				Indexes: refs,
+			}
+
+			data, mErr := json.Marshal(&deps)
+			if mErr != nil {
+				info.Code = m.statusCodes.errMarshalResp.Code
+				info.Info = fmt.Sprintf("Failed to marshal dependent indexes of library function: %v, err: %v", appName, mErr)
+				return
+			}
+
+			info.Code = m.statusCodes.errAppNotUndeployed.Code
+			info.Info = string(data)
+			return
+		}
+
+		if err = m.markLibraryRefsErrored(appName, refs); err != nil {
+			info.Code = m.statusCodes.errDelAppPs.Code
+			info.Info = fmt.Sprintf("Failed to mark indexes of library function: %v errored, err: %v", appName, err)
+			return
+		}
+	}
+
+	appList := util.ListChildren(metakvViewAppsPath)
+	for _, app := range appList {
+		if app == appName {
+
+			appsPath := metakvViewAppsPath + appName
+			err = util.MetaKvDelete(appsPath, nil)
+			if err != nil {
+				info.Code = m.statusCodes.errDelAppPs.Code
+				info.Info = fmt.Sprintf("Failed to delete app definition for app: %v, err: %v", appName, err)
+				return
+			}
+
+			for _, path := range []string{metakvTempViewAppsPath + appName, metakvViewTestsPath + appName} {
+				if data, _ := util.MetakvGet(path); data == nil {
+					continue
+				}
+				if err = util.MetaKvDelete(path, nil); err != nil {
+					logging.Errorf("Failed to delete %v of deleted library function: %v, err: %v", path, appName, err)
+				}
+			}
+
+			info.Code = m.statusCodes.ok.Code
+			info.Info = fmt.Sprintf("Deleting app: %v in the background", appName)
+			return
+		}
+	}
+
+	info.Code = m.statusCodes.errAppNotFoundTs.Code
+	info.Info = fmt.Sprintf("Library function: %v not found", appName)
+	return
+}
+
+//No need of this module
+func (m *ServiceMgr) deleteTempLibraryHandler(w http.ResponseWriter, r *http.Request) {
+	if !m.validateAuth(w, r, EventingPermissionManage) {
+		return
+	}
+
+	values := r.URL.Query()
+	appName := values["name"][0]
+
+	audit.Log(auditevent.DeleteDrafts, r, appName)
+
+	m.deleteViewTempStore(appName)
+}
+
+func (m *ServiceMgr) deleteViewTempStore(appName string) (info *runtimeInfo) {
+	info = &runtimeInfo{}
+	logging.Infof("Deleting drafts from temporary store: %v", appName)
+
+	checkIfDeployed := false
+	for _, app := range util.ListChildren(metakvTempViewAppsPath) {
+		if app == appName {
+			checkIfDeployed = true
+		}
+	}
+
+	if !checkIfDeployed {
+		info.Code = m.statusCodes.errAppNotDeployed.Code
+		info.Info = fmt.Sprintf("App: %v not deployed", appName)
+		return
+	}
+
+	tempAppList := util.ListChildren(metakvTempViewAppsPath)
+
+	for _, tempAppName := range tempAppList {
+		if appName == tempAppName {
+			path := metakvTempViewAppsPath + tempAppName
+			err := util.MetaKvDelete(path, nil)
+			if err != nil {
+				info.Code = m.statusCodes.errDelAppTs.Code
+				info.Info = fmt.Sprintf("Failed to delete from temp store for %v, err: %v", appName, err)
+				return
+			}
+
+			info.Code = m.statusCodes.ok.Code
+			info.Info = fmt.Sprintf("Deleting app: %v in the background", appName)
+			return
+		}
+	}
+
+	info.Code = m.statusCodes.ok.Code
+	info.Info = fmt.Sprintf("Deleting app: %v in the background", appName)
+	return
+}
+
+func (m *ServiceMgr) getViewStoreHandler(w http.ResponseWriter, r *http.Request) {
+	if !m.validateAuth(w, r, EventingPermissionManage) {
+		return
+	}
+
+	logging.Infof("Getting all applications in primary store")
+	audit.Log(auditevent.FetchFunctions, r, nil)
+
+	appList := util.ListChildren(metakvViewAppsPath)
+	respData := make([]jsonType, len(appList))
+
+	for index, appName := range appList {
+
+		path := metakvViewAppsPath + appName
+		data, err := util.MetakvGet(path)
+		var details jsonType
+		err = json.Unmarshal(data, &details)
+		if err == nil {
+			respData[index] = details
+		}
+	}
+
+	data, err := json.Marshal(respData)
+	if err != nil {
+		w.Header().Add(headerKey, strconv.Itoa(m.statusCodes.errMarshalResp.Code))
+		fmt.Fprintf(w, "Failed to marshal response for get_application, err: %v", err)
+		return
+	}
+
+	w.Header().Add(headerKey, strconv.Itoa(m.statusCodes.ok.Code))
+	fmt.Fprintf(w, "%s\n", data)
+}
+
+func (m *ServiceMgr) getTempViewHandler(w http.ResponseWriter, r *http.Request) {
+	logging.Infof("GET TEMPHANDLER")
+	if !m.validateAuth(w, r, EventingPermissionManage) {
+		w.WriteHeader(http.StatusUnauthorized)
+		fmt.Fprintln(w, "{\"error\":\"Request not authorized\"}")
+		return
+	}
+	logging.Infof("TEMP HANDLER")
+	respData := m.getTempLibraryStoreAll()
+
+	data, err := json.Marshal(respData)
 	if err != nil {
 		w.Header().Add(headerKey, strconv.Itoa(m.statusCodes.errMarshalResp.Code))
 		w.WriteHeader(http.StatusInternalServerError)
@@ -1975,83 +2778,273 @@ func (m *ServiceMgr) getTempViewHandler(w http.ResponseWriter, r *http.Request)
 }
 
 func (m *ServiceMgr) getTempLibraryStoreAll() []jsonType {
-	logging.Infof("ALL ")
-	tempAppList := util.ListChildren(metakvViewAppsPath)
-	logging.Infof("TEMPLIST %v", tempAppList)
-	applications := make([]jsonType, len(tempAppList))
+	return m.getLibraryDrafts()
+}
 
-	for i, name := range tempAppList {
-		path := metakvViewAppsPath + name
-		data, err := util.MetakvGet(path)
-		if err == nil {
-			var app jsonType
-			uErr := json.Unmarshal(data, &app)
-			if uErr != nil {
-				logging.Errorf("Failed to unmarshal data from metakv, err: %v", uErr)
-				continue
-			}
+// Saves the draft of a library function, drafts are not validated
+func (m *ServiceMgr) saveTempViewHandler(w http.ResponseWriter, r *http.Request) {
+	if !m.validateAuth(w, r, EventingPermissionManage) {
+		return
+	}
 
-			applications[i] = app
+	params := r.URL.Query()
+	appName := params.Get("name")
+
+	audit.Log(auditevent.SaveDraft, r, appName)
+
+	app, info := m.unmarshalLibraryFunction(r, appName)
+	if info.Code != m.statusCodes.ok.Code {
+		m.sendLibraryError(w, http.StatusBadRequest, info)
+		return
+	}
+
+	// An existing draft is only replaced by a save naming its revision
+	saved, conflict, info := m.saveLibraryDraft(app)
+	if conflict {
+		m.sendLibraryConflict(w, saved, info)
+		return
+	} else if info.Code != m.statusCodes.ok.Code {
+		m.sendLibraryError(w, m.libraryHTTPStatus(info), info)
+		return
+	}
+	m.sendLibraryFunction(w, http.StatusOK, saved)
+}
+
+// Publishes the draft of a library function as a new version
+func (m *ServiceMgr) publishViewHandler(w http.ResponseWriter, r *http.Request) {
+	if !m.validateAuth(w, r, EventingPermissionManage) {
+		return
+	}
+
+	params := r.URL.Query()
+	appName := params.Get("name")
+
+	audit.Log(auditevent.CreateFunction, r, appName)
+
+	rev, ok := m.requireIfMatch(w, r, appName)
+	if !ok {
+		return
+	}
+
+	saved, conflict, info := m.publishLibraryDraft(appName, rev, libraryAuthor(r))
+	if conflict {
+		m.sendLibraryConflict(w, saved, info)
+		return
+	} else if info.Code != m.statusCodes.ok.Code {
+		m.sendLibraryError(w, m.libraryHTTPStatus(info), info)
+		return
+	}
+	m.sendLibraryFunction(w, http.StatusOK, saved)
+}
+
+// Returns, or with POST replaces, the test cases of a library function
+func (m *ServiceMgr) viewTestsHandler(w http.ResponseWriter, r *http.Request) {
+	if !m.validateAuth(w, r, EventingPermissionManage) {
+		return
+	}
+
+	appName := r.URL.Query().Get("name")
+
+	if r.Method == "POST" {
+		audit.Log(auditevent.SaveDraft, r, appName)
+
+		tests, info := m.unmarshalLibraryTests(r)
+		if info.Code == m.statusCodes.ok.Code {
+			info = m.saveLibraryTests(appName, tests)
 		}
+		m.sendErrorInfo(w, info)
+		return
 	}
 
-	return applications
+	audit.Log(auditevent.FetchFunctions, r, appName)
+
+	tests, err := m.getLibraryTests(appName)
+	if err != nil {
+		w.Header().Add(headerKey, strconv.Itoa(m.statusCodes.errGetConfig.Code))
+		fmt.Fprintf(w, "Failed to read test cases of library function: %v, err: %v", appName, err)
+		return
+	}
+
+	data, err := json.Marshal(tests)
+	if err != nil {
+		w.Header().Add(headerKey, strconv.Itoa(m.statusCodes.errMarshalResp.Code))
+		fmt.Fprintf(w, "Failed to marshal test cases of library function: %v, err: %v", appName, err)
+		return
+	}
+
+	w.Header().Add(headerKey, strconv.Itoa(m.statusCodes.ok.Code))
+	fmt.Fprintf(w, "%s\n", data)
 }
 
-func (m *ServiceMgr) saveViewStoreHandler(w http.ResponseWriter, r *http.Request) {
+// Runs the test cases of a library function against its latest version,
+// its draft with draft=true or a version with version=N
+func (m *ServiceMgr) runViewTestsHandler(w http.ResponseWriter, r *http.Request) {
 	if !m.validateAuth(w, r, EventingPermissionManage) {
 		return
 	}
 
 	params := r.URL.Query()
-	appName := params["name"][0]
+	appName := params.Get("name")
+	ref := params.Get("version")
+	if params.Get("draft") == "true" {
+		ref = "draft"
+	}
 
-	data, err := ioutil.ReadAll(r.Body)
+	audit.Log(auditevent.FetchFunctions, r, appName)
+
+	report, info := m.runLibraryTestsOn(appName, ref)
+	if info.Code != m.statusCodes.ok.Code {
+		m.sendErrorInfo(w, info)
+		return
+	}
+
+	data, err := json.Marshal(report)
 	if err != nil {
-		w.Header().Add(headerKey, strconv.Itoa(m.statusCodes.errReadReq.Code))
-		fmt.Fprintf(w, "Failed to read request body, err: %v", err)
+		w.Header().Add(headerKey, strconv.Itoa(m.statusCodes.errMarshalResp.Code))
+		fmt.Fprintf(w, "Failed to marshal test report of library function: %v, err: %v", appName, err)
 		return
 	}
-	logging.Infof("APPNAME :%s", appName)
-	var app jsonType
-	err = json.Unmarshal(data, &app)
-	logging.Infof("JSOND: %v %v %v", app.Name, app.AppCode, app.Description)
+
+	w.Header().Add(headerKey, strconv.Itoa(m.statusCodes.ok.Code))
+	fmt.Fprintf(w, "%s\n", data)
+}
+
+// Publishes an earlier version of a library function as a new version
+func (m *ServiceMgr) rollbackViewHandler(w http.ResponseWriter, r *http.Request) {
+	if !m.validateAuth(w, r, EventingPermissionManage) {
+		return
+	}
+
+	params := r.URL.Query()
+	appName := params.Get("name")
+
+	audit.Log(auditevent.CreateFunction, r, appName)
+
+	version, err := strconv.ParseUint(params.Get("version"), 10, 64)
 	if err != nil {
-		errString := fmt.Sprintf("App: %s, Failed to unmarshal payload", appName)
-		logging.Errorf("%s, err: %v", errString, err)
-		w.Header().Add(headerKey, strconv.Itoa(m.statusCodes.errUnmarshalPld.Code))
-		fmt.Fprintf(w, "%s\n", errString)
+		m.sendLibraryError(w, http.StatusBadRequest, &runtimeInfo{
+			Code: m.statusCodes.errReadReq.Code,
+			Info: fmt.Sprintf("Invalid version: %v for library function: %v", params.Get("version"), appName),
+		})
 		return
 	}
 
-	info := m.savePrimaryStoreView(app)
-	m.sendErrorInfo(w, info)
+	rev, ok := m.requireIfMatch(w, r, appName)
+	if !ok {
+		return
+	}
+
+	saved, conflict, info := m.rollbackLibraryFunction(appName, version, rev, libraryAuthor(r))
+	if conflict {
+		m.sendLibraryConflict(w, saved, info)
+		return
+	} else if info.Code != m.statusCodes.ok.Code {
+		m.sendLibraryError(w, m.libraryHTTPStatus(info), info)
+		return
+	}
+	m.sendLibraryFunction(w, http.StatusOK, saved)
 }
 
-func (m *ServiceMgr) savePrimaryStoreView(app jsonType) (info *runtimeInfo) {
-	appName := app.Name
-	info = &runtimeInfo{}
-	path := metakvViewAppsPath + appName
-	data, err := json.Marshal(app)
+// Diffs two revisions of a library function, by default its latest
+// published version against its draft
+func (m *ServiceMgr) getViewDiffHandler(w http.ResponseWriter, r *http.Request) {
+	if !m.validateAuth(w, r, EventingPermissionManage) {
+		return
+	}
+
+	params := r.URL.Query()
+	appName := params.Get("name")
+	to := params.Get("to")
+	if to == "" {
+		to = "draft"
+	}
+
+	audit.Log(auditevent.FetchDrafts, r, appName)
+
+	diff, info := m.diffLibraryRevisions(appName, params.Get("from"), to)
+	if info.Code != m.statusCodes.ok.Code {
+		m.sendErrorInfo(w, info)
+		return
+	}
+
+	data, err := json.Marshal(diff)
 	if err != nil {
-		logging.Infof("ERROR %v", err)
-		info.Code = m.statusCodes.errMarshalResp.Code
-		info.Info = fmt.Sprintf("Failed to marshal data as JSON to save in temp store, err : %v", err)
+		w.Header().Add(headerKey, strconv.Itoa(m.statusCodes.errMarshalResp.Code))
+		fmt.Fprintf(w, "Failed to marshal response for library diff, err: %v", err)
 		return
 	}
-	err = util.MetakvSet(path, data, nil)
 
-/*
-	//GETTING CODE IN THE PROJECTOR
-	type Code struct {
-		Code string `json:"appcode"`
+	w.Header().Add(headerKey, strconv.Itoa(m.statusCodes.ok.Code))
+	fmt.Fprintf(w, "%s\n", data)
+}
+
+// Publishes a library function from the UI as a new version
+func (m *ServiceMgr) saveViewStoreHandler(w http.ResponseWriter, r *http.Request) {
+	if !m.validateAuth(w, r, EventingPermissionManage) {
+		return
 	}
-	var t testing
-	_, err = c.MetakvGet(path, &t)
-*/
 
-	info.Code = m.statusCodes.ok.Code
-	info.Info = "Stored application config in metakv"
+	params := r.URL.Query()
+	appName := params.Get("name")
 
-	return
+	audit.Log(auditevent.CreateFunction, r, appName)
+
+	app, info := m.unmarshalLibraryFunction(r, appName)
+	if info.Code != m.statusCodes.ok.Code {
+		m.sendLibraryError(w, http.StatusBadRequest, info)
+		return
+	}
+	app.Author = libraryAuthor(r)
+
+	// An existing function is only replaced by a save naming its revision
+	saved, conflict, info := m.saveLibraryVersion(app)
+	if conflict {
+		m.sendLibraryConflict(w, saved, info)
+		return
+	} else if info.Code != m.statusCodes.ok.Code {
+		m.sendLibraryError(w, m.libraryHTTPStatus(info), info)
+		return
+	}
+	m.sendLibraryFunction(w, http.StatusOK, saved)
+}
+
+func (m *ServiceMgr) getViewVersionsHandler(w http.ResponseWriter, r *http.Request) {
+	if !m.validateAuth(w, r, EventingPermissionManage) {
+		return
+	}
+
+	params := r.URL.Query()
+	appName := params.Get("name")
+
+	audit.Log(auditevent.FetchFunctions, r, appName)
+
+	var respData interface{}
+	if v := params.Get("version"); v != "" {
+		version, err := strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			w.Header().Add(headerKey, strconv.Itoa(m.statusCodes.errReadReq.Code))
+			fmt.Fprintf(w, "Invalid version: %v for library function: %v", v, appName)
+			return
+		}
+
+		app, found, err := m.getLibraryVersion(appName, version)
+		if err != nil || !found {
+			w.Header().Add(headerKey, strconv.Itoa(m.statusCodes.errAppNotFoundTs.Code))
+			fmt.Fprintf(w, "Version: %v of library function: %v not found, err: %v", version, appName, err)
+			return
+		}
+		respData = app
+	} else {
+		respData = m.getLibraryVersions(appName)
+	}
+
+	data, err := json.Marshal(respData)
+	if err != nil {
+		w.Header().Add(headerKey, strconv.Itoa(m.statusCodes.errMarshalResp.Code))
+		fmt.Fprintf(w, "Failed to marshal response for library versions, err: %v", err)
+		return
+	}
+
+	w.Header().Add(headerKey, strconv.Itoa(m.statusCodes.ok.Code))
+	fmt.Fprintf(w, "%s\n", data)
 }
@@ -0,0 +1,913 @@
+package servicemanager
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/couchbase/cbauth/metakv"
+	"github.com/couchbase/eventing/logging"
+	"github.com/couchbase/eventing/util"
+	c "github.com/couchbase/indexing/secondary/common"
+)
+
+// Library functions are stored as immutable versions under
+// metakvViewVersionsPath/<name>/<version>. The entry under
+// metakvViewAppsPath/<name> always mirrors the latest version, so that
+// readers that only care about the current code keep working. Indexes
+// pin a version at CREATE INDEX time and are unaffected by later saves.
+// Only the latest library_history_limit versions are kept, along with
+// the versions indexes are pinned to.
+
+const (
+	libraryHistoryLimitDefault = 20
+	libraryLanguageDefault     = "javascript"
+)
+
+func libraryVersionPath(appName string, version uint64) string {
+	return metakvViewVersionsPath + appName + "/" + strconv.FormatUint(version, 10)
+}
+
+// Returns the version the next save of a library function gets, past every
+// stored version: versions outlive the function when it is deleted, and a
+// function created again under the same name never reuses their numbers
+func libraryNextVersion(appName string, latest uint64) uint64 {
+	next := latest + 1
+	for _, child := range util.ListChildren(metakvViewVersionsPath + appName + "/") {
+		if version, err := strconv.ParseUint(child, 10, 64); err == nil && version >= next {
+			next = version + 1
+		}
+	}
+	return next
+}
+
+// Library entries are updated with metakv compare-and-set. Their metakv
+// revision, opaque to clients, is exposed as jsonType.Rev and as the ETag
+// of REST reads, and is expected back, as If-Match or rev in the body, by
+// updates.
+
+func encodeLibraryRev(rev interface{}) string {
+	if b, ok := rev.([]byte); ok && len(b) > 0 {
+		return base64.RawURLEncoding.EncodeToString(b)
+	}
+	return ""
+}
+
+func decodeLibraryRev(rev string) (interface{}, error) {
+	b, err := base64.RawURLEncoding.DecodeString(rev)
+	if err != nil || len(b) == 0 {
+		return nil, fmt.Errorf("invalid revision: %v", rev)
+	}
+	return b, nil
+}
+
+// Returns the library function stored at path along with its revision,
+// found is false if there is none
+func (m *ServiceMgr) getLibraryFunction(path string) (app jsonType, found bool, err error) {
+	data, rev, err := metakv.Get(path)
+	if err != nil || data == nil {
+		return
+	}
+
+	err = json.Unmarshal(data, &app)
+	if err != nil {
+		return
+	}
+
+	if app.Timestamp != "" {
+		if app.Modified == "" {
+			app.Modified = app.Timestamp
+		}
+		if app.Created == "" {
+			app.Created = app.Timestamp // the earliest known
+		}
+		app.Timestamp = ""
+	}
+	app.Rev = encodeLibraryRev(rev)
+	found = true
+	return
+}
+
+// Writes a library entry if its revision is still expectedRev, or if it
+// does not exist when expectedRev is empty. conflict is set when metakv
+// refuses the write for a concurrent update.
+func (m *ServiceMgr) setLibraryEntry(path string, app jsonType, expectedRev string) (conflict bool, err error) {
+	app.Rev, app.Tests, app.Timestamp = "", nil, ""
+	data, err := json.Marshal(app)
+	if err != nil {
+		return
+	}
+
+	if expectedRev == "" {
+		err = metakv.Add(path, data)
+	} else {
+		var rev interface{}
+		if rev, err = decodeLibraryRev(expectedRev); err != nil {
+			return true, err
+		}
+		err = metakv.Set(path, data, rev)
+	}
+
+	if err == metakv.ErrRevMismatch {
+		conflict = true
+	}
+	return
+}
+
+// Returns the latest version of a library function, found is false if
+// the function was never saved
+func (m *ServiceMgr) getLibraryLatest(appName string) (app jsonType, found bool, err error) {
+	return m.getLibraryFunction(metakvViewAppsPath + appName)
+}
+
+// Returns a specific version of a library function
+func (m *ServiceMgr) getLibraryVersion(appName string, version uint64) (app jsonType, found bool, err error) {
+	return m.getLibraryFunction(libraryVersionPath(appName, version))
+}
+
+// Returns the latest version of every library function
+func (m *ServiceMgr) getLibraryLatestAll() []jsonType {
+	return m.getLibraryFunctions(metakvViewAppsPath)
+}
+
+// Returns the library functions stored under a directory of metakv
+func (m *ServiceMgr) getLibraryFunctions(dir string) []jsonType {
+	apps := make([]jsonType, 0)
+
+	for _, appName := range util.ListChildren(dir) {
+		app, found, err := m.getLibraryFunction(dir + appName)
+		if err != nil || !found {
+			logging.Errorf("Failed to read library function: %v, err: %v", dir+appName, err)
+			continue
+		}
+		apps = append(apps, app)
+	}
+
+	return apps
+}
+
+// Returns all versions of a library function in ascending order
+func (m *ServiceMgr) getLibraryVersions(appName string) []jsonType {
+	versions := make([]jsonType, 0)
+
+	for _, child := range util.ListChildren(metakvViewVersionsPath + appName + "/") {
+		version, err := strconv.ParseUint(child, 10, 64)
+		if err != nil {
+			logging.Errorf("Skipping unexpected version entry %v for library function: %v", child, appName)
+			continue
+		}
+
+		app, found, err := m.getLibraryVersion(appName, version)
+		if err != nil || !found {
+			logging.Errorf("Failed to read version %v of library function: %v, err: %v", version, appName, err)
+			continue
+		}
+		versions = append(versions, app)
+	}
+
+	sort.Slice(versions, func(i, j int) bool {
+		return versions[i].Version < versions[j].Version
+	})
+	return versions
+}
+
+// Saves the code as a new immutable version of the library function and
+// makes it the latest one. Saving code identical to the latest version
+// does not create a new version. When app.Rev is set, the save is refused
+// with conflict unless it is the revision of the latest version, saved
+// is then the latest version. A failing test case refuses the save.
+func (m *ServiceMgr) saveLibraryVersion(app jsonType) (saved jsonType, conflict bool, info *runtimeInfo) {
+	// Only a new function may be saved without naming a revision
+	if app.Rev == "" {
+		latest, found, err := m.getLibraryLatest(app.Name)
+		if err != nil {
+			info = &runtimeInfo{
+				Code: m.statusCodes.errGetConfig.Code,
+				Info: fmt.Sprintf("Failed to read library function: %v, err: %v", app.Name, err),
+			}
+			return
+		} else if found {
+			saved, conflict = latest, true
+			info = &runtimeInfo{
+				Code: m.statusCodes.errSaveAppPs.Code,
+				Info: fmt.Sprintf("Library function: %v exists, save requires its current revision: %v", app.Name, latest.Rev),
+			}
+			return
+		}
+	}
+
+	if info = m.transpileLibraryFunction(&app); info.Code != m.statusCodes.ok.Code {
+		return
+	}
+
+	if info = m.resolveLibraryDependencies(&app); info.Code != m.statusCodes.ok.Code {
+		return
+	}
+
+	if info = m.validateLibraryFunction(app); info.Code != m.statusCodes.ok.Code {
+		return
+	}
+
+	report, info := m.checkLibraryTests(app)
+	if info.Code != m.statusCodes.ok.Code {
+		return
+	}
+
+	saved, conflict, info = m.storeLibraryVersion(app)
+	saved.Tests = report
+	return
+}
+
+// saveLibraryVersion for code already validated
+func (m *ServiceMgr) storeLibraryVersion(app jsonType) (saved jsonType, conflict bool, info *runtimeInfo) {
+	info = &runtimeInfo{}
+	appName := app.Name
+	expectedRev := app.Rev
+
+	latest, found, err := m.getLibraryLatest(appName)
+	if err != nil {
+		info.Code = m.statusCodes.errGetConfig.Code
+		info.Info = fmt.Sprintf("Failed to read library function: %v, err: %v", appName, err)
+		return
+	}
+
+	if expectedRev != "" && expectedRev != latest.Rev {
+		saved, conflict = latest, true
+		info.Code = m.statusCodes.errSaveAppPs.Code
+		info.Info = fmt.Sprintf("Library function: %v was modified concurrently, current revision: %v", appName, latest.Rev)
+		return
+	}
+
+	// Ownership and creation time carry over from the latest version
+	if app.Language == "" {
+		app.Language = libraryLanguageDefault
+	}
+	if app.Owner == "" {
+		app.Owner = latest.Owner
+	}
+	if app.Owner == "" {
+		app.Owner = app.Author
+	}
+	app.Created = latest.Created
+
+	app.Hash = c.JSFunctionHash(app.AppCode)
+	if found && latest.Hash == app.Hash && libraryMetadataEqual(latest, app) {
+		saved = latest
+		info.Code = m.statusCodes.ok.Code
+		info.Info = fmt.Sprintf("Library function: %v unchanged at version %v", appName, latest.Version)
+		return
+	}
+
+	app.Version = libraryNextVersion(appName, latest.Version)
+	app.Modified = time.Now().UTC().Format(time.RFC3339)
+	if app.Created == "" {
+		app.Created = app.Modified
+	}
+	path := libraryVersionPath(appName, app.Version)
+
+	// Versions are never rewritten, a concurrent save may have got there first
+	conflict, err = m.setLibraryEntry(path, app, "")
+	if conflict {
+		saved, _, _ = m.getLibraryLatest(appName)
+		info.Code = m.statusCodes.errSaveAppPs.Code
+		info.Info = fmt.Sprintf("Version %v of library function: %v was saved concurrently, current revision: %v", app.Version, appName, saved.Rev)
+		return
+	} else if err != nil {
+		info.Code = m.statusCodes.errSaveAppPs.Code
+		info.Info = fmt.Sprintf("Failed to store version %v of library function: %v, err: %v", app.Version, appName, err)
+		return
+	}
+
+	conflict, err = m.setLibraryEntry(metakvViewAppsPath+appName, app, latest.Rev)
+	if err != nil {
+		// Nothing refers to the version yet, take it back
+		if dErr := util.MetaKvDelete(path, nil); dErr != nil {
+			logging.Errorf("Failed to remove unpublished version %v of library function: %v, err: %v", app.Version, appName, dErr)
+		}
+
+		info.Code = m.statusCodes.errSaveAppPs.Code
+		if conflict {
+			saved, _, _ = m.getLibraryLatest(appName)
+			info.Info = fmt.Sprintf("Library function: %v was modified concurrently, current revision: %v", appName, saved.Rev)
+		} else {
+			info.Info = fmt.Sprintf("Failed to publish version %v of library function: %v, err: %v", app.Version, appName, err)
+		}
+		return
+	}
+
+	logging.Infof("Stored library function: %v version: %v hash: %v author: %v", appName, app.Version, app.Hash, app.Author)
+	m.pruneLibraryHistory(appName)
+
+	saved = app
+	if current, found, _ := m.getLibraryLatest(appName); found && current.Version == app.Version {
+		saved.Rev = current.Rev
+	}
+	info.Code = m.statusCodes.ok.Code
+	info.Info = fmt.Sprintf("Stored library function: %v version: %v", appName, app.Version)
+	return
+}
+
+// Returns true if the metadata of two revisions of a library function,
+// other than timestamps, is the same
+func libraryMetadataEqual(a, b jsonType) bool {
+	equal := func(x, y []string) bool {
+		if len(x) != len(y) {
+			return false
+		}
+		for i := range x {
+			if x[i] != y[i] {
+				return false
+			}
+		}
+		return true
+	}
+
+	return a.Description == b.Description && a.Language == b.Language && a.Source == b.Source &&
+		a.RuntimeVersion == b.RuntimeVersion && a.Owner == b.Owner && a.Module == b.Module &&
+		equal(a.EntryPoints, b.EntryPoints) && equal(a.Tags, b.Tags) && equal(a.Buckets, b.Buckets) &&
+		reflect.DeepEqual(a.Dependencies, b.Dependencies)
+}
+
+// Returns the page of apps matching filter, in its order, along with the
+// number of apps that match
+func filterLibraryFunctions(apps []jsonType, filter libraryFilter) (page []jsonType, total int) {
+	contains := func(values []string, value string) bool {
+		for _, v := range values {
+			if v == value {
+				return true
+			}
+		}
+		return false
+	}
+
+	query := strings.ToLower(filter.Query)
+	page = make([]jsonType, 0)
+
+next:
+	for _, app := range apps {
+		if query != "" && !strings.Contains(strings.ToLower(app.Name), query) &&
+			!strings.Contains(strings.ToLower(app.Description), query) {
+			continue
+		}
+		for _, tag := range filter.Tags {
+			if !contains(app.Tags, tag) {
+				continue next
+			}
+		}
+		entryPoints := app.EntryPoints
+		if len(entryPoints) == 0 {
+			entryPoints = []string{c.DefaultJSEntryPoint}
+		}
+		if (filter.Bucket != "" && !contains(app.Buckets, filter.Bucket)) ||
+			(filter.EntryPoint != "" && !contains(entryPoints, filter.EntryPoint)) ||
+			(filter.Owner != "" && app.Owner != filter.Owner) ||
+			(filter.Language != "" && app.Language != filter.Language) {
+			continue
+		}
+		if !filter.ModifiedSince.IsZero() || !filter.ModifiedBefore.IsZero() {
+			modified, err := time.Parse(time.RFC3339, app.Modified)
+			if err != nil || (!filter.ModifiedSince.IsZero() && modified.Before(filter.ModifiedSince)) ||
+				(!filter.ModifiedBefore.IsZero() && !modified.Before(filter.ModifiedBefore)) {
+				continue
+			}
+		}
+		page = append(page, app)
+	}
+
+	// RFC 3339 timestamps in UTC sort as strings
+	desc := strings.HasPrefix(filter.Sort, "-")
+	key := func(app jsonType) string {
+		switch strings.TrimPrefix(filter.Sort, "-") {
+		case "modified":
+			return app.Modified
+		case "created":
+			return app.Created
+		}
+		return app.Name
+	}
+	sort.SliceStable(page, func(i, j int) bool {
+		ki, kj := key(page[i]), key(page[j])
+		if ki == kj {
+			return page[i].Name < page[j].Name
+		} else if desc {
+			return ki > kj
+		}
+		return ki < kj
+	})
+
+	total = len(page)
+	if filter.Offset >= total {
+		return page[:0], total
+	}
+	page = page[filter.Offset:]
+	if filter.Limit > 0 && filter.Limit < len(page) {
+		page = page[:filter.Limit]
+	}
+	return
+}
+
+// Evaluates a library function against sample documents in the engine
+// projector evaluates it in, see libraryTryRequest
+func (m *ServiceMgr) tryLibraryFunction(req libraryTryRequest) (resp libraryTryResponse, info *runtimeInfo) {
+	info = &runtimeInfo{}
+
+	app := jsonType{Name: req.Name, AppCode: req.AppCode, Language: req.Language, Source: req.Source}
+	if app.AppCode != "" || app.Source != "" {
+		if info = m.transpileLibraryFunction(&app); info.Code != m.statusCodes.ok.Code {
+			return
+		}
+		if info = m.resolveLibraryDependencies(&app); info.Code != m.statusCodes.ok.Code {
+			return
+		}
+	} else {
+		var found bool
+		var err error
+		if req.Version == 0 {
+			app, found, err = m.getLibraryLatest(req.Name)
+		} else {
+			app, found, err = m.getLibraryVersion(req.Name, req.Version)
+		}
+		if err != nil {
+			info.Code = m.statusCodes.errGetConfig.Code
+			info.Info = fmt.Sprintf("Failed to read library function: %v, err: %v", req.Name, err)
+			return
+		} else if !found {
+			info.Code = m.statusCodes.errAppNotFoundTs.Code
+			info.Info = fmt.Sprintf("Library function: %v version: %v not found", req.Name, req.Version)
+			return
+		}
+	}
+
+	resp.Name, resp.Version, resp.EntryPoint = req.Name, app.Version, req.EntryPoint
+	if resp.EntryPoint == "" {
+		resp.EntryPoint = c.JSEntryPoints(app.EntryPoints)[0].Name
+	}
+
+	code, err := m.bundleLibraryFunction(app)
+	if err != nil {
+		info.Code = m.statusCodes.errHandlerCompile.Code
+		info.Info = fmt.Sprintf("Library function: %v version: %v, %v", req.Name, app.Version, err)
+		return
+	}
+
+	results, err := tryLibraryCode(req.Name, code, resp.EntryPoint, req.Docs)
+	if err == c.ErrorJSTryTooManyDocs {
+		info.Code = m.statusCodes.errReadReq.Code
+		info.Info = fmt.Sprintf("At most %v documents can be tried at once", c.JSTryMaxDocs)
+		return
+	} else if err != nil {
+		info.Code = m.statusCodes.errHandlerCompile.Code
+		info.Info = c.MapJSErrorLines(app.SourceMap, err.Error())
+		return
+	}
+
+	for _, result := range results {
+		result.Exception = c.MapJSErrorLines(app.SourceMap, result.Exception)
+	}
+	resp.Results = results
+	info.Code = m.statusCodes.ok.Code
+	return
+}
+
+// Deletes the oldest versions of a library function beyond the history
+// limit, but for versions indexes are pinned to
+func (m *ServiceMgr) pruneLibraryHistory(appName string) {
+	limit := libraryHistoryLimitDefault
+	if conf, info := m.getConfig(); info.Code == m.statusCodes.ok.Code && conf.LibraryHistoryLimit > 0 {
+		limit = conf.LibraryHistoryLimit
+	}
+
+	versions := make([]uint64, 0)
+	for _, child := range util.ListChildren(metakvViewVersionsPath + appName + "/") {
+		if version, err := strconv.ParseUint(child, 10, 64); err == nil {
+			versions = append(versions, version)
+		}
+	}
+	if len(versions) <= limit {
+		return
+	}
+	sort.Slice(versions, func(i, j int) bool {
+		return versions[i] < versions[j]
+	})
+
+	pinned := make(map[uint64]bool)
+	for _, ref := range m.getLibraryRefs(appName) {
+		pinned[ref.Version] = true
+	}
+
+	// Only versions marked as module can be depended on, walking the
+	// dependencies of every function is left to when one is pruned
+	candidates := make([]uint64, 0)
+	modules := false
+	for _, version := range versions[:len(versions)-limit] {
+		if pinned[version] {
+			continue
+		}
+		candidates = append(candidates, version)
+		if app, found, err := m.getLibraryVersion(appName, version); err != nil || (found && app.Module) {
+			modules = true
+		}
+	}
+	if modules {
+		for version := range m.getLibraryDependencyPins(appName) {
+			pinned[version] = true
+		}
+	}
+
+	for _, version := range candidates {
+		if pinned[version] {
+			continue
+		}
+		if err := util.MetaKvDelete(libraryVersionPath(appName, version), nil); err != nil {
+			logging.Errorf("Failed to prune version %v of library function: %v, err: %v", version, appName, err)
+			continue
+		}
+		m.libraryDeps.Delete(fmt.Sprintf("%v@%v", appName, version))
+		logging.Infof("Pruned version %v of library function: %v, history limit: %v", version, appName, limit)
+	}
+}
+
+// Publishes the code of an earlier version of a library function as a new
+// version, expectedRev must be the revision of the latest version
+func (m *ServiceMgr) rollbackLibraryFunction(appName string, version uint64, expectedRev, author string) (saved jsonType, conflict bool, info *runtimeInfo) {
+	info = &runtimeInfo{}
+
+	app, found, err := m.getLibraryVersion(appName, version)
+	if err != nil {
+		info.Code = m.statusCodes.errGetConfig.Code
+		info.Info = fmt.Sprintf("Failed to read version %v of library function: %v, err: %v", version, appName, err)
+		return
+	} else if !found {
+		info.Code = m.statusCodes.errAppNotFoundTs.Code
+		info.Info = fmt.Sprintf("Version: %v of library function: %v not found, it may have been pruned", version, appName)
+		return
+	}
+
+	latest, _, err := m.getLibraryLatest(appName)
+	if err != nil {
+		info.Code = m.statusCodes.errGetConfig.Code
+		info.Info = fmt.Sprintf("Failed to read library function: %v, err: %v", appName, err)
+		return
+	} else if expectedRev != latest.Rev {
+		saved, conflict = latest, true
+		info.Code = m.statusCodes.errSaveAppPs.Code
+		info.Info = fmt.Sprintf("Library function: %v was modified concurrently, current revision: %v", appName, latest.Rev)
+		return
+	}
+
+	// The version was validated when it was saved, test cases may have
+	// changed since
+	report, info := m.checkLibraryTests(app)
+	if info.Code != m.statusCodes.ok.Code {
+		return
+	}
+
+	app.Rev, app.Author = expectedRev, author
+	if saved, conflict, info = m.storeLibraryVersion(app); info.Code != m.statusCodes.ok.Code {
+		return
+	}
+	saved.Tests = report
+
+	logging.Infof("Rolled back library function: %v to version: %v as version: %v", appName, version, saved.Version)
+	info.Info = fmt.Sprintf("Rolled back library function: %v to version: %v as version: %v", appName, version, saved.Version)
+	return
+}
+
+// Returns the index definitions bound to a library function, recorded by
+// indexer under metakvViewRefsPath/<name>/<defnId>
+func (m *ServiceMgr) getLibraryRefs(appName string) []c.JSFunctionRef {
+	refs := make([]c.JSFunctionRef, 0)
+	dir := metakvViewRefsPath + appName + "/"
+
+	for _, child := range util.ListChildren(dir) {
+		data, err := util.MetakvGet(dir + child)
+		if err != nil || data == nil {
+			logging.Errorf("Failed to read index reference %v of library function: %v, err: %v", child, appName, err)
+			continue
+		}
+
+		var ref c.JSFunctionRef
+		if err = json.Unmarshal(data, &ref); err != nil {
+			logging.Errorf("Failed to unmarshal index reference %v of library function: %v, err: %v", child, appName, err)
+			continue
+		}
+		refs = append(refs, ref)
+	}
+
+	sort.Slice(refs, func(i, j int) bool {
+		return refs[i].DefnId < refs[j].DefnId
+	})
+	return refs
+}
+
+// Records an error against every index bound to a library function
+// deleted by force, indexer moves those indexes to an error state
+func (m *ServiceMgr) markLibraryRefsErrored(appName string, refs []c.JSFunctionRef) error {
+	for _, ref := range refs {
+		ref.Error = fmt.Sprintf("library function %v was deleted", appName)
+
+		data, err := json.Marshal(&ref)
+		if err != nil {
+			return err
+		}
+
+		path := metakvViewRefsPath + appName + "/" + strconv.FormatUint(uint64(ref.DefnId), 10)
+		if err = util.MetakvSet(path, data, nil); err != nil {
+			return err
+		}
+		logging.Infof("Marked index: %v.%v errored, library function: %v deleted by force", ref.Bucket, ref.Name, appName)
+	}
+	return nil
+}
+
+// Compiles the code with compileLibraryCode, in projector's engine through
+// protobuf.ValidateJS, then lints it for entry points and constructs
+// projector refuses. Diagnostics are returned as libraryDiagnostics
+// marshalled into info.Info, with errHandlerCompile.
+func (m *ServiceMgr) validateLibraryFunction(app jsonType) (info *runtimeInfo) {
+	info = &runtimeInfo{}
+	appName := app.Name
+
+	diags := libraryDiagnostics{CompileSuccess: true}
+
+	// Compiled the way projector compiles it for an index
+	if compileErr := compileLibraryCode(app.AppCode); compileErr != nil {
+		diags.CompileSuccess = false
+		diags.Diagnostics = []c.JSDiagnostic{*compileErr}
+	} else {
+		// Modules need not declare entry points
+		entryPoints := c.JSEntryPoints(app.EntryPoints)
+		if app.Module && len(app.EntryPoints) == 0 {
+			entryPoints = nil
+		}
+		diags.Diagnostics = c.LintJSFunction(app.AppCode, entryPoints)
+	}
+	mapLibraryDiagnostics(app, diags.Diagnostics)
+
+	if !diags.CompileSuccess || c.JSDiagnosticsError(diags.Diagnostics) {
+		data, err := json.Marshal(&diags)
+		if err != nil {
+			info.Code = m.statusCodes.errMarshalResp.Code
+			info.Info = fmt.Sprintf("Failed to marshal diagnostics of library function: %v, err: %v", appName, err)
+			return
+		}
+
+		logging.Errorf("Rejected library function: %v, diagnostics: %s", appName, data)
+		info.Code = m.statusCodes.errHandlerCompile.Code
+		info.Info = string(data)
+		return
+	}
+
+	info.Code = m.statusCodes.ok.Code
+	return
+}
+
+// Drafts live under metakvTempViewAppsPath/<name>, at most one per library
+// function, and are overwritten on every save. The version of a draft is
+// the published version it was started from, 0 for a function never
+// published.
+
+// Returns the draft of a library function, found is false if there is none
+func (m *ServiceMgr) getLibraryDraft(appName string) (app jsonType, found bool, err error) {
+	return m.getLibraryFunction(metakvTempViewAppsPath + appName)
+}
+
+// Returns the drafts of all library functions
+func (m *ServiceMgr) getLibraryDrafts() []jsonType {
+	return m.getLibraryFunctions(metakvTempViewAppsPath)
+}
+
+// Saves the code as the draft of the library function. When app.Rev is
+// set, the save is refused with conflict unless it is the revision of the
+// current draft, saved is then the current draft. saved.Tests reports the
+// test cases run against the draft.
+func (m *ServiceMgr) saveLibraryDraft(app jsonType) (saved jsonType, conflict bool, info *runtimeInfo) {
+	info = &runtimeInfo{}
+	appName := app.Name
+	expectedRev := app.Rev
+
+	draft, found, err := m.getLibraryDraft(appName)
+	if err != nil {
+		info.Code = m.statusCodes.errGetConfig.Code
+		info.Info = fmt.Sprintf("Failed to read draft of library function: %v, err: %v", appName, err)
+		return
+	}
+
+	// Only a new draft may be saved without naming a revision
+	if (found || expectedRev != "") && expectedRev != draft.Rev {
+		saved, conflict = draft, true
+		info.Code = m.statusCodes.errSaveAppTs.Code
+		info.Info = fmt.Sprintf("Draft of library function: %v was modified concurrently, current revision: %v", appName, draft.Rev)
+		return
+	}
+
+	latest, _, err := m.getLibraryLatest(appName)
+	if err != nil {
+		info.Code = m.statusCodes.errGetConfig.Code
+		info.Info = fmt.Sprintf("Failed to read library function: %v, err: %v", appName, err)
+		return
+	}
+
+	// Drafts are stored even when their source does not transpile, or
+	// requires modules that cannot be resolved, tests are then skipped
+	// and publishing reports the errors
+	tInfo := m.transpileLibraryFunction(&app)
+	if tInfo.Code != m.statusCodes.ok.Code {
+		app.AppCode, app.SourceMap = "", ""
+		logging.Errorf("Failed to transpile draft of library function: %v, %v", appName, tInfo.Info)
+	} else if tInfo = m.resolveLibraryDependencies(&app); tInfo.Code != m.statusCodes.ok.Code {
+		logging.Errorf("Failed to resolve modules of draft of library function: %v, %v", appName, tInfo.Info)
+	}
+
+	app.Version = latest.Version
+	app.Hash = c.JSFunctionHash(app.AppCode)
+	app.Created, app.Modified = latest.Created, time.Now().UTC().Format(time.RFC3339)
+
+	path := metakvTempViewAppsPath + appName
+	if found {
+		conflict, err = m.setLibraryEntry(path, app, draft.Rev)
+	} else {
+		conflict, err = m.setLibraryEntry(path, app, "")
+	}
+	if conflict {
+		saved, _, _ = m.getLibraryDraft(appName)
+		info.Code = m.statusCodes.errSaveAppTs.Code
+		info.Info = fmt.Sprintf("Draft of library function: %v was modified concurrently, current revision: %v", appName, saved.Rev)
+		return
+	} else if err != nil {
+		info.Code = m.statusCodes.errSaveAppTs.Code
+		info.Info = fmt.Sprintf("Failed to store draft of library function: %v, err: %v", appName, err)
+		return
+	}
+
+	logging.Infof("Stored draft of library function: %v based on version: %v", appName, app.Version)
+
+	saved, _, _ = m.getLibraryDraft(appName)
+
+	// Failing test cases are reported, they only block publishing
+	if tInfo.Code != m.statusCodes.ok.Code {
+		logging.Infof("Skipped test cases of draft of library function: %v", appName)
+	} else if saved.Tests, err = m.runLibraryTests(saved); err != nil {
+		logging.Errorf("Failed to run test cases of library function: %v, err: %v", appName, err)
+	}
+
+	info.Code = m.statusCodes.ok.Code
+	info.Info = fmt.Sprintf("Stored draft of library function: %v", appName)
+	return
+}
+
+// Deletes the draft of a library function
+func (m *ServiceMgr) deleteLibraryDraft(appName string) (info *runtimeInfo) {
+	info = &runtimeInfo{}
+
+	_, found, err := m.getLibraryDraft(appName)
+	if err != nil {
+		info.Code = m.statusCodes.errGetConfig.Code
+		info.Info = fmt.Sprintf("Failed to read draft of library function: %v, err: %v", appName, err)
+		return
+	} else if !found {
+		info.Code = m.statusCodes.errAppNotFoundTs.Code
+		info.Info = fmt.Sprintf("Library function: %v has no draft", appName)
+		return
+	}
+
+	err = util.MetaKvDelete(metakvTempViewAppsPath+appName, nil)
+	if err != nil {
+		info.Code = m.statusCodes.errDelAppTs.Code
+		info.Info = fmt.Sprintf("Failed to delete draft of library function: %v, err: %v", appName, err)
+		return
+	}
+
+	info.Code = m.statusCodes.ok.Code
+	info.Info = fmt.Sprintf("Deleted draft of library function: %v", appName)
+	return
+}
+
+// Promotes the draft of a library function to a new published version,
+// validated like any save. conflict is set, and nothing published, when
+// draftRev is set and is not the revision of the draft, or when another
+// version was published since the draft was started; saving the draft
+// again rebases it on the latest version. The draft is deleted once
+// published, unless it was saved again meanwhile. The version is authored
+// by the publisher.
+func (m *ServiceMgr) publishLibraryDraft(appName, draftRev, author string) (saved jsonType, conflict bool, info *runtimeInfo) {
+	info = &runtimeInfo{}
+
+	draft, found, err := m.getLibraryDraft(appName)
+	if err != nil {
+		info.Code = m.statusCodes.errGetConfig.Code
+		info.Info = fmt.Sprintf("Failed to read draft of library function: %v, err: %v", appName, err)
+		return
+	} else if !found {
+		info.Code = m.statusCodes.errAppNotFoundTs.Code
+		info.Info = fmt.Sprintf("Library function: %v has no draft to publish", appName)
+		return
+	}
+
+	if draftRev != draft.Rev {
+		saved, conflict = draft, true
+		info.Code = m.statusCodes.errSaveAppTs.Code
+		info.Info = fmt.Sprintf("Draft of library function: %v was modified concurrently, current revision: %v", appName, draft.Rev)
+		return
+	}
+
+	latest, _, err := m.getLibraryLatest(appName)
+	if err != nil {
+		info.Code = m.statusCodes.errGetConfig.Code
+		info.Info = fmt.Sprintf("Failed to read library function: %v, err: %v", appName, err)
+		return
+	}
+
+	if latest.Version != draft.Version {
+		saved, conflict = latest, true
+		info.Code = m.statusCodes.errSaveAppPs.Code
+		info.Info = fmt.Sprintf("Library function: %v was published at version %v after its draft was started from version %v, save the draft again to rebase it",
+			appName, latest.Version, draft.Version)
+		return
+	}
+
+	// Publish over the latest version the draft was checked against
+	draftRev = draft.Rev
+	draft.Rev, draft.Author = latest.Rev, author
+	saved, conflict, info = m.saveLibraryVersion(draft)
+	if info.Code != m.statusCodes.ok.Code {
+		return
+	}
+
+	// The function is published, a draft left behind is only stale
+	rev, _ := decodeLibraryRev(draftRev)
+	if err = util.MetaKvDelete(metakvTempViewAppsPath+appName, rev); err != nil {
+		logging.Errorf("Published library function: %v but kept its draft, err: %v", appName, err)
+	}
+
+	info.Info = fmt.Sprintf("Published library function: %v version: %v", appName, saved.Version)
+	return
+}
+
+// Returns the revision of a library function named by ref: "draft", a
+// published version number, or the latest published version when empty
+func (m *ServiceMgr) getLibraryRevision(appName, ref string) (app jsonType, label string, info *runtimeInfo) {
+	info = &runtimeInfo{}
+
+	var found bool
+	var err error
+	switch ref {
+	case "draft":
+		app, found, err = m.getLibraryDraft(appName)
+		label = appName + " (draft)"
+
+	case "", "latest":
+		app, found, err = m.getLibraryLatest(appName)
+		label = fmt.Sprintf("%v (version %v)", appName, app.Version)
+
+	default:
+		version, pErr := strconv.ParseUint(ref, 10, 64)
+		if pErr != nil {
+			info.Code = m.statusCodes.errReadReq.Code
+			info.Info = fmt.Sprintf("Invalid revision: %v for library function: %v", ref, appName)
+			return
+		}
+		app, found, err = m.getLibraryVersion(appName, version)
+		label = fmt.Sprintf("%v (version %v)", appName, version)
+	}
+
+	if err != nil {
+		info.Code = m.statusCodes.errGetConfig.Code
+		info.Info = fmt.Sprintf("Failed to read library function: %v, err: %v", appName, err)
+		return
+	} else if !found {
+		info.Code = m.statusCodes.errAppNotFoundTs.Code
+		info.Info = fmt.Sprintf("Revision: %v of library function: %v not found", ref, appName)
+		return
+	}
+
+	info.Code = m.statusCodes.ok.Code
+	return
+}
+
+// Diffs two revisions of a library function, see getLibraryRevision
+func (m *ServiceMgr) diffLibraryRevisions(appName, from, to string) (diff libraryDiff, info *runtimeInfo) {
+	fromApp, fromLabel, info := m.getLibraryRevision(appName, from)
+	if info.Code != m.statusCodes.ok.Code {
+		return
+	}
+
+	toApp, toLabel, info := m.getLibraryRevision(appName, to)
+	if info.Code != m.statusCodes.ok.Code {
+		return
+	}
+
+	diff = libraryDiff{
+		Name:        appName,
+		FromVersion: fromApp.Version,
+		ToVersion:   toApp.Version,
+		Identical:   librarySource(fromApp) == librarySource(toApp),
+		Diff:        unifiedDiff(fromLabel, toLabel, librarySource(fromApp), librarySource(toApp)),
+	}
+	return
+}
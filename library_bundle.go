@@ -0,0 +1,416 @@
+package servicemanager
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/couchbase/eventing/logging"
+	c "github.com/couchbase/indexing/secondary/common"
+)
+
+// Library bundles carry published library functions between clusters,
+// drafts stay behind. Both clusters share library_signing_key of the
+// eventing config, a bundle signed with another key is refused.
+
+// Bumped whenever libraryBundlePayload or jsonType changes, a bundle of
+// another format is refused. Format 1 signed the bundle re-marshalled.
+const libraryBundleFormat = 2
+
+// Stands for library_signing_key in the config returned by GET
+const librarySigningKeyRedacted = "*****"
+
+// Import conflict policies, for functions that exist on this cluster
+const (
+	libraryImportSkip      = "skip"
+	libraryImportOverwrite = "overwrite" // the latest bundled version becomes a new version
+	libraryImportRename    = "rename"    // imported under <name>_imported[_N]
+
+	libraryImportNameAttempts = 100 // names tried for a renamed function
+)
+
+// Version of bundled functions while an import is validated, the one they
+// get is only known once written
+const libraryImportPending = math.MaxUint64
+
+func (m *ServiceMgr) librarySigningKey() (key []byte, info *runtimeInfo) {
+	conf, info := m.getConfig()
+	if info.Code != m.statusCodes.ok.Code {
+		return
+	}
+
+	if conf.LibrarySigningKey == "" {
+		info.Code = m.statusCodes.errGetConfig.Code
+		info.Info = "Library bundles need library_signing_key in the eventing config"
+		return
+	}
+	return []byte(conf.LibrarySigningKey), info
+}
+
+func signLibraryBundle(payload, key []byte) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(payload)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Exports the named library functions, all when appNames is empty
+func (m *ServiceMgr) exportLibraryBundle(appNames []string) (bundle libraryBundle, info *runtimeInfo) {
+	key, info := m.librarySigningKey()
+	if info.Code != m.statusCodes.ok.Code {
+		return
+	}
+
+	if len(appNames) == 0 {
+		for _, app := range m.getLibraryLatestAll() {
+			appNames = append(appNames, app.Name)
+		}
+	}
+	sort.Strings(appNames)
+
+	var payload libraryBundlePayload
+	payload.ExportedAt = time.Now().UTC().Format(time.RFC3339)
+	payload.Functions = make([]libraryBundleFunction, 0, len(appNames))
+
+	for _, appName := range appNames {
+		versions := m.getLibraryVersions(appName)
+		if len(versions) == 0 {
+			info.Code = m.statusCodes.errAppNotFoundTs.Code
+			info.Info = fmt.Sprintf("Library function: %v not found", appName)
+			return
+		}
+
+		for i := range versions {
+			versions[i].Rev = ""
+		}
+		payload.Functions = append(payload.Functions, libraryBundleFunction{Name: appName, Versions: versions})
+	}
+
+	data, err := json.Marshal(&payload)
+	if err != nil {
+		info.Code = m.statusCodes.errMarshalResp.Code
+		info.Info = fmt.Sprintf("Failed to marshal library bundle, err: %v", err)
+		return
+	}
+	bundle.Format = libraryBundleFormat
+	bundle.Payload = string(data)
+	bundle.Signature = signLibraryBundle(data, key)
+
+	logging.Infof("Exported library bundle of %v functions", len(payload.Functions))
+	info.Code = m.statusCodes.ok.Code
+	return
+}
+
+// Checks the format, signature and checksums of a bundle, and returns its
+// payload. The signature covers the payload as received, fields unknown to
+// this format are refused rather than dropped.
+func (m *ServiceMgr) verifyLibraryBundle(bundle libraryBundle) (payload libraryBundlePayload, info *runtimeInfo) {
+	key, info := m.librarySigningKey()
+	if info.Code != m.statusCodes.ok.Code {
+		return
+	}
+
+	info.Code = m.statusCodes.errReadReq.Code
+	if bundle.Format != libraryBundleFormat {
+		info.Info = fmt.Sprintf("Unsupported library bundle format: %v, expected: %v, export it again from a cluster of this version",
+			bundle.Format, libraryBundleFormat)
+		return
+	}
+
+	signature := signLibraryBundle([]byte(bundle.Payload), key)
+	if !hmac.Equal([]byte(signature), []byte(bundle.Signature)) {
+		info.Info = "Library bundle signature does not match, it was altered or signed with another key"
+		return
+	}
+
+	decoder := json.NewDecoder(strings.NewReader(bundle.Payload))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&payload); err != nil {
+		info.Info = fmt.Sprintf("Failed to unmarshal library bundle payload, err: %v", err)
+		return
+	}
+
+	seen := make(map[string]bool)
+	for _, fn := range payload.Functions {
+		if fn.Name == "" || len(fn.Versions) == 0 {
+			info.Info = "Library bundle has a function without name or versions"
+			return
+		} else if seen[fn.Name] {
+			info.Info = fmt.Sprintf("Library bundle has library function: %v more than once", fn.Name)
+			return
+		}
+		seen[fn.Name] = true
+
+		for _, version := range fn.Versions {
+			if version.Name != fn.Name {
+				info.Info = fmt.Sprintf("Library bundle has version: %v of library function: %v under: %v", version.Version, version.Name, fn.Name)
+				return
+			} else if c.JSFunctionHash(version.AppCode) != version.Hash {
+				info.Info = fmt.Sprintf("Checksum of library function: %v version: %v does not match", fn.Name, version.Version)
+				return
+			}
+		}
+	}
+
+	info.Code = m.statusCodes.ok.Code
+	return
+}
+
+// Imports a bundle, every version to write is validated, its requires
+// resolved and the test cases of the function run before any is written.
+// A function new to this cluster gets all its versions, one that exists is
+// handled as per policy. On dry run nothing is written and the result is
+// the plan.
+func (m *ServiceMgr) importLibraryBundle(bundle libraryBundle, policy string, dryRun bool) (result libraryImportResult, info *runtimeInfo) {
+	result.DryRun = dryRun
+
+	switch policy {
+	case "":
+		policy = libraryImportSkip
+	case libraryImportSkip, libraryImportOverwrite, libraryImportRename:
+	default:
+		info = &runtimeInfo{
+			Code: m.statusCodes.errReadReq.Code,
+			Info: fmt.Sprintf("Unknown conflict policy: %v, expected skip, overwrite or rename", policy),
+		}
+		return
+	}
+
+	payload, info := m.verifyLibraryBundle(bundle)
+	if info.Code != m.statusCodes.ok.Code {
+		return
+	}
+	result.Functions = make([]libraryImportAction, 0, len(payload.Functions))
+
+	taken := make(map[string]bool)
+	for _, fn := range payload.Functions {
+		taken[fn.Name] = true
+	}
+
+	writes := make([][]jsonType, len(payload.Functions))
+	failed := false
+
+	for i, fn := range payload.Functions {
+		action := libraryImportAction{Name: fn.Name, ImportedAs: fn.Name, Action: "create"}
+		versions := fn.Versions
+
+		latest, found, err := m.getLibraryLatest(fn.Name)
+		if err != nil {
+			info.Code = m.statusCodes.errGetConfig.Code
+			info.Info = fmt.Sprintf("Failed to read library function: %v, err: %v", fn.Name, err)
+			return
+		}
+
+		if found {
+			newest := versions[len(versions)-1]
+			switch {
+			case latest.Hash == newest.Hash && libraryMetadataEqual(latest, newest):
+				action.Action, versions = "unchanged", nil
+			case policy == libraryImportSkip:
+				action.Action, versions = libraryImportSkip, nil
+			case policy == libraryImportOverwrite:
+				action.Action, versions = libraryImportOverwrite, versions[len(versions)-1:]
+			case policy == libraryImportRename:
+				action.Action = libraryImportRename
+				if action.ImportedAs, err = m.libraryImportName(fn.Name, taken); err != nil {
+					info.Code = m.statusCodes.errGetConfig.Code
+					info.Info = fmt.Sprintf("Failed to name library function: %v on import, err: %v", fn.Name, err)
+					return
+				}
+			}
+		}
+
+		writes[i] = make([]jsonType, 0, len(versions))
+		for _, version := range versions {
+			version.Name = action.ImportedAs
+			if vInfo := m.validateLibraryFunction(version); vInfo.Code != m.statusCodes.ok.Code {
+				m.failLibraryImport(&action, version.Version, vInfo)
+				failed = true
+				break
+			}
+			writes[i] = append(writes[i], version)
+		}
+		action.Versions = len(writes[i])
+		result.Functions = append(result.Functions, action)
+	}
+
+	renamed := make(map[string]string)
+	for _, action := range result.Functions {
+		if action.Action == libraryImportRename {
+			renamed[action.Name] = action.ImportedAs
+		}
+	}
+
+	// Modules first, requires are resolved as they will be once the
+	// versions written before are, and test cases run against the newest
+	mods := libraryImportModules{cluster: clusterLibraryModules{m}, written: make(map[string]jsonType)}
+	for _, i := range libraryImportOrder(payload) {
+		if failed {
+			break
+		}
+		action := &result.Functions[i]
+
+		for _, version := range writes[i] {
+			vInfo := m.checkLibraryImportRequires(version, renamed)
+			if vInfo.Code == m.statusCodes.ok.Code {
+				vInfo = m.resolveLibraryDependenciesIn(&version, mods)
+			}
+			if vInfo.Code != m.statusCodes.ok.Code {
+				m.failLibraryImport(action, version.Version, vInfo)
+				failed = true
+				break
+			}
+
+			version.Version = libraryImportPending
+			mods.written[version.Name] = version
+		}
+		if failed || len(writes[i]) == 0 {
+			continue
+		}
+
+		newest := mods.written[action.ImportedAs]
+		newest.Version = writes[i][len(writes[i])-1].Version
+		if _, vInfo := m.checkLibraryTestsIn(newest, mods); vInfo.Code != m.statusCodes.ok.Code {
+			m.failLibraryImport(action, writes[i][len(writes[i])-1].Version, vInfo)
+			failed = true
+			break
+		}
+	}
+
+	if failed {
+		data, err := json.Marshal(&result)
+		if err != nil {
+			info.Code = m.statusCodes.errMarshalResp.Code
+			info.Info = fmt.Sprintf("Failed to marshal library import result, err: %v", err)
+			return
+		}
+
+		logging.Errorf("Rejected library bundle import: %s", data)
+		info.Code = m.statusCodes.errHandlerCompile.Code
+		info.Info = string(data)
+		return
+	} else if dryRun {
+		info.Code = m.statusCodes.ok.Code
+		return
+	}
+
+	// Modules first, requires are resolved again against this cluster
+	for _, i := range libraryImportOrder(payload) {
+		for _, version := range writes[i] {
+			version.Version, version.Hash, version.Rev = 0, "", ""
+			vInfo := m.resolveLibraryDependencies(&version)
+			if vInfo.Code == m.statusCodes.ok.Code {
+				_, _, vInfo = m.storeLibraryVersion(version)
+			}
+			if vInfo.Code != m.statusCodes.ok.Code {
+				info.Code = vInfo.Code
+				info.Info = fmt.Sprintf("Library bundle import stopped at library function: %v, %v", result.Functions[i].Name, vInfo.Info)
+				return
+			}
+		}
+	}
+
+	logging.Infof("Imported library bundle of %v functions, policy: %v", len(payload.Functions), policy)
+	info.Code = m.statusCodes.ok.Code
+	return
+}
+
+// Returns a name, not used by this cluster nor the bundle, to import a
+// function that exists under
+func (m *ServiceMgr) libraryImportName(appName string, taken map[string]bool) (string, error) {
+	for n := 1; n <= libraryImportNameAttempts; n++ {
+		name := appName + "_imported"
+		if n > 1 {
+			name = fmt.Sprintf("%v_%v", name, n)
+		}
+		if taken[name] {
+			continue
+		}
+
+		_, found, err := m.getLibraryLatest(name)
+		if err != nil {
+			return "", err
+		} else if !found {
+			taken[name] = true
+			return name, nil
+		}
+	}
+	return "", fmt.Errorf("%v names tried", libraryImportNameAttempts)
+}
+
+// Records why a version of a bundle cannot be imported
+func (m *ServiceMgr) failLibraryImport(action *libraryImportAction, version uint64, vInfo *runtimeInfo) {
+	action.Version = version
+	var diags libraryDiagnostics
+	var report libraryTestReport
+	switch {
+	case vInfo.Code != m.statusCodes.errHandlerCompile.Code:
+		action.Error = vInfo.Info
+	case json.Unmarshal([]byte(vInfo.Info), &diags) == nil && diags.Diagnostics != nil:
+		action.Diagnostics = diags.Diagnostics
+	case json.Unmarshal([]byte(vInfo.Info), &report) == nil && report.Cases != nil:
+		action.Tests = &report
+	default:
+		action.Error = vInfo.Info
+	}
+}
+
+// Refuses versions requiring a module of the bundle that the import
+// renames, the require would still name the module of this cluster
+func (m *ServiceMgr) checkLibraryImportRequires(app jsonType, renamed map[string]string) (info *runtimeInfo) {
+	info = &runtimeInfo{Code: m.statusCodes.ok.Code}
+
+	requires, _ := c.JSRequires(app.AppCode)
+	diags := make([]c.JSDiagnostic, 0)
+	for _, req := range requires {
+		if as, ok := renamed[req.Name]; ok {
+			diags = append(diags, c.JSDiagnostic{
+				Severity: c.JSSeverityError,
+				Rule:     c.JSRuleRequire,
+				Message:  fmt.Sprintf("module %v is imported as %v, require it under that name or import with overwrite", req.Name, as),
+				Line:     req.Line,
+				Column:   req.Column,
+			})
+		}
+	}
+	if len(diags) == 0 {
+		return
+	}
+
+	mapLibraryDiagnostics(app, diags)
+	data, err := json.Marshal(&libraryDiagnostics{CompileSuccess: true, Diagnostics: diags})
+	if err != nil {
+		info.Code = m.statusCodes.errMarshalResp.Code
+		info.Info = fmt.Sprintf("Failed to marshal diagnostics of library function: %v, err: %v", app.Name, err)
+		return
+	}
+	info.Code = m.statusCodes.errHandlerCompile.Code
+	info.Info = string(data)
+	return
+}
+
+// The library functions of this cluster as they will be once the versions
+// of a bundle are written, the newest of which shadow those of the cluster
+type libraryImportModules struct {
+	cluster libraryModules
+	written map[string]jsonType // by name imported as
+}
+
+func (im libraryImportModules) latest(name string) (jsonType, bool, error) {
+	if app, ok := im.written[name]; ok {
+		return app, true, nil
+	}
+	return im.cluster.latest(name)
+}
+
+func (im libraryImportModules) load(name string, version uint64) (*c.JSModule, error) {
+	if app, ok := im.written[name]; ok && version == app.Version {
+		return libraryModule(app), nil
+	}
+	return im.cluster.load(name, version)
+}
@@ -0,0 +1,177 @@
+package servicemanager
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+const (
+	diffContextLines = 3
+	diffMaxCells     = 4 * 1024 * 1024 // bounds the LCS table, larger inputs diff as a whole
+)
+
+type diffOp struct {
+	kind byte // ' ', '-' or '+'
+	line string
+}
+
+// Returns a unified diff of two revisions of a library function, empty
+// if they are identical
+func unifiedDiff(fromLabel, toLabel, from, to string) string {
+	if from == to {
+		return ""
+	}
+
+	ops := diffLines(splitLines(from), splitLines(to))
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "--- %s\n+++ %s\n", fromLabel, toLabel)
+
+	for start := 0; start < len(ops); {
+		// Find the next change and the extent of its hunk
+		first := start
+		for first < len(ops) && ops[first].kind == ' ' {
+			first++
+		}
+		if first == len(ops) {
+			break
+		}
+
+		hunkStart := first - diffContextLines
+		if hunkStart < start {
+			hunkStart = start
+		}
+
+		hunkEnd, unchanged := first, 0
+		for i := first; i < len(ops) && unchanged <= 2*diffContextLines; i++ {
+			if ops[i].kind == ' ' {
+				unchanged++
+			} else {
+				unchanged, hunkEnd = 0, i+1
+			}
+		}
+		end := hunkEnd + diffContextLines
+		if end > len(ops) {
+			end = len(ops)
+		}
+
+		fromLine, toLine := 1, 1
+		for _, op := range ops[:hunkStart] {
+			if op.kind != '+' {
+				fromLine++
+			}
+			if op.kind != '-' {
+				toLine++
+			}
+		}
+
+		fromCount, toCount := 0, 0
+		for _, op := range ops[hunkStart:end] {
+			if op.kind != '+' {
+				fromCount++
+			}
+			if op.kind != '-' {
+				toCount++
+			}
+		}
+
+		fmt.Fprintf(&buf, "@@ -%s +%s @@\n", hunkRange(fromLine, fromCount), hunkRange(toLine, toCount))
+		for _, op := range ops[hunkStart:end] {
+			buf.WriteByte(op.kind)
+			buf.WriteString(op.line)
+			buf.WriteByte('\n')
+		}
+
+		start = end
+	}
+
+	return buf.String()
+}
+
+func hunkRange(line, count int) string {
+	if count == 0 {
+		return fmt.Sprintf("%d,0", line-1)
+	} else if count == 1 {
+		return fmt.Sprintf("%d", line)
+	}
+	return fmt.Sprintf("%d,%d", line, count)
+}
+
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(s, "\n"), "\n")
+}
+
+// Computes the edit script from a to b over their longest common
+// subsequence of lines
+func diffLines(a, b []string) []diffOp {
+	// Common prefix and suffix need no table
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+
+	ops := make([]diffOp, 0, len(a)+len(b))
+	for _, line := range a[:prefix] {
+		ops = append(ops, diffOp{' ', line})
+	}
+
+	x, y := a[prefix:len(a)-suffix], b[prefix:len(b)-suffix]
+	if len(x)*len(y) > diffMaxCells {
+		for _, line := range x {
+			ops = append(ops, diffOp{'-', line})
+		}
+		for _, line := range y {
+			ops = append(ops, diffOp{'+', line})
+		}
+	} else {
+		// lcs[i][j] is the LCS length of x[i:] and y[j:]
+		lcs := make([][]int32, len(x)+1)
+		for i := range lcs {
+			lcs[i] = make([]int32, len(y)+1)
+		}
+		for i := len(x) - 1; i >= 0; i-- {
+			for j := len(y) - 1; j >= 0; j-- {
+				if x[i] == y[j] {
+					lcs[i][j] = lcs[i+1][j+1] + 1
+				} else if lcs[i+1][j] >= lcs[i][j+1] {
+					lcs[i][j] = lcs[i+1][j]
+				} else {
+					lcs[i][j] = lcs[i][j+1]
+				}
+			}
+		}
+
+		i, j := 0, 0
+		for i < len(x) && j < len(y) {
+			if x[i] == y[j] {
+				ops = append(ops, diffOp{' ', x[i]})
+				i, j = i+1, j+1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				ops = append(ops, diffOp{'-', x[i]})
+				i++
+			} else {
+				ops = append(ops, diffOp{'+', y[j]})
+				j++
+			}
+		}
+		for ; i < len(x); i++ {
+			ops = append(ops, diffOp{'-', x[i]})
+		}
+		for ; j < len(y); j++ {
+			ops = append(ops, diffOp{'+', y[j]})
+		}
+	}
+
+	for _, line := range a[len(a)-suffix:] {
+		ops = append(ops, diffOp{' ', line})
+	}
+	return ops
+}
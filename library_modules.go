@@ -0,0 +1,221 @@
+package servicemanager
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/couchbase/eventing/logging"
+	c "github.com/couchbase/indexing/secondary/common"
+)
+
+// Library functions marked as module are required by others by name, see
+// c.JSRequires. Requires are resolved when a function is saved, to the
+// version named or else the latest one, and recorded as Dependencies of
+// the version saved, so that what it bundles never changes. Versions
+// other functions depend on are kept by pruneLibraryHistory.
+
+// Library functions requires are resolved against: those of this cluster,
+// or those it will have once a bundle is imported
+type libraryModules interface {
+	latest(name string) (app jsonType, found bool, err error)
+	load(name string, version uint64) (*c.JSModule, error)
+}
+
+type clusterLibraryModules struct {
+	m *ServiceMgr
+}
+
+func (cm clusterLibraryModules) latest(name string) (jsonType, bool, error) {
+	return cm.m.getLibraryLatest(name)
+}
+
+func (cm clusterLibraryModules) load(name string, version uint64) (*c.JSModule, error) {
+	app, found, err := cm.m.getLibraryVersion(name, version)
+	if err != nil || !found {
+		return nil, err
+	}
+	return libraryModule(app), nil
+}
+
+func libraryModule(app jsonType) *c.JSModule {
+	return &c.JSModule{
+		Name:         app.Name,
+		Version:      app.Version,
+		Code:         app.AppCode,
+		Module:       app.Module,
+		Dependencies: app.Dependencies,
+	}
+}
+
+// Resolves the requires of app into app.Dependencies. Missing modules,
+// functions that are not modules and cyclic dependencies are returned as
+// libraryDiagnostics marshalled into info.Info, with errHandlerCompile,
+// against the require they come through.
+func (m *ServiceMgr) resolveLibraryDependencies(app *jsonType) (info *runtimeInfo) {
+	return m.resolveLibraryDependenciesIn(app, clusterLibraryModules{m})
+}
+
+func (m *ServiceMgr) resolveLibraryDependenciesIn(app *jsonType, mods libraryModules) (info *runtimeInfo) {
+	info = &runtimeInfo{}
+
+	requires, diags := c.JSRequires(app.AppCode)
+	deps := make(map[string]uint64)
+
+	for _, req := range requires {
+		diag := c.JSDiagnostic{Severity: c.JSSeverityError, Rule: c.JSRuleRequire, Line: req.Line, Column: req.Column}
+
+		version := req.Version
+		if version == 0 {
+			latest, found, err := mods.latest(req.Name)
+			if err != nil {
+				info.Code = m.statusCodes.errGetConfig.Code
+				info.Info = fmt.Sprintf("Failed to read library function: %v, err: %v", req.Name, err)
+				return
+			} else if !found {
+				diag.Message = fmt.Sprintf("module %v not found", req.Name)
+				diags = append(diags, diag)
+				continue
+			}
+			version = latest.Version
+		}
+
+		if pinned, ok := deps[req.Name]; ok && pinned != version {
+			diag.Message = fmt.Sprintf("module %v is required at versions %v and %v", req.Name, pinned, version)
+			diags = append(diags, diag)
+			continue
+		}
+
+		if _, err := c.ResolveJSModules(app.Name, map[string]uint64{req.Name: version}, mods.load); err != nil {
+			diag.Message = err.Error()
+			diags = append(diags, diag)
+			continue
+		}
+		deps[req.Name] = version
+	}
+
+	if len(diags) > 0 {
+		mapLibraryDiagnostics(*app, diags)
+		data, err := json.Marshal(&libraryDiagnostics{CompileSuccess: true, Diagnostics: diags})
+		if err != nil {
+			info.Code = m.statusCodes.errMarshalResp.Code
+			info.Info = fmt.Sprintf("Failed to marshal diagnostics of library function: %v, err: %v", app.Name, err)
+			return
+		}
+
+		logging.Errorf("Failed to resolve modules of library function: %v, diagnostics: %s", app.Name, data)
+		info.Code = m.statusCodes.errHandlerCompile.Code
+		info.Info = string(data)
+		return
+	}
+
+	app.Dependencies = nil
+	if len(deps) > 0 {
+		app.Dependencies = deps
+	}
+	info.Code = m.statusCodes.ok.Code
+	return
+}
+
+// Returns the code of a library function with the modules it depends on,
+// as projector compiles it
+func (m *ServiceMgr) bundleLibraryFunction(app jsonType) (string, error) {
+	return bundleLibraryFunctionIn(app, clusterLibraryModules{m})
+}
+
+func bundleLibraryFunctionIn(app jsonType, mods libraryModules) (string, error) {
+	modules, err := c.ResolveJSModules(app.Name, app.Dependencies, mods.load)
+	if err != nil {
+		return "", err
+	}
+	return c.BundleJSFunction(app.AppCode, app.Dependencies, modules), nil
+}
+
+// Returns the dependencies of a version of a library function. Versions
+// never change, so they are read from metakv once.
+func (m *ServiceMgr) getLibraryVersionDependencies(name string, version uint64) (map[string]uint64, bool) {
+	key := fmt.Sprintf("%v@%v", name, version)
+	if deps, ok := m.libraryDeps.Load(key); ok {
+		return deps.(map[string]uint64), true
+	}
+
+	app, found, err := m.getLibraryVersion(name, version)
+	if err != nil || !found {
+		return nil, false
+	}
+	m.libraryDeps.Store(key, app.Dependencies)
+	return app.Dependencies, true
+}
+
+// Returns the versions of a library function that the latest version of
+// any function, or the version an index is pinned to, depends on, directly
+// or through other modules
+func (m *ServiceMgr) getLibraryDependencyPins(appName string) map[uint64]bool {
+	pins := make(map[uint64]bool)
+	seen := make(map[string]bool)
+
+	var walk func(deps map[string]uint64)
+	walk = func(deps map[string]uint64) {
+		for name, version := range deps {
+			key := fmt.Sprintf("%v@%v", name, version)
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+
+			if name == appName {
+				pins[version] = true
+			}
+			if deps, found := m.getLibraryVersionDependencies(name, version); found {
+				walk(deps)
+			}
+		}
+	}
+
+	for _, app := range m.getLibraryLatestAll() {
+		walk(app.Dependencies)
+		for _, ref := range m.getLibraryRefs(app.Name) {
+			if deps, found := m.getLibraryVersionDependencies(app.Name, ref.Version); found {
+				walk(deps)
+			}
+		}
+	}
+	return pins
+}
+
+// Orders the functions of a bundle so that modules are imported before
+// the functions of the bundle requiring them
+func libraryImportOrder(bundle libraryBundlePayload) []int {
+	index := make(map[string]int, len(bundle.Functions))
+	for i, fn := range bundle.Functions {
+		index[fn.Name] = i
+	}
+
+	order := make([]int, 0, len(bundle.Functions))
+	visited := make(map[int]bool)
+
+	var visit func(i int)
+	visit = func(i int) {
+		if visited[i] {
+			return
+		}
+		visited[i] = true
+
+		versions := bundle.Functions[i].Versions
+		requires, _ := c.JSRequires(versions[len(versions)-1].AppCode)
+		sort.Slice(requires, func(x, y int) bool {
+			return requires[x].Name < requires[y].Name
+		})
+		for _, req := range requires {
+			if j, ok := index[req.Name]; ok {
+				visit(j)
+			}
+		}
+		order = append(order, i)
+	}
+
+	for i := range bundle.Functions {
+		visit(i)
+	}
+	return order
+}
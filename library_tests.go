@@ -0,0 +1,224 @@
+package servicemanager
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+
+	"github.com/couchbase/eventing/logging"
+	"github.com/couchbase/eventing/util"
+	c "github.com/couchbase/indexing/secondary/common"
+	protobuf "github.com/couchbase/indexing/secondary/protobuf/projector"
+)
+
+// Evaluates code against docs in projector's engine, linked in through
+// the protobuf package. This and compileLibraryCode are the only places
+// service manager runs functions, everything else they take from and
+// return to their callers is of common.
+func tryLibraryCode(name, code, entryPoint string, docs []*c.JSTryDoc) ([]*c.JSTryResult, error) {
+	return protobuf.TryJSFunction(name, code, entryPoint, docs)
+}
+
+// Compiles code in projector's engine, as ValidateFunctionRequest does
+// before an index is created, and returns the compile error if any.
+func compileLibraryCode(code string) *c.JSDiagnostic {
+	v := protobuf.ValidateJS(code)
+	if v.Error == "" {
+		return nil
+	}
+	return &c.JSDiagnostic{
+		Severity: c.JSSeverityError,
+		Rule:     c.JSRuleSyntax,
+		Message:  v.Error,
+		Line:     v.Line,
+		Column:   v.Column + 1, // engine columns are 0-based
+	}
+}
+
+// Test cases of a library function are stored as one list under
+// metakvViewTestsPath/<name>, apart from its versions, and apply to
+// whatever code is saved next. Saving a draft runs them and reports the
+// outcome, publishing is refused while any of them fails.
+
+// Returns the test cases of a library function, none if it has none
+func (m *ServiceMgr) getLibraryTests(appName string) (tests []libraryTestCase, err error) {
+	tests = make([]libraryTestCase, 0)
+
+	data, err := util.MetakvGet(metakvViewTestsPath + appName)
+	if err != nil || data == nil {
+		return
+	}
+	err = json.Unmarshal(data, &tests)
+	return
+}
+
+// Replaces the test cases of a library function
+func (m *ServiceMgr) saveLibraryTests(appName string, tests []libraryTestCase) (info *runtimeInfo) {
+	info = &runtimeInfo{Code: m.statusCodes.errReadReq.Code}
+
+	if len(tests) > c.JSTryMaxDocs {
+		info.Info = fmt.Sprintf("Library function: %v may have at most %v test cases", appName, c.JSTryMaxDocs)
+		return
+	}
+
+	names := make(map[string]bool)
+	for i := range tests {
+		if tests[i].Name == "" || names[tests[i].Name] {
+			info.Info = fmt.Sprintf("Test case %v of library function: %v needs a name of its own", i, appName)
+			return
+		} else if len(tests[i].Doc) == 0 {
+			info.Info = fmt.Sprintf("Test case: %v of library function: %v has no doc", tests[i].Name, appName)
+			return
+		}
+		names[tests[i].Name] = true
+
+		if len(tests[i].Expected) == 0 {
+			tests[i].Expected = json.RawMessage("null")
+		}
+	}
+
+	data, err := json.Marshal(tests)
+	if err != nil {
+		info.Code = m.statusCodes.errMarshalResp.Code
+		info.Info = fmt.Sprintf("Failed to marshal test cases of library function: %v, err: %v", appName, err)
+		return
+	}
+
+	if err = util.MetakvSet(metakvViewTestsPath+appName, data, nil); err != nil {
+		info.Code = m.statusCodes.errSaveAppPs.Code
+		info.Info = fmt.Sprintf("Failed to store test cases of library function: %v, err: %v", appName, err)
+		return
+	}
+
+	logging.Infof("Stored %v test cases of library function: %v", len(tests), appName)
+	info.Code = m.statusCodes.ok.Code
+	info.Info = fmt.Sprintf("Stored %v test cases of library function: %v", len(tests), appName)
+	return
+}
+
+// Runs the test cases of a library function against app, which may be a
+// stored revision or code about to be saved. report is nil when the
+// function has no test cases.
+func (m *ServiceMgr) runLibraryTests(app jsonType) (report *libraryTestReport, err error) {
+	return m.runLibraryTestsIn(app, clusterLibraryModules{m})
+}
+
+func (m *ServiceMgr) runLibraryTestsIn(app jsonType, mods libraryModules) (report *libraryTestReport, err error) {
+	tests, err := m.getLibraryTests(app.Name)
+	if err != nil || len(tests) == 0 {
+		return
+	}
+
+	report = &libraryTestReport{
+		Name:    app.Name,
+		Version: app.Version,
+		Passed:  true,
+		Cases:   make([]libraryTestResult, 0, len(tests)),
+	}
+
+	docs := make([]*c.JSTryDoc, 0, len(tests))
+	for _, test := range tests {
+		docs = append(docs, &c.JSTryDoc{Meta: test.Meta, Doc: test.Doc})
+	}
+
+	entryPoint := c.JSEntryPoints(app.EntryPoints)[0].Name
+	var results []*c.JSTryResult
+	code, tErr := bundleLibraryFunctionIn(app, mods)
+	if tErr == nil {
+		results, tErr = tryLibraryCode(app.Name, code, entryPoint, docs)
+	}
+	if tErr != nil {
+		report.Passed, report.Failures, report.Error = false, len(tests), c.MapJSErrorLines(app.SourceMap, tErr.Error())
+		return
+	}
+
+	for i, test := range tests {
+		res := results[i]
+		result := libraryTestResult{
+			Name:      test.Name,
+			Expected:  test.Expected,
+			Actual:    res.Key,
+			Exception: c.MapJSErrorLines(app.SourceMap, res.Exception),
+			Console:   res.Console,
+		}
+		if len(result.Actual) == 0 {
+			result.Actual = json.RawMessage("null")
+		}
+
+		if test.Exception {
+			result.Passed = res.Exception != ""
+		} else {
+			result.Passed = res.Exception == "" && res.KeyError == "" && jsonEqual(test.Expected, result.Actual)
+		}
+
+		if !result.Passed {
+			report.Passed = false
+			report.Failures++
+		}
+		report.Cases = append(report.Cases, result)
+	}
+	return
+}
+
+// Runs the test cases of a library function on demand against the
+// revision named by ref, see getLibraryRevision
+func (m *ServiceMgr) runLibraryTestsOn(appName, ref string) (report *libraryTestReport, info *runtimeInfo) {
+	app, _, info := m.getLibraryRevision(appName, ref)
+	if info.Code != m.statusCodes.ok.Code {
+		return
+	}
+
+	report, err := m.runLibraryTests(app)
+	if err != nil {
+		info.Code = m.statusCodes.errGetConfig.Code
+		info.Info = fmt.Sprintf("Failed to read test cases of library function: %v, err: %v", appName, err)
+		return
+	} else if report == nil {
+		report = &libraryTestReport{Name: appName, Version: app.Version, Passed: true, Cases: []libraryTestResult{}}
+	}
+	return
+}
+
+// Runs the test cases of a library function about to be published, info
+// is errHandlerCompile with the report marshalled into info.Info if any
+// of them fails
+func (m *ServiceMgr) checkLibraryTests(app jsonType) (report *libraryTestReport, info *runtimeInfo) {
+	return m.checkLibraryTestsIn(app, clusterLibraryModules{m})
+}
+
+func (m *ServiceMgr) checkLibraryTestsIn(app jsonType, mods libraryModules) (report *libraryTestReport, info *runtimeInfo) {
+	info = &runtimeInfo{}
+
+	report, err := m.runLibraryTestsIn(app, mods)
+	if err != nil {
+		info.Code = m.statusCodes.errGetConfig.Code
+		info.Info = fmt.Sprintf("Failed to read test cases of library function: %v, err: %v", app.Name, err)
+		return
+	}
+
+	if report != nil && !report.Passed {
+		data, err := json.Marshal(report)
+		if err != nil {
+			info.Code = m.statusCodes.errMarshalResp.Code
+			info.Info = fmt.Sprintf("Failed to marshal test report of library function: %v, err: %v", app.Name, err)
+			return
+		}
+
+		logging.Errorf("Refused to publish library function: %v, %v of its test cases failed", app.Name, report.Failures)
+		info.Code = m.statusCodes.errHandlerCompile.Code
+		info.Info = string(data)
+		return
+	}
+
+	info.Code = m.statusCodes.ok.Code
+	return
+}
+
+// Compares two JSON values, ignoring formatting and key order
+func jsonEqual(a, b json.RawMessage) bool {
+	var x, y interface{}
+	if json.Unmarshal(a, &x) != nil || json.Unmarshal(b, &y) != nil {
+		return false
+	}
+	return reflect.DeepEqual(x, y)
+}
@@ -0,0 +1,120 @@
+package servicemanager
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/couchbase/eventing/logging"
+	c "github.com/couchbase/indexing/secondary/common"
+	"github.com/evanw/esbuild/pkg/api"
+)
+
+// Library functions in typescript or esnext are authored as Source and
+// transpiled on every save down to the JavaScript level of projector's
+// engine. AppCode holds the generated code, which is what is validated,
+// hashed, tested and run by projector, and SourceMap maps it back to
+// Source. Lines reported against the generated code are mapped back
+// before they reach users.
+
+const (
+	libraryLanguageTypeScript = "typescript"
+	libraryLanguageESNext     = "esnext"
+)
+
+// JavaScript level of projector's engine
+const libraryTranspileTarget = api.ES2015
+
+// Generates AppCode and SourceMap of a typescript or esnext function from
+// its Source, clears them for javascript. Transpile errors are returned as
+// libraryDiagnostics marshalled into info.Info, with errHandlerCompile.
+func (m *ServiceMgr) transpileLibraryFunction(app *jsonType) (info *runtimeInfo) {
+	info = &runtimeInfo{Code: m.statusCodes.ok.Code}
+
+	var loader api.Loader
+	switch app.Language {
+	case "", libraryLanguageDefault:
+		app.Source, app.SourceMap = "", ""
+		return
+	case libraryLanguageTypeScript:
+		loader = api.LoaderTS
+	case libraryLanguageESNext:
+		loader = api.LoaderJS
+	default:
+		info.Code = m.statusCodes.errReadReq.Code
+		info.Info = fmt.Sprintf("Unknown language: %v of library function: %v, expected javascript, typescript or esnext", app.Language, app.Name)
+		return
+	}
+
+	if app.Source == "" {
+		info.Code = m.statusCodes.errReadReq.Code
+		info.Info = fmt.Sprintf("Library function: %v in %v has no source", app.Name, app.Language)
+		return
+	}
+
+	result := api.Transform(app.Source, api.TransformOptions{
+		Loader:     loader,
+		Target:     libraryTranspileTarget,
+		Sourcemap:  api.SourceMapExternal,
+		Sourcefile: app.Name,
+	})
+
+	if len(result.Errors) > 0 {
+		diags := libraryDiagnostics{CompileSuccess: false, Diagnostics: make([]c.JSDiagnostic, 0, len(result.Errors))}
+		for _, msg := range result.Errors {
+			diag := c.JSDiagnostic{Severity: c.JSSeverityError, Rule: c.JSRuleSyntax, Message: msg.Text}
+			if msg.Location != nil {
+				diag.Line, diag.Column = msg.Location.Line, msg.Location.Column+1
+			}
+			diags.Diagnostics = append(diags.Diagnostics, diag)
+		}
+
+		data, err := json.Marshal(&diags)
+		if err != nil {
+			info.Code = m.statusCodes.errMarshalResp.Code
+			info.Info = fmt.Sprintf("Failed to marshal diagnostics of library function: %v, err: %v", app.Name, err)
+			return
+		}
+
+		logging.Errorf("Failed to transpile library function: %v, diagnostics: %s", app.Name, data)
+		info.Code = m.statusCodes.errHandlerCompile.Code
+		info.Info = string(data)
+		return
+	}
+
+	app.AppCode, app.SourceMap = string(result.Code), string(result.Map)
+	return
+}
+
+// Returns the code of a library function as authored
+func librarySource(app jsonType) string {
+	if app.Source != "" {
+		return app.Source
+	}
+	return app.AppCode
+}
+
+// Maps diagnostics of the generated code of a library function back to its
+// source, in place. Diagnostics of code the transpiler added keep their
+// line and say so.
+func mapLibraryDiagnostics(app jsonType, diags []c.JSDiagnostic) {
+	if app.SourceMap == "" {
+		return
+	}
+
+	for i := range diags {
+		if diags[i].Line < 1 {
+			continue
+		}
+
+		column := diags[i].Column - 1
+		if column < 0 {
+			column = 0
+		}
+
+		if line, col, ok := c.JSOriginalPosition(app.SourceMap, diags[i].Line, column); ok {
+			diags[i].Line, diags[i].Column = line, col+1
+		} else {
+			diags[i].Message = fmt.Sprintf("%v (line %v of the generated code)", diags[i].Message, diags[i].Line)
+		}
+	}
+}
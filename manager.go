@@ -126,13 +126,26 @@ func (m *ServiceMgr) initService() {
 	//getViewTempStore -> Get view from temporary store		Function getTempStoreHandler
 	//saveTempApp -> Save to the tempstore	   		Function saveTempStoreHandler
 	//saveViewStore -> save to the view Function		Function savePrimaryStoreHandler
+	//getViewVersions -> List versions of a library function	Function getViewVersionsHandler
+	//saveTempView -> Save the draft of a library function	Function saveTempViewHandler
+	//publishView -> Publish the draft as a new version	Function publishViewHandler
+	//getViewDiff -> Diff of two revisions, latest against draft by default	Function getViewDiffHandler
+	//rollbackView -> Publish an earlier version as a new version	Function rollbackViewHandler
+	//viewTests -> Get, or with POST replace, test cases	Function viewTestsHandler
+	//runViewTests -> Run test cases, a failing one blocks publishing	Function runViewTestsHandler
 	
 	http.HandleFunc("/deleteViewLibrary/", m.deleteLibraryHandler)
 	http.HandleFunc("/deleteViewTempStore/", m.deleteTempLibraryHandler)
 	http.HandleFunc("/getView/", m.getViewStoreHandler)
 	http.HandleFunc("/getTempView/", m.getTempViewHandler)
-	http.HandleFunc("/saveTempView/", m.getTempViewHandler)
+	http.HandleFunc("/saveTempView/", m.saveTempViewHandler)
 	http.HandleFunc("/saveViewAppStore/", m.saveViewStoreHandler)
+	http.HandleFunc("/getViewVersions/", m.getViewVersionsHandler)
+	http.HandleFunc("/publishView/", m.publishViewHandler)
+	http.HandleFunc("/getViewDiff/", m.getViewDiffHandler)
+	http.HandleFunc("/rollbackView/", m.rollbackViewHandler)
+	http.HandleFunc("/viewTests/", m.viewTestsHandler)
+	http.HandleFunc("/runViewTests/", m.runViewTestsHandler)
 
 	// Public REST APIs
 	http.HandleFunc("/api/v1/stats", m.statsHandler)
@@ -140,8 +153,11 @@ func (m *ServiceMgr) initService() {
 	http.HandleFunc("/api/v1/config/", m.configHandler)
 	http.HandleFunc("/api/v1/functions", m.functionsHandler)
 	http.HandleFunc("/api/v1/functions/", m.functionsHandler)
-	http.HandleFunc("/api/v1/Library", m.libraryHandler)	//WRITE IT
+	http.HandleFunc("/api/v1/Library", m.libraryHandler) // ?draft=true for drafts, ?version=N for a published version
 	http.HandleFunc("/api/v1/Library/", m.libraryHandler)
+	http.HandleFunc("/api/v1/export/Library", m.exportLibraryHandler) // ?name= repeated for a subset
+	http.HandleFunc("/api/v1/import/Library", m.importLibraryHandler) // ?dryrun=true&conflict=skip|overwrite|rename
+	http.HandleFunc("/api/v1/try/Library", m.tryLibraryHandler)
 
 	go func() {
 		addr := net.JoinHostPort("", m.adminHTTPPort)